@@ -70,4 +70,29 @@ const (
 	MBC3RamBankEnd   = 0x5FFF
 	MBC3LatchStart   = 0x6000
 	MBC3LatchEnd     = 0x7FFF
+
+	// HuC1 and HuC3
+	HuCRomBankStart = 0x2000
+	HuCRamBankStart = 0x4000
+	HuCUnusedStart  = 0x6000
+
+	// MBC7
+	MBC7RomBankStart    = 0x2000
+	MBC7RamEnable2Start = 0x4000
+	MBC7UnusedStart     = 0x6000
+	MBC7RegisterEnd     = 0xAFFF
+
+	// Pocket Camera
+	CameraRomBankStart = 0x2000
+	CameraRamBankStart = 0x4000
+	CameraUnusedStart  = 0x6000
+
+	// MMM01
+	MMM01RomBankStart  = 0x2000
+	MMM01RamBankStart  = 0x4000
+	MMM01BankModeStart = 0x6000
+
+	// TAMA5
+	TAMA5Data     = 0xA000
+	TAMA5Register = 0xA001
 )
@@ -0,0 +1,110 @@
+package cartridge
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The biggest official ROMs are 8MB, anything much larger is not a ROM
+const maxRomSize = 16 * 1024 * 1024
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1F, 0x8B}
+)
+
+// Reads a ROM from a plain file, a .gz or a .zip, going by the first bytes rather than the
+// extension. entry picks a file in a zip by its name or path, and when empty the first .gb or
+// .gbc file is used
+func ReadRom(romPath string, entry string) ([]byte, error) {
+	data, err := os.ReadFile(romPath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(data, zipMagic):
+		data, err = readZip(data, entry)
+	case bytes.HasPrefix(data, gzipMagic):
+		data, err = readGzip(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", romPath, err)
+	}
+	return data, nil
+}
+
+func readGzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r)
+}
+
+func readZip(data []byte, entry string) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if entry != "" && file.Name != entry && path.Base(file.Name) != entry {
+			continue
+		}
+		if entry == "" && !isRomName(file.Name) {
+			continue
+		}
+
+		r, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readLimited(r)
+	}
+
+	if entry != "" {
+		return nil, fmt.Errorf("no %q in the zip", entry)
+	}
+	return nil, fmt.Errorf("no .gb or .gbc file in the zip")
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxRomSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxRomSize {
+		return nil, fmt.Errorf("ROM is larger than %dMB", maxRomSize/1024/1024)
+	}
+	return data, nil
+}
+
+func isRomName(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".gb" || ext == ".gbc"
+}
+
+// foo.zip, foo.gb.gz and foo.gb all become foo, so saves and patches sit next to them as foo.sav
+// or foo.ips
+func romBase(romPath string) string {
+	base := romPath
+	for _, ext := range []string{".zip", ".gz", ".gbc", ".gb"} {
+		if strings.EqualFold(filepath.Ext(base), ext) {
+			base = base[:len(base)-len(ext)]
+		}
+	}
+	return base
+}
@@ -0,0 +1,222 @@
+package cartridge
+
+import (
+	"image"
+
+	"garboy/addresses"
+)
+
+const (
+	CameraWidth  = 128
+	CameraHeight = 112
+
+	cameraRamSize  = 128 * 1024
+	cameraRegBank  = 0x10  // RAM bank bit that maps the registers instead of RAM
+	cameraRegMask  = 0x7F  // Registers repeat every 128 bytes
+	cameraImage    = 0x100 // The capture goes into the first RAM bank from here, as 16x14 tiles
+	cameraTileSize = 8
+
+	// Registers
+	cameraControl   = 0x00 // Bit 0 starts a capture and reads 1 while busy
+	cameraEdgeMode  = 0x01 // Bits 5-6: none, horizontal, vertical or 2D edge enhancement
+	cameraExposure  = 0x02 // 16 bits, big endian
+	cameraEdgeRatio = 0x04 // Bits 4-6 pick the enhancement ratio, bit 3 inverts the image
+	cameraMatrix    = 0x06 // 4x4 entries of 3 thresholds each, for shades 3, 2 and 1
+	cameraRegCount  = cameraMatrix + 4*4*3
+
+	// Exposure that leaves the source image as it is
+	cameraNeutralExposure = 0x0800
+)
+
+// Edge enhancement strength for each ratio setting, in 1/8ths
+var cameraEdgeRatios = [8]int{2, 3, 4, 5, 8, 12, 16, 20}
+
+// Where camera pictures come from. Frames are CameraWidth x CameraHeight, 0 is black
+type CameraSource interface {
+	Frame() *image.Gray
+}
+
+// Cartridges with a camera
+type cameraCart interface {
+	SetCameraSource(source CameraSource)
+}
+
+// The Pocket Camera: an MBC with 128KB of RAM and the registers of its M64282FP sensor. Captures
+// finish straight away, so a game waiting on the busy bit sees it clear on its next read. The
+// sensor gain isn't emulated
+type Camera struct {
+	rom        []byte
+	ram        []byte
+	romBank    byte
+	ramBank    byte
+	ramEnabled bool
+	regs       [cameraRegCount]byte
+	source     CameraSource
+	dirty      bool
+}
+
+func NewCamera(data []byte, header CartridgeHeader) *Camera {
+	return &Camera{
+		rom:     data,
+		ram:     make([]byte, cameraRamSize),
+		romBank: 1,
+		source:  NewPatternSource(),
+	}
+}
+
+func (m *Camera) SetCameraSource(source CameraSource) {
+	m.source = source
+}
+
+func (m *Camera) Read(address uint16) byte {
+	switch {
+	case address < addresses.RomBank1:
+		return readRom(m.rom, int(address))
+	case address < addresses.Vram:
+		return readRom(m.rom, m.RomBank()*RomBankSize+int(address-addresses.RomBank1))
+	case address >= addresses.ExternalRam && address < addresses.Wram:
+		if m.ramBank&cameraRegBank != 0 {
+			// Only the control register can be read, and captures are never busy
+			if address&cameraRegMask == cameraControl {
+				return m.regs[cameraControl] &^ 0x01
+			}
+			return 0x00
+		}
+		return m.ram[m.ramAddress(address)]
+	default:
+		return 0xFF
+	}
+}
+
+func (m *Camera) RomBank() int {
+	return int(m.romBank)
+}
+
+func (m *Camera) ramAddress(address uint16) int {
+	return int(m.ramBank&0x0F)*RamBankSize + int(address-addresses.ExternalRam)
+}
+
+func (m *Camera) Write(address uint16, val byte) {
+	switch {
+	case address < addresses.CameraRomBankStart:
+		m.ramEnabled = val&0x0F == 0x0A
+	case address < addresses.CameraRamBankStart:
+		m.romBank = val & 0x3F
+	case address < addresses.CameraUnusedStart:
+		m.ramBank = val & 0x1F
+	case address < addresses.Vram:
+		// Nothing is mapped here
+	case address >= addresses.ExternalRam && address < addresses.Wram:
+		if m.ramBank&cameraRegBank != 0 {
+			m.writeRegister(byte(address&cameraRegMask), val)
+			return
+		}
+		if m.ramEnabled {
+			m.ram[m.ramAddress(address)] = val
+			m.dirty = true
+		}
+	}
+}
+
+func (m *Camera) writeRegister(reg byte, val byte) {
+	if int(reg) >= len(m.regs) {
+		return
+	}
+	m.regs[reg] = val
+	if reg == cameraControl && val&0x01 != 0 {
+		m.capture()
+	}
+}
+
+// Exposes, enhances and dithers a frame from the source into RAM
+func (m *Camera) capture() {
+	frame := m.source.Frame()
+	exposure := int(m.regs[cameraExposure])<<8 | int(m.regs[cameraExposure+1])
+
+	var sensor [CameraHeight][CameraWidth]int
+	for y := range sensor {
+		for x := range sensor[y] {
+			sensor[y][x] = int(frame.GrayAt(frame.Rect.Min.X+x, frame.Rect.Min.Y+y).Y) * exposure / cameraNeutralExposure
+		}
+	}
+
+	enhanced := m.enhanceEdges(&sensor)
+	invert := m.regs[cameraEdgeRatio]&0x08 != 0
+	for y := range enhanced {
+		for x := range enhanced[y] {
+			val := min(max(enhanced[y][x], 0), 255)
+			if invert {
+				val = 255 - val
+			}
+			m.setPixel(x, y, m.dither(x, y, val))
+		}
+	}
+	m.dirty = true
+}
+
+// Adds the difference from the neighbours along the enhanced directions
+func (m *Camera) enhanceEdges(sensor *[CameraHeight][CameraWidth]int) *[CameraHeight][CameraWidth]int {
+	mode := (m.regs[cameraEdgeMode] >> 5) & 0x03
+	if mode == 0 {
+		return sensor
+	}
+	ratio := cameraEdgeRatios[(m.regs[cameraEdgeRatio]>>4)&0x07]
+	at := func(x, y int) int {
+		return sensor[min(max(y, 0), CameraHeight-1)][min(max(x, 0), CameraWidth-1)]
+	}
+
+	var out [CameraHeight][CameraWidth]int
+	for y := range out {
+		for x := range out[y] {
+			val := sensor[y][x]
+			diff := 0
+			if mode&0x01 != 0 { // Horizontal
+				diff += 2*val - at(x-1, y) - at(x+1, y)
+			}
+			if mode&0x02 != 0 { // Vertical
+				diff += 2*val - at(x, y-1) - at(x, y+1)
+			}
+			out[y][x] = val + diff*ratio/8
+		}
+	}
+	return &out
+}
+
+// Lighter than all three thresholds of the matrix entry is shade 0, darker than all is shade 3
+func (m *Camera) dither(x, y int, val int) byte {
+	entry := m.regs[cameraMatrix+((y%4)*4+x%4)*3:]
+	shade := byte(3)
+	for _, threshold := range entry[:3] {
+		if val >= int(threshold) {
+			shade--
+		}
+	}
+	return shade
+}
+
+func (m *Camera) setPixel(x, y int, shade byte) {
+	tile := (y/cameraTileSize)*(CameraWidth/cameraTileSize) + x/cameraTileSize
+	offset := cameraImage + tile*16 + (y%cameraTileSize)*2
+	bit := byte(0x80) >> (x % cameraTileSize)
+
+	for plane := 0; plane < 2; plane++ {
+		if shade&(1<<plane) != 0 {
+			m.ram[offset+plane] |= bit
+		} else {
+			m.ram[offset+plane] &^= bit
+		}
+	}
+}
+
+func (m *Camera) SaveData() []byte {
+	m.dirty = false
+	return append([]byte(nil), m.ram...)
+}
+
+func (m *Camera) LoadSaveData(data []byte) {
+	copy(m.ram, data)
+}
+
+func (m *Camera) Unsaved() bool {
+	return m.dirty
+}
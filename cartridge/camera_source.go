@@ -0,0 +1,148 @@
+package cartridge
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ErrNoImages = errors.New("no PNG images in the camera directory")
+
+// Picks a camera source: "pattern" for the generated one, a directory to cycle through its PNGs,
+// or an image file
+func NewCameraSource(spec string) (CameraSource, error) {
+	if spec == "" || spec == "pattern" {
+		return NewPatternSource(), nil
+	}
+	info, err := os.Stat(spec)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return NewDirectorySource(spec)
+	}
+	return NewImageSource(spec)
+}
+
+// Shows the same picture in every capture
+type ImageSource struct {
+	frame *image.Gray
+}
+
+func NewImageSource(path string) (*ImageSource, error) {
+	frame, err := loadCameraImage(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageSource{frame: frame}, nil
+}
+
+func (s *ImageSource) Frame() *image.Gray {
+	return s.frame
+}
+
+// Moves on to the next PNG in the directory, by name, with every capture
+type DirectorySource struct {
+	paths []string
+	next  int
+}
+
+func NewDirectorySource(dir string) (*DirectorySource, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".png") {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, ErrNoImages
+	}
+	sort.Strings(paths)
+	return &DirectorySource{paths: paths}, nil
+}
+
+// Images that fail to load come out black, so a bad file doesn't stop the game
+func (s *DirectorySource) Frame() *image.Gray {
+	path := s.paths[s.next]
+	s.next = (s.next + 1) % len(s.paths)
+
+	frame, err := loadCameraImage(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "camera:", err)
+		return image.NewGray(image.Rect(0, 0, CameraWidth, CameraHeight))
+	}
+	return frame
+}
+
+// Rings around a spot that moves a little with every capture, over a gradient, so there is
+// something to see without any image files
+type PatternSource struct {
+	frames int
+}
+
+func NewPatternSource() *PatternSource {
+	return &PatternSource{}
+}
+
+func (s *PatternSource) Frame() *image.Gray {
+	frame := image.NewGray(image.Rect(0, 0, CameraWidth, CameraHeight))
+	angle := float64(s.frames) * 0.1
+	cx := CameraWidth/2 + 24*math.Cos(angle)
+	cy := CameraHeight/2 + 16*math.Sin(angle)
+	s.frames++
+
+	for y := 0; y < CameraHeight; y++ {
+		for x := 0; x < CameraWidth; x++ {
+			distance := math.Hypot(float64(x)-cx, float64(y)-cy)
+			ring := (math.Cos(distance/4) + 1) / 2
+			gradient := float64(x+y) / float64(CameraWidth+CameraHeight)
+			frame.SetGray(x, y, color.Gray{Y: uint8(255 * (0.7*ring + 0.3*gradient))})
+		}
+	}
+	return frame
+}
+
+// Decodes a PNG, JPEG or GIF and crops and scales it to fill the sensor
+func loadCameraImage(path string) (*image.Gray, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return fitCamera(img), nil
+}
+
+// Nearest neighbour scaling of the middle of the image, keeping its aspect ratio
+func fitCamera(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	scale := min(float64(bounds.Dx())/CameraWidth, float64(bounds.Dy())/CameraHeight)
+	left := bounds.Min.X + int((float64(bounds.Dx())-scale*CameraWidth)/2)
+	top := bounds.Min.Y + int((float64(bounds.Dy())-scale*CameraHeight)/2)
+
+	frame := image.NewGray(image.Rect(0, 0, CameraWidth, CameraHeight))
+	for y := 0; y < CameraHeight; y++ {
+		for x := 0; x < CameraWidth; x++ {
+			src := img.At(left+int((float64(x)+0.5)*scale), top+int((float64(y)+0.5)*scale))
+			frame.Set(x, y, color.GrayModel.Convert(src))
+		}
+	}
+	return frame
+}
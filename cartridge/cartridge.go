@@ -1,34 +1,208 @@
 package cartridge
 
-import "os"
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"garboy/patch"
+	"garboy/romdb"
+)
 
 type Cartridge struct {
-	mbc    MBC
-	header CartridgeHeader
+	mbc       MBC
+	header    CartridgeHeader
+	savePath  string
+	patchPath string
+	match     *romdb.Match
+}
+
+type LoadOptions struct {
+	Entry string // File to run from a zip, by default the first .gb or .gbc
+	Patch string // IPS, UPS or BPS patch, by default foo.ips, foo.ups or foo.bps next to foo.gb
+
+	Database *romdb.Database // Where to look the ROM up, for its name and quirks
 }
 
-type CartridgeHeader struct {
-	CartType uint8
-	RomSize  uint8
-	RamSize  uint8
+// Loads a ROM file, which can also be zipped or gzipped. Checksum mismatches are only warnings,
+// see Header().Warnings
+func NewCartridge(romPath string) (*Cartridge, error) {
+	return NewCartridgeWith(romPath, LoadOptions{})
 }
 
-func NewCartridge(romPath string) *Cartridge {
-	data, err := os.ReadFile(romPath)
+// Patches are applied in memory, the files on disk are never changed. The database lookup uses
+// the ROM from before patching, since that's what DAT files list
+func NewCartridgeWith(romPath string, opts LoadOptions) (*Cartridge, error) {
+	data, err := ReadRom(romPath, opts.Entry)
+	if err != nil {
+		return nil, err
+	}
+
+	var match *romdb.Match
+	if opts.Database != nil {
+		if found, ok := opts.Database.Lookup(data); ok {
+			match = &found
+		}
+	}
+
+	patchPath := opts.Patch
+	if patchPath == "" {
+		patchPath = findPatch(romPath)
+	}
+	if patchPath != "" {
+		if data, err = patch.ApplyFile(data, patchPath); err != nil {
+			return nil, err
+		}
+	}
+
+	header, err := ParseHeader(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", romPath, err)
+	}
+
+	mbc, err := newMBCWithQuirks(data, header, match)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("%s: %w", romPath, err)
 	}
 
-	header := CartridgeHeader{
-		CartType: data[0x147],
-		RomSize:  data[0x148],
-		RamSize:  data[0x149],
+	c := &Cartridge{
+		mbc:       mbc,
+		header:    header,
+		savePath:  romBase(romPath) + ".sav",
+		patchPath: patchPath,
+		match:     match,
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Header() stays as read from the ROM, only the MBC sees the fixes
+func newMBCWithQuirks(data []byte, header CartridgeHeader, match *romdb.Match) (MBC, error) {
+	var quirks romdb.Quirks
+	if match != nil {
+		quirks = match.Quirks()
+	}
+	if quirks.RamSize > 0 {
+		code, ok := ramSizeCode(quirks.RamSize)
+		if !ok {
+			return nil, fmt.Errorf("RAM size quirk of %d bytes is more than any cartridge header gives", quirks.RamSize)
+		}
+		header.RamSize = code
 	}
 
-	return &Cartridge{
-		mbc:    NewMBC(data, header),
-		header: header,
+	mbc, err := NewMBC(data, header)
+	if err != nil {
+		return nil, err
 	}
+	if mbc1, ok := mbc.(interface{ setMulticart() }); ok && quirks.Multicart {
+		mbc1.setMulticart()
+	}
+	return mbc, nil
+}
+
+// Cartridges that keep data without power
+type batteryCart interface {
+	SaveData() []byte
+	LoadSaveData(data []byte)
+	Unsaved() bool
+}
+
+func (c *Cartridge) HasBattery() bool {
+	_, ok := c.mbc.(batteryCart)
+	return ok
+}
+
+// Reads the save file if there is one
+func (c *Cartridge) load() error {
+	battery, ok := c.mbc.(batteryCart)
+	if !ok {
+		return nil
+	}
+	data, err := os.ReadFile(c.savePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	battery.LoadSaveData(data)
+	return nil
+}
+
+// Writes the save file if anything changed since it was loaded or last saved
+func (c *Cartridge) Save() error {
+	battery, ok := c.mbc.(batteryCart)
+	if !ok || !battery.Unsaved() {
+		return nil
+	}
+	return os.WriteFile(c.savePath, battery.SaveData(), 0o644)
+}
+
+func (c *Cartridge) Header() CartridgeHeader {
+	return c.header
+}
+
+// The database entry for the ROM, or nil if it wasn't found or there was no database
+func (c *Cartridge) Match() *romdb.Match {
+	return c.match
+}
+
+// The patch that was applied, or empty if there was none
+func (c *Cartridge) PatchPath() string {
+	return c.patchPath
+}
+
+// Where battery backed RAM belongs, named after the file the ROM was loaded from even if that
+// was an archive
+func (c *Cartridge) SavePath() string {
+	return c.savePath
+}
+
+// The first of foo.ips, foo.ups or foo.bps that exists
+func findPatch(romPath string) string {
+	for _, ext := range []string{".ips", ".ups", ".bps"} {
+		path := romBase(romPath) + ext
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Feeds tilt into MBC7 carts. Returns false if the cartridge has no accelerometer
+func (c *Cartridge) SetAccelerometer(a Accelerometer) bool {
+	cart, ok := c.mbc.(accelerometerCart)
+	if ok {
+		cart.SetAccelerometer(a)
+	}
+	return ok
+}
+
+// Connects the IR port of Hudson carts, e.g. to one end of NewIRLink. Returns false if the
+// cartridge has no IR port
+func (c *Cartridge) SetIR(ir IR) bool {
+	port, ok := c.mbc.(irCart)
+	if ok {
+		port.SetIR(ir)
+	}
+	return ok
+}
+
+func (c *Cartridge) HasCamera() bool {
+	_, ok := c.mbc.(cameraCart)
+	return ok
+}
+
+// Picks where the Pocket Camera's pictures come from. Returns false if the cartridge has no camera
+func (c *Cartridge) SetCameraSource(source CameraSource) bool {
+	camera, ok := c.mbc.(cameraCart)
+	if ok {
+		camera.SetCameraSource(source)
+	}
+	return ok
 }
 
 func (c *Cartridge) Read(address uint16) byte {
@@ -38,3 +212,7 @@ func (c *Cartridge) Read(address uint16) byte {
 func (c *Cartridge) Write(address uint16, val byte) {
 	c.mbc.Write(address, val)
 }
+
+func (c *Cartridge) RomBank() int {
+	return c.mbc.RomBank()
+}
@@ -0,0 +1,175 @@
+package cartridge
+
+// 93LC56 commands are a start bit, 2 opcode bits and 8 address bits. Opcode 00 uses the top two
+// address bits as a sub command
+const (
+	eepromCommandBits = 11
+	eepromWordBits    = 16
+	eepromWords       = 128
+
+	eepromExtended = 0b00
+	eepromWrite    = 0b01
+	eepromRead     = 0b10
+	eepromErase    = 0b11
+
+	eepromDisableWrites = 0b00
+	eepromWriteAll      = 0b01
+	eepromEraseAll      = 0b10
+	eepromEnableWrites  = 0b11
+)
+
+type eepromState int
+
+const (
+	eepromIdle eepromState = iota
+	eepromCommand
+	eepromWriting
+	eepromReading
+)
+
+// A 93LC56 serial EEPROM in 16 bit mode: 128 words, clocked one bit at a time. Writes finish
+// immediately, so DO reads ready as soon as a command is done
+type eeprom struct {
+	words [eepromWords]uint16
+
+	cs, clk, di, do bool
+
+	state        eepromState
+	bits         uint16
+	count        int
+	address      uint8
+	writeAll     bool
+	writeEnabled bool
+	dirty        bool
+}
+
+func newEeprom() *eeprom {
+	e := &eeprom{do: true}
+	for i := range e.words {
+		e.words[i] = 0xFFFF
+	}
+	return e
+}
+
+// Pins as the MBC7 reads them: CS in bit 7, CLK in 6, DI in 1 and DO in 0
+func (e *eeprom) read() byte {
+	val := byte(0)
+	for _, pin := range []struct {
+		set bool
+		bit byte
+	}{{e.cs, 7}, {e.clk, 6}, {e.di, 1}, {e.do, 0}} {
+		if pin.set {
+			val |= 1 << pin.bit
+		}
+	}
+	return val
+}
+
+func (e *eeprom) write(val byte) {
+	cs, clk := val&0x80 != 0, val&0x40 != 0
+	e.di = val&0x02 != 0
+
+	if !cs {
+		e.state = eepromIdle
+		e.do = true
+	} else if clk && !e.clk {
+		e.clock()
+	}
+	e.cs, e.clk = cs, clk
+}
+
+// Rising edge of CLK while selected
+func (e *eeprom) clock() {
+	bit := uint16(0)
+	if e.di {
+		bit = 1
+	}
+
+	switch e.state {
+	case eepromIdle:
+		if e.di {
+			e.state, e.bits, e.count = eepromCommand, 1, 1
+		}
+	case eepromCommand:
+		e.bits = e.bits<<1 | bit
+		e.count++
+		if e.count == eepromCommandBits {
+			e.runCommand(uint8(e.bits>>8)&0x03, uint8(e.bits))
+		}
+	case eepromWriting:
+		e.bits = e.bits<<1 | bit
+		e.count++
+		if e.count == eepromWordBits {
+			if e.writeAll {
+				e.fill(e.bits)
+			} else {
+				e.store(e.address, e.bits)
+			}
+			e.state, e.do = eepromIdle, true
+		}
+	case eepromReading:
+		// Sequential reads carry on into the next word
+		word := e.words[e.address&(eepromWords-1)]
+		e.do = word&(0x8000>>e.count) != 0
+		e.count++
+		if e.count == eepromWordBits {
+			e.address++
+			e.count = 0
+		}
+	}
+}
+
+func (e *eeprom) runCommand(opcode uint8, address uint8) {
+	e.state, e.bits, e.count = eepromIdle, 0, 0
+	e.address = address & (eepromWords - 1)
+
+	switch opcode {
+	case eepromRead:
+		e.state, e.do = eepromReading, false // A dummy 0 comes before the data
+	case eepromWrite:
+		e.state, e.writeAll = eepromWriting, false
+	case eepromErase:
+		e.store(e.address, 0xFFFF)
+	case eepromExtended:
+		switch address >> 6 {
+		case eepromDisableWrites:
+			e.writeEnabled = false
+		case eepromEnableWrites:
+			e.writeEnabled = true
+		case eepromEraseAll:
+			e.fill(0xFFFF)
+		case eepromWriteAll:
+			e.state, e.writeAll = eepromWriting, true
+		}
+	}
+}
+
+func (e *eeprom) store(address uint8, val uint16) {
+	if e.writeEnabled {
+		e.words[address&(eepromWords-1)] = val
+		e.dirty = true
+	}
+}
+
+func (e *eeprom) fill(val uint16) {
+	for i := range e.words {
+		e.store(uint8(i), val)
+	}
+}
+
+// Words are saved little endian, 256 bytes in all
+func (e *eeprom) saveData() []byte {
+	data := make([]byte, 2*eepromWords)
+	for i, word := range e.words {
+		data[2*i], data[2*i+1] = byte(word), byte(word>>8)
+	}
+	return data
+}
+
+func (e *eeprom) loadSaveData(data []byte) {
+	for i := range e.words {
+		if 2*i+1 < len(data) {
+			e.words[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package cartridge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	HeaderStart = 0x0100
+	HeaderEnd   = 0x0150
+
+	logoAddress           = 0x0104
+	titleAddress          = 0x0134
+	manufacturerAddress   = 0x013F
+	cgbFlagAddress        = 0x0143
+	newLicenseeAddress    = 0x0144
+	sgbFlagAddress        = 0x0146
+	cartTypeAddress       = 0x0147
+	romSizeAddress        = 0x0148
+	ramSizeAddress        = 0x0149
+	destinationAddress    = 0x014A
+	oldLicenseeAddress    = 0x014B
+	versionAddress        = 0x014C
+	headerChecksumAddress = 0x014D
+	globalChecksumAddress = 0x014E
+
+	// Old licensee code meaning the new licensee code is used instead, and required for SGB support
+	useNewLicensee = 0x33
+)
+
+var (
+	ErrTruncatedRom = errors.New("file is too short to be a Game Boy ROM")
+	ErrNotGameBoy   = errors.New("no Nintendo logo in the header, not a Game Boy ROM")
+)
+
+// The boot ROM refuses to start a cartridge without this logo at 0x0104
+var nintendoLogo = []byte{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83, 0x00, 0x0C, 0x00, 0x0D,
+	0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E, 0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99,
+	0xBB, 0xBB, 0x67, 0x63, 0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
+// Everything in 0x0100-0x014F after the entry point and logo
+type CartridgeHeader struct {
+	Title            string
+	ManufacturerCode string // Only on later cartridges, empty otherwise
+	CGBFlag          uint8  // 0x80 works on CGB too, 0xC0 is CGB only
+	NewLicensee      string // Two characters, used when OldLicensee is 0x33
+	SGBFlag          uint8  // 0x03 with OldLicensee 0x33 enables SGB functions
+	CartType         uint8
+	RomSize          uint8
+	RamSize          uint8
+	Destination      uint8 // 0x00 is Japan, 0x01 is everywhere else
+	OldLicensee      uint8
+	Version          uint8
+	HeaderChecksum   uint8
+	GlobalChecksum   uint16 // Big endian, unlike everything else
+
+	// What the checksums should be for the data they came from
+	ComputedHeaderChecksum uint8
+	ComputedGlobalChecksum uint16
+}
+
+// Parses the header of a whole ROM image. Checksums aren't checked here, see Warnings
+func ParseHeader(data []byte) (CartridgeHeader, error) {
+	if len(data) < HeaderEnd {
+		return CartridgeHeader{}, ErrTruncatedRom
+	}
+	if !bytes.Equal(data[logoAddress:logoAddress+len(nintendoLogo)], nintendoLogo) {
+		return CartridgeHeader{}, ErrNotGameBoy
+	}
+
+	header := CartridgeHeader{
+		CGBFlag:                data[cgbFlagAddress],
+		NewLicensee:            string(data[newLicenseeAddress : newLicenseeAddress+2]),
+		SGBFlag:                data[sgbFlagAddress],
+		CartType:               data[cartTypeAddress],
+		RomSize:                data[romSizeAddress],
+		RamSize:                data[ramSizeAddress],
+		Destination:            data[destinationAddress],
+		OldLicensee:            data[oldLicenseeAddress],
+		Version:                data[versionAddress],
+		HeaderChecksum:         data[headerChecksumAddress],
+		GlobalChecksum:         uint16(data[globalChecksumAddress])<<8 | uint16(data[globalChecksumAddress+1]),
+		ComputedHeaderChecksum: headerChecksum(data),
+		ComputedGlobalChecksum: globalChecksum(data),
+	}
+
+	// The title was 16 bytes until the CGB took its last byte, and later carts also took 4 more
+	// for the manufacturer code. Older titles can still run into those bytes
+	titleEnd := cgbFlagAddress + 1
+	if header.SupportsCGB() {
+		titleEnd = cgbFlagAddress
+		if code := data[manufacturerAddress:cgbFlagAddress]; isManufacturerCode(code) {
+			header.ManufacturerCode = string(code)
+			titleEnd = manufacturerAddress
+		}
+	}
+	title := data[titleAddress:titleEnd]
+	if end := bytes.IndexByte(title, 0); end >= 0 {
+		title = title[:end]
+	}
+	header.Title = strings.TrimSpace(string(title))
+
+	return header, nil
+}
+
+// Four uppercase letters or digits
+func isManufacturerCode(code []byte) bool {
+	for _, c := range code {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return len(code) == 4
+}
+
+// The boot ROM locks up if this doesn't match 0x014D
+func headerChecksum(data []byte) uint8 {
+	var sum uint8
+	for _, b := range data[titleAddress:headerChecksumAddress] {
+		sum = sum - b - 1
+	}
+	return sum
+}
+
+// Sum of every byte but the checksum itself. Nothing checks it on real hardware
+func globalChecksum(data []byte) uint16 {
+	var sum uint16
+	for i, b := range data {
+		if i != globalChecksumAddress && i != globalChecksumAddress+1 {
+			sum += uint16(b)
+		}
+	}
+	return sum
+}
+
+func (h CartridgeHeader) SupportsCGB() bool {
+	return h.CGBFlag&0x80 != 0
+}
+
+func (h CartridgeHeader) CGBOnly() bool {
+	return h.CGBFlag == 0xC0
+}
+
+func (h CartridgeHeader) SupportsSGB() bool {
+	return h.SGBFlag == 0x03 && h.OldLicensee == useNewLicensee
+}
+
+func (h CartridgeHeader) Japanese() bool {
+	return h.Destination == 0x00
+}
+
+// The new licensee code if the old one says to use it, otherwise the old one in hex
+func (h CartridgeHeader) Licensee() string {
+	if h.OldLicensee == useNewLicensee {
+		return h.NewLicensee
+	}
+	return fmt.Sprintf("%02X", h.OldLicensee)
+}
+
+// Checksum mismatches. Games still run with them, but they usually mean a bad dump or a hack
+func (h CartridgeHeader) Warnings() []string {
+	var warnings []string
+	if h.HeaderChecksum != h.ComputedHeaderChecksum {
+		warnings = append(warnings, fmt.Sprintf("header checksum is %02X, expected %02X (a real Game Boy would not boot this)",
+			h.HeaderChecksum, h.ComputedHeaderChecksum))
+	}
+	if h.GlobalChecksum != h.ComputedGlobalChecksum {
+		warnings = append(warnings, fmt.Sprintf("global checksum is %04X, expected %04X",
+			h.GlobalChecksum, h.ComputedGlobalChecksum))
+	}
+	return warnings
+}
+
+func (h CartridgeHeader) String() string {
+	return fmt.Sprintf("%q type %02X, ROM %dKB, RAM %dKB, version %d, licensee %s",
+		h.Title, h.CartType, getRomSize(h.RomSize)/1024, getRamSize(h.RamSize)/1024, h.Version, h.Licensee())
+}
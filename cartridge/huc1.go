@@ -0,0 +1,93 @@
+package cartridge
+
+import (
+	"garboy/addresses"
+)
+
+// Value written to 0000-1FFF that puts the IR port at A000-BFFF on Hudson carts
+const hudsonIRMode = 0x0E
+
+// Hudson's MBC1 lookalike with an IR port instead of a RAM enable
+type HuC1 struct {
+	rom     []byte
+	ram     []byte
+	romBank byte
+	ramBank byte
+	irMode  bool
+	ir      IR
+	dirty   bool
+}
+
+func NewHuC1(data []byte, header CartridgeHeader) *HuC1 {
+	return &HuC1{
+		rom:     data,
+		ram:     make([]byte, getRamSize(header.RamSize)),
+		romBank: 1,
+		ir:      noIR{},
+	}
+}
+
+func (m *HuC1) SetIR(ir IR) {
+	m.ir = ir
+}
+
+func (m *HuC1) Read(address uint16) byte {
+	switch {
+	case address < addresses.RomBank1:
+		return readRom(m.rom, int(address))
+	case address < addresses.Vram:
+		return readRom(m.rom, m.RomBank()*RomBankSize+int(address-addresses.RomBank1))
+	case address >= addresses.ExternalRam && address < addresses.Wram:
+		if m.irMode {
+			return irRead(m.ir)
+		}
+		if ramAddress := int(m.ramBank)*RamBankSize + int(address-addresses.ExternalRam); ramAddress < len(m.ram) {
+			return m.ram[ramAddress]
+		}
+		return 0xFF
+	default:
+		return 0xFF
+	}
+}
+
+func (m *HuC1) RomBank() int {
+	return int(m.romBank)
+}
+
+func (m *HuC1) Write(address uint16, val byte) {
+	switch {
+	case address < addresses.HuCRomBankStart:
+		m.irMode = val&0x0F == hudsonIRMode
+	case address < addresses.HuCRamBankStart:
+		m.romBank = val & 0x3F
+		if m.romBank == 0 {
+			m.romBank = 1
+		}
+	case address < addresses.HuCUnusedStart:
+		m.ramBank = val & 0x03
+	case address < addresses.Vram:
+		// Nothing is mapped here
+	case address >= addresses.ExternalRam && address < addresses.Wram:
+		if m.irMode {
+			m.ir.SetLED(val&0x01 != 0)
+			return
+		}
+		if ramAddress := int(m.ramBank)*RamBankSize + int(address-addresses.ExternalRam); ramAddress < len(m.ram) {
+			m.ram[ramAddress] = val
+			m.dirty = true
+		}
+	}
+}
+
+func (m *HuC1) SaveData() []byte {
+	m.dirty = false
+	return append([]byte(nil), m.ram...)
+}
+
+func (m *HuC1) LoadSaveData(data []byte) {
+	copy(m.ram, data)
+}
+
+func (m *HuC1) Unsaved() bool {
+	return m.dirty
+}
@@ -0,0 +1,220 @@
+package cartridge
+
+import (
+	"encoding/binary"
+	"time"
+
+	"garboy/addresses"
+)
+
+// What A000-BFFF does, picked by writing to 0000-1FFF. hudsonIRMode selects the IR port
+const (
+	huc3RamRead   = 0x0
+	huc3RamWrite  = 0xA
+	huc3Command   = 0xB // Writes set the command in bits 4-6 and its argument in bits 0-3
+	huc3Response  = 0xC // Reads give the command back with the result in bits 0-3
+	huc3Semaphore = 0xD // Writing bit 0 clear runs the command, reading bit 0 set means done
+)
+
+// RTC commands
+const (
+	huc3ReadNext    = 0x1 // Reads the nibble at the address and moves to the next one
+	huc3WriteNext   = 0x3 // Writes the argument at the address and moves to the next one
+	huc3AddressLow  = 0x4
+	huc3AddressHigh = 0x5
+	huc3Extended    = 0x6
+)
+
+// Arguments of the extended command
+const (
+	huc3LoadTime  = 0x0 // Current time into nibbles 00-06
+	huc3StoreTime = 0x1 // Nibbles 00-06 become the current time
+	huc3Status    = 0x2
+	huc3Tone      = 0xE
+)
+
+const minutesPerDay = 24 * 60
+
+// Saves hold the RAM, then the RTC memory two nibbles to a byte, then clockBase as 64 bit
+// little endian Unix seconds
+const huc3RtcSaveSize = 256/2 + 8
+
+// Hudson's mapper with an RTC, a tone generator and an IR port. The RTC has 256 nibbles of memory
+// where 00-02 hold the minute of the day and 03-05 the day count when the time is loaded
+type HuC3 struct {
+	rom     []byte
+	ram     []byte
+	romBank byte
+	ramBank byte
+	mode    byte
+	ir      IR
+
+	command   byte
+	response  byte
+	address   byte
+	rtcMemory [256]byte
+	clockBase time.Time // When the clock read zero minutes
+	tones     int       // Times the tone generator was triggered, there is no sound to play it on
+	dirty     bool
+}
+
+func NewHuC3(data []byte, header CartridgeHeader) *HuC3 {
+	return &HuC3{
+		rom:       data,
+		ram:       make([]byte, getRamSize(header.RamSize)),
+		romBank:   1,
+		ir:        noIR{},
+		clockBase: time.Now(),
+	}
+}
+
+func (m *HuC3) SetIR(ir IR) {
+	m.ir = ir
+}
+
+func (m *HuC3) Read(address uint16) byte {
+	switch {
+	case address < addresses.RomBank1:
+		return readRom(m.rom, int(address))
+	case address < addresses.Vram:
+		return readRom(m.rom, m.RomBank()*RomBankSize+int(address-addresses.RomBank1))
+	case address >= addresses.ExternalRam && address < addresses.Wram:
+		switch m.mode {
+		case huc3RamRead, huc3RamWrite:
+			if ramAddress := m.ramAddress(address); ramAddress < len(m.ram) {
+				return m.ram[ramAddress]
+			}
+		case huc3Response:
+			return 0x80 | m.command&0x70 | m.response
+		case huc3Semaphore:
+			return 0xFF // Commands finish straight away
+		case hudsonIRMode:
+			return irRead(m.ir)
+		}
+		return 0xFF
+	default:
+		return 0xFF
+	}
+}
+
+func (m *HuC3) RomBank() int {
+	return int(m.romBank)
+}
+
+func (m *HuC3) ramAddress(address uint16) int {
+	return int(m.ramBank)*RamBankSize + int(address-addresses.ExternalRam)
+}
+
+func (m *HuC3) Write(address uint16, val byte) {
+	switch {
+	case address < addresses.HuCRomBankStart:
+		m.mode = val & 0x0F
+	case address < addresses.HuCRamBankStart:
+		m.romBank = val & 0x7F
+		if m.romBank == 0 {
+			m.romBank = 1
+		}
+	case address < addresses.HuCUnusedStart:
+		m.ramBank = val & 0x0F
+	case address < addresses.Vram:
+		// Nothing is mapped here
+	case address >= addresses.ExternalRam && address < addresses.Wram:
+		switch m.mode {
+		case huc3RamWrite:
+			if ramAddress := m.ramAddress(address); ramAddress < len(m.ram) {
+				m.ram[ramAddress] = val
+				m.dirty = true
+			}
+		case huc3Command:
+			m.command = val & 0x7F
+		case huc3Semaphore:
+			if val&0x01 == 0 {
+				m.runCommand()
+			}
+		case hudsonIRMode:
+			m.ir.SetLED(val&0x01 != 0)
+		}
+	}
+}
+
+func (m *HuC3) runCommand() {
+	arg := m.command & 0x0F
+	switch m.command >> 4 {
+	case huc3ReadNext:
+		m.response = m.rtcMemory[m.address]
+		m.address++
+	case huc3WriteNext:
+		m.rtcMemory[m.address] = arg
+		m.address++
+		m.dirty = true
+	case huc3AddressLow:
+		m.address = m.address&0xF0 | arg
+	case huc3AddressHigh:
+		m.address = m.address&0x0F | arg<<4
+	case huc3Extended:
+		switch arg {
+		case huc3LoadTime:
+			m.loadTime()
+		case huc3StoreTime:
+			m.storeTime()
+		case huc3Status:
+			m.response = 0x01
+		case huc3Tone:
+			m.tones++
+		}
+	}
+}
+
+func (m *HuC3) loadTime() {
+	minutes := int(time.Since(m.clockBase) / time.Minute)
+	m.writeNibbles(0, minutes%minutesPerDay)
+	m.writeNibbles(3, minutes/minutesPerDay)
+}
+
+func (m *HuC3) storeTime() {
+	minutes := m.readNibbles(0) + m.readNibbles(3)*minutesPerDay
+	m.clockBase = time.Now().Add(-time.Duration(minutes) * time.Minute)
+	m.dirty = true
+}
+
+// 12 bit values are stored low nibble first
+func (m *HuC3) writeNibbles(start int, val int) {
+	for i := 0; i < 3; i++ {
+		m.rtcMemory[start+i] = byte(val>>(4*i)) & 0x0F
+	}
+}
+
+func (m *HuC3) readNibbles(start int) int {
+	val := 0
+	for i := 0; i < 3; i++ {
+		val |= int(m.rtcMemory[start+i]) << (4 * i)
+	}
+	return val
+}
+
+func (m *HuC3) SaveData() []byte {
+	m.dirty = false
+	data := append([]byte(nil), m.ram...)
+	for i := 0; i < len(m.rtcMemory); i += 2 {
+		data = append(data, m.rtcMemory[i]|m.rtcMemory[i+1]<<4)
+	}
+	return binary.LittleEndian.AppendUint64(data, uint64(m.clockBase.Unix()))
+}
+
+// Saves from before the RTC was saved, or from other emulators without it, keep the clock running
+// from when the game was loaded
+func (m *HuC3) LoadSaveData(data []byte) {
+	copy(m.ram, data)
+	if len(data) < len(m.ram)+huc3RtcSaveSize {
+		return
+	}
+	rtc := data[len(m.ram):]
+	for i := 0; i < len(m.rtcMemory); i += 2 {
+		m.rtcMemory[i], m.rtcMemory[i+1] = rtc[i/2]&0x0F, rtc[i/2]>>4
+	}
+	m.clockBase = time.Unix(int64(binary.LittleEndian.Uint64(rtc[len(m.rtcMemory)/2:])), 0)
+}
+
+func (m *HuC3) Unsaved() bool {
+	return m.dirty
+}
@@ -0,0 +1,50 @@
+package cartridge
+
+import "sync/atomic"
+
+// The infrared port on Hudson carts. The cartridge switches its LED and samples whatever light
+// reaches its receiver
+type IR interface {
+	SetLED(on bool)
+	Light() bool
+}
+
+// Nothing is ever received
+type noIR struct{}
+
+func (noIR) SetLED(on bool) {}
+func (noIR) Light() bool    { return false }
+
+// One end of an infrared link, which sees the light of the other end's LED
+type IREnd struct {
+	led   atomic.Bool
+	other *IREnd
+}
+
+// Two ends facing each other, e.g. for two emulators running side by side
+func NewIRLink() (*IREnd, *IREnd) {
+	a, b := &IREnd{}, &IREnd{}
+	a.other, b.other = b, a
+	return a, b
+}
+
+func (e *IREnd) SetLED(on bool) {
+	e.led.Store(on)
+}
+
+func (e *IREnd) Light() bool {
+	return e.other.led.Load()
+}
+
+// Cartridges with an IR port
+type irCart interface {
+	SetIR(ir IR)
+}
+
+// What A000-BFFF reads in IR mode: 0xC1 when light is received, otherwise 0xC0
+func irRead(ir IR) byte {
+	if ir.Light() {
+		return 0xC1
+	}
+	return 0xC0
+}
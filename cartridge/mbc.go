@@ -1,5 +1,7 @@
 package cartridge
 
+import "fmt"
+
 const (
 	RomBankSize = 0x4000
 	RamBankSize = 0x2000
@@ -8,21 +10,58 @@ const (
 type MBC interface {
 	Read(addr uint16) uint8
 	Write(addr uint16, value uint8)
+	RomBank() int // Bank currently mapped at 0x4000-0x7FFF
+}
+
+// The cartridge type byte at 0x0147 names a mapper this emulator doesn't have
+type ErrUnsupportedMBC struct {
+	Type uint8
 }
 
-func NewMBC(rom []uint8, header CartridgeHeader) MBC {
+func (e ErrUnsupportedMBC) Error() string {
+	return fmt.Sprintf("unsupported cartridge type %02X", e.Type)
+}
+
+func NewMBC(rom []uint8, header CartridgeHeader) (MBC, error) {
+	if hasMMM01Menu(rom) {
+		return newMMM01(rom, header), nil
+	}
 	switch header.CartType {
 	case 0x00:
-		return NewMBC0(rom, header)
-	case 0x01, 0x02, 0x03:
-		return NewMBC1(rom, header)
-	case 0x0F, 0x10, 0x11, 0x12, 0x13:
-		return NewMBC3(rom, header)
+		return NewMBC0(rom, header), nil
+	case 0x01, 0x02:
+		return NewMBC1(rom, header), nil
+	case 0x03:
+		return &batteryMBC1{NewMBC1(rom, header)}, nil
+	case 0x0B, 0x0C, 0x0D:
+		return newMMM01(rom, header), nil
+	case 0x11, 0x12:
+		return NewMBC3(rom, header), nil
+	case 0x0F, 0x10, 0x13:
+		return &batteryMBC3{NewMBC3(rom, header)}, nil
+	case 0x22:
+		return NewMBC7(rom, header), nil
+	case 0xFC:
+		return NewCamera(rom, header), nil
+	case 0xFD:
+		return NewTAMA5(rom, header), nil
+	case 0xFE:
+		return NewHuC3(rom, header), nil
+	case 0xFF:
+		return NewHuC1(rom, header), nil
 	default:
-		panic("Unsupported MBC type")
+		return nil, ErrUnsupportedMBC{header.CartType}
 	}
 }
 
+// Reads past the end of the ROM see an open bus
+func readRom(rom []byte, offset int) byte {
+	if offset < len(rom) {
+		return rom[offset]
+	}
+	return 0xFF
+}
+
 func getRomSize(romSizeCode uint8) int {
 	switch romSizeCode {
 	case 0x00: // 32KB
@@ -48,6 +87,17 @@ func getRomSize(romSizeCode uint8) int {
 	}
 }
 
+// The smallest header RAM size code with room for size bytes, false when no code has
+func ramSizeCode(size int) (uint8, bool) {
+	best, ok := uint8(0x04), false
+	for code := uint8(0x00); code <= 0x05; code++ {
+		if getRamSize(code) >= size && getRamSize(code) <= getRamSize(best) {
+			best, ok = code, true
+		}
+	}
+	return best, ok
+}
+
 func getRamSize(ramSizeCode uint8) int {
 	switch ramSizeCode {
 	case 0x00: // No RAM
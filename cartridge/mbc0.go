@@ -13,8 +13,11 @@ type MBC0 struct {
 
 func NewMBC0(romData []uint8, header CartridgeHeader) *MBC0 {
 	ramSize := getRamSize(header.RamSize)
+	// Anything past the end of a short ROM reads as open bus
 	rom := make([]byte, 0x8000)
-	copy(rom, romData)
+	for i := copy(rom, romData); i < len(rom); i++ {
+		rom[i] = 0xFF
+	}
 
 	return &MBC0{
 		rom:     memory.NewROM(rom),
@@ -28,22 +31,26 @@ func (m *MBC0) Read(address uint16) uint8 {
 	case address <= addresses.RomBankXEnd:
 		return m.rom.Read(address)
 	case address >= addresses.RamStart && address <= addresses.RamEnd:
-		if m.ramSize > 0 {
-			return m.ram.Read(address - addresses.RamStart)
+		if offset := int(address - addresses.RamStart); offset < m.ramSize {
+			return m.ram.Read(uint16(offset))
 		}
 		return 0xFF
 	default:
-		panic("Reading from MBC0 at an invalid address")
+		return 0xFF
 	}
 }
 
+func (m *MBC0) RomBank() int {
+	return 1
+}
+
 func (m *MBC0) Write(address uint16, val uint8) {
 	switch {
 	case address <= addresses.RomBankXEnd:
 		return
 	case address >= addresses.RamStart && address <= addresses.RamEnd:
-		if m.ramSize > 0 {
-			m.ram.Write(address-addresses.RamStart, val)
+		if offset := int(address - addresses.RamStart); offset < m.ramSize {
+			m.ram.Write(uint16(offset), val)
 		}
 	}
 }
@@ -1,17 +1,30 @@
 package cartridge
 
 import (
+	"bytes"
+
 	"garboy/addresses"
 )
 
+const (
+	// MBC1M multicarts are 1MB with a game every 256KB
+	multicartSize     = 1024 * 1024
+	multicartGameSize = 256 * 1024
+)
+
 type MBC1 struct {
 	rom        []byte
 	ram        []byte
-	romBank    byte
-	ramBank    byte
+	romBank    byte // Lower 5 bits of the ROM bank
+	ramBank    byte // 2 bits used as the RAM bank or the upper ROM bank bits
 	ramEnabled bool
 	bankMode   byte
 	hasRam     bool
+	dirty      bool
+
+	romBanks int
+	// MBC1M wires only 4 bits of romBank, so ramBank selects one of four 256KB games
+	multicart bool
 }
 
 func NewMBC1(data []byte, header CartridgeHeader) *MBC1 {
@@ -22,6 +35,8 @@ func NewMBC1(data []byte, header CartridgeHeader) *MBC1 {
 		ramEnabled: false,
 		bankMode:   0,
 		hasRam:     header.CartType == 0x02 || header.CartType == 0x03,
+		romBanks:   romBankCount(data, header),
+		multicart:  isMulticart(data),
 	}
 
 	if mbc.hasRam {
@@ -32,45 +47,85 @@ func NewMBC1(data []byte, header CartridgeHeader) *MBC1 {
 	return mbc
 }
 
+// A power of two, so unconnected bank bits can be masked off. Goes by the file when the header
+// says less
+func romBankCount(data []byte, header CartridgeHeader) int {
+	banks := 2
+	for banks*RomBankSize < max(getRomSize(header.RomSize), len(data)) {
+		banks *= 2
+	}
+	return banks
+}
+
+// Each game in a multicart has its own header, so there's a Nintendo logo at the start of the
+// second 256KB as well as the first
+func isMulticart(data []byte) bool {
+	if len(data) != multicartSize {
+		return false
+	}
+	logo := data[multicartGameSize+logoAddress:]
+	return bytes.HasPrefix(logo, nintendoLogo)
+}
+
+// For multicarts a database knows about but isMulticart can't spot
+func (m *MBC1) setMulticart() {
+	m.multicart = true
+}
+
+// Where ramBank goes in the ROM bank number
+func (m *MBC1) upperShift() int {
+	if m.multicart {
+		return 4
+	}
+	return 5
+}
+
+// Banks past the end of the ROM wrap around, since the unused bank bits aren't connected
+func (m *MBC1) readBank(bank int, address uint16) byte {
+	bank &= m.romBanks - 1
+	return readRom(m.rom, bank*RomBankSize+int(address))
+}
+
 func (m *MBC1) Read(address uint16) byte {
 	switch {
 	case address < addresses.MBC1RamBankStart:
-		bankOffset := 0
+		bank := 0
 		if m.bankMode == 1 {
-			bankOffset = int(m.ramBank) << 5
+			bank = int(m.ramBank) << m.upperShift()
 		}
-		return m.rom[bankOffset*RomBankSize+int(address)]
+		return m.readBank(bank, address)
 	case address < addresses.Vram:
-		actualBank := m.romBank
-		if m.bankMode == 0 {
-			actualBank |= m.ramBank << 5
-		}
-		bankOffset := int(actualBank) * RomBankSize
-		romAddress := bankOffset + int(address-addresses.MBC1RamBankStart)
-		if romAddress < len(m.rom) {
-			return m.rom[romAddress]
-		}
-		return 0xFF
+		return m.readBank(m.RomBank(), address-addresses.MBC1RamBankStart)
 	case address >= addresses.ExternalRam && address < addresses.Wram:
-		if !m.ramEnabled || !m.hasRam {
+		if !m.ramEnabled || len(m.ram) == 0 {
 			return 0xFF
 		}
-
-		actualRamBank := byte(0)
-		if m.bankMode == 1 {
-			actualRamBank = m.ramBank
-		}
-
-		ramAddress := int(actualRamBank)*RamBankSize + int(address-addresses.ExternalRam)
-		if ramAddress < len(m.ram) {
-			return m.ram[ramAddress]
-		}
-		return 0xFF
+		return m.ram[m.ramAddress(address)]
 	default:
 		return 0xFF
 	}
 }
 
+// The 0 to 1 translation only looks at the 5 bit register, so banks 0x20, 0x40 and 0x60 can't be
+// mapped here, but 0x10 on a multicart can
+func (m *MBC1) RomBank() int {
+	bank := int(m.romBank)
+	if m.multicart {
+		bank &= 0x0F
+	}
+	bank |= int(m.ramBank) << m.upperShift()
+	return bank & (m.romBanks - 1)
+}
+
+// Only mode 1 banks RAM. Smaller chips repeat through the whole area
+func (m *MBC1) ramAddress(address uint16) int {
+	bank := 0
+	if m.bankMode == 1 {
+		bank = int(m.ramBank)
+	}
+	return (bank*RamBankSize + int(address-addresses.ExternalRam)) % len(m.ram)
+}
+
 func (m *MBC1) Write(address uint16, val byte) {
 	switch {
 	case address < addresses.MBC1RomBankStart:
@@ -86,18 +141,28 @@ func (m *MBC1) Write(address uint16, val byte) {
 	case address < addresses.Vram:
 		m.bankMode = val & 0x01
 	case address >= addresses.ExternalRam && address < addresses.Wram:
-		if !m.ramEnabled || !m.hasRam {
+		if !m.ramEnabled || len(m.ram) == 0 {
 			return
 		}
+		m.ram[m.ramAddress(address)] = val
+		m.dirty = true
+	}
+}
 
-		actualRamBank := byte(0)
-		if m.bankMode == 1 {
-			actualRamBank = m.ramBank
-		}
+// Type 0x03 is MBC1+RAM+BATTERY, the only MBC1 that keeps its RAM in a .sav
+type batteryMBC1 struct {
+	*MBC1
+}
 
-		ramAddress := int(actualRamBank)*RamBankSize + int(address-0xA000)
-		if ramAddress < len(m.ram) {
-			m.ram[ramAddress] = val
-		}
-	}
+func (m *batteryMBC1) SaveData() []byte {
+	m.dirty = false
+	return append([]byte(nil), m.ram...)
+}
+
+func (m *batteryMBC1) LoadSaveData(data []byte) {
+	copy(m.ram, data)
+}
+
+func (m *batteryMBC1) Unsaved() bool {
+	return m.dirty
 }
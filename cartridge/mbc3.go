@@ -1,6 +1,7 @@
 package cartridge
 
 import (
+	"encoding/binary"
 	"time"
 
 	"garboy/addresses"
@@ -20,8 +21,12 @@ type MBC3 struct {
 	rtcLatchData byte
 	rtcBaseTime  time.Time
 	rtcHalt      bool
+	dirty        bool
 }
 
+// The RTC registers and the unix seconds the clock counts from, after the RAM in a save
+const mbc3RtcSaveSize = 5 + 8
+
 func NewMBC3(data []byte, header CartridgeHeader) *MBC3 {
 	mbc := &MBC3{
 		rom:         data,
@@ -44,14 +49,10 @@ func NewMBC3(data []byte, header CartridgeHeader) *MBC3 {
 func (m *MBC3) Read(address uint16) byte {
 	switch {
 	case address < addresses.RomBank1:
-		return m.rom[address]
+		return readRom(m.rom, int(address))
 	case address < addresses.Vram:
 		bankOffset := int(m.romBank) * RomBankSize
-		romAddress := bankOffset + int(address-addresses.RomBank1)
-		if romAddress < len(m.rom) {
-			return m.rom[romAddress]
-		}
-		return 0xFF
+		return readRom(m.rom, bankOffset+int(address-addresses.RomBank1))
 	case address >= addresses.ExternalRam && address < addresses.Wram:
 		if !m.ramEnabled {
 			return 0xFF
@@ -75,6 +76,10 @@ func (m *MBC3) Read(address uint16) byte {
 	}
 }
 
+func (m *MBC3) RomBank() int {
+	return int(m.romBank)
+}
+
 func (m *MBC3) Write(address uint16, val byte) {
 	switch {
 	case address < addresses.MBC3RomBankStart:
@@ -106,6 +111,7 @@ func (m *MBC3) Write(address uint16, val byte) {
 			ramAddress := int(m.ramBank)*RamBankSize + int(address-addresses.ExternalRam)
 			if ramAddress < len(m.ram) {
 				m.ram[ramAddress] = val
+				m.dirty = true
 			}
 		} else if m.ramBank >= 0x08 && m.ramBank <= 0x0C && m.hasTimer {
 			m.writeRTC(m.ramBank-0x08, val)
@@ -147,6 +153,7 @@ func (m *MBC3) latchRTC() {
 
 func (m *MBC3) writeRTC(reg byte, val byte) {
 	m.rtcRegs[reg] = val
+	m.dirty = true
 
 	switch reg {
 	case 4: // Days high
@@ -156,3 +163,35 @@ func (m *MBC3) writeRTC(reg byte, val byte) {
 		}
 	}
 }
+
+// Types 0x0F, 0x10 and 0x13 have a battery. It keeps the RAM and, on the timer carts, the clock
+type batteryMBC3 struct {
+	*MBC3
+}
+
+func (m *batteryMBC3) SaveData() []byte {
+	m.dirty = false
+	data := append([]byte(nil), m.ram...)
+	if !m.hasTimer {
+		return data
+	}
+	data = append(data, m.rtcRegs[:]...)
+	return binary.LittleEndian.AppendUint64(data, uint64(m.rtcBaseTime.Unix()))
+}
+
+// Saves without the clock, from before it was saved or from other emulators, keep it running
+// from when the game was loaded
+func (m *batteryMBC3) LoadSaveData(data []byte) {
+	copy(m.ram, data)
+	if !m.hasTimer || len(data) < len(m.ram)+mbc3RtcSaveSize {
+		return
+	}
+	rtc := data[len(m.ram):]
+	copy(m.rtcRegs[:], rtc)
+	m.rtcHalt = m.rtcRegs[4]&0x40 != 0
+	m.rtcBaseTime = time.Unix(int64(binary.LittleEndian.Uint64(rtc[len(m.rtcRegs):])), 0)
+}
+
+func (m *batteryMBC3) Unsaved() bool {
+	return m.dirty
+}
@@ -0,0 +1,169 @@
+package cartridge
+
+import (
+	"math"
+	"sync/atomic"
+
+	"garboy/addresses"
+)
+
+const (
+	// Both RAM enables must be written before the registers at A000-AFFF appear
+	mbc7RamEnable1 = 0x0A
+	mbc7RamEnable2 = 0x40
+
+	mbc7EraseLatch = 0x55
+	mbc7Latch      = 0xAA
+
+	// Accelerometer readings when level, and how far 1g moves them
+	accelerometerCenter = 0x81D0
+	accelerometerPerG   = 0x70
+	// Erased latches read this until the next latch
+	accelerometerErased = 0x8000
+)
+
+// Tilt in g on each axis, positive x to the right and positive y towards the player. About
+// -1 to 1 covers how far a Game Boy is tilted in play
+type Accelerometer interface {
+	Tilt() (x, y float64)
+}
+
+// An Accelerometer that reports whatever was last set, for tests and other input code
+type TiltSensor struct {
+	x, y atomic.Uint64
+}
+
+func (t *TiltSensor) Set(x, y float64) {
+	t.x.Store(math.Float64bits(x))
+	t.y.Store(math.Float64bits(y))
+}
+
+func (t *TiltSensor) Tilt() (float64, float64) {
+	return math.Float64frombits(t.x.Load()), math.Float64frombits(t.y.Load())
+}
+
+// Cartridges with an accelerometer
+type accelerometerCart interface {
+	SetAccelerometer(a Accelerometer)
+}
+
+// Kirby Tilt 'n' Tumble's mapper: an accelerometer and a 93LC56 EEPROM behind registers at
+// A000-AFFF, repeated every 256 bytes
+type MBC7 struct {
+	rom       []byte
+	romBank   byte
+	ramEnable [2]bool
+
+	accelerometer Accelerometer
+	erased        bool
+	x, y          uint16
+
+	eeprom *eeprom
+}
+
+func NewMBC7(data []byte, header CartridgeHeader) *MBC7 {
+	return &MBC7{
+		rom:           data,
+		romBank:       1,
+		accelerometer: &TiltSensor{},
+		x:             accelerometerErased,
+		y:             accelerometerErased,
+		eeprom:        newEeprom(),
+	}
+}
+
+func (m *MBC7) SetAccelerometer(a Accelerometer) {
+	m.accelerometer = a
+}
+
+func (m *MBC7) registersEnabled() bool {
+	return m.ramEnable[0] && m.ramEnable[1]
+}
+
+func (m *MBC7) Read(address uint16) byte {
+	switch {
+	case address < addresses.RomBank1:
+		return readRom(m.rom, int(address))
+	case address < addresses.Vram:
+		return readRom(m.rom, m.RomBank()*RomBankSize+int(address-addresses.RomBank1))
+	case address >= addresses.ExternalRam && address <= addresses.MBC7RegisterEnd:
+		if !m.registersEnabled() {
+			return 0xFF
+		}
+		switch (address >> 4) & 0x0F {
+		case 0x2:
+			return byte(m.x)
+		case 0x3:
+			return byte(m.x >> 8)
+		case 0x4:
+			return byte(m.y)
+		case 0x5:
+			return byte(m.y >> 8)
+		case 0x6:
+			return 0x00
+		case 0x8:
+			return m.eeprom.read()
+		}
+		return 0xFF
+	default:
+		return 0xFF
+	}
+}
+
+func (m *MBC7) RomBank() int {
+	return int(m.romBank)
+}
+
+func (m *MBC7) Write(address uint16, val byte) {
+	switch {
+	case address < addresses.MBC7RomBankStart:
+		m.ramEnable[0] = val == mbc7RamEnable1
+	case address < addresses.MBC7RamEnable2Start:
+		m.romBank = val & 0x7F
+	case address < addresses.MBC7UnusedStart:
+		m.ramEnable[1] = val == mbc7RamEnable2
+	case address >= addresses.ExternalRam && address <= addresses.MBC7RegisterEnd:
+		if !m.registersEnabled() {
+			return
+		}
+		switch (address >> 4) & 0x0F {
+		case 0x0:
+			if val == mbc7EraseLatch {
+				m.erased = true
+				m.x, m.y = accelerometerErased, accelerometerErased
+			}
+		case 0x1:
+			if val == mbc7Latch && m.erased {
+				m.erased = false
+				m.latch()
+			}
+		case 0x8:
+			m.eeprom.write(val)
+		}
+	}
+}
+
+func (m *MBC7) latch() {
+	x, y := m.accelerometer.Tilt()
+	m.x = accelerometerValue(x)
+	m.y = accelerometerValue(y)
+}
+
+func accelerometerValue(g float64) uint16 {
+	val := accelerometerCenter + g*accelerometerPerG
+	return uint16(min(max(val, 0), math.MaxUint16))
+}
+
+func (m *MBC7) SaveData() []byte {
+	m.eeprom.dirty = false
+	return m.eeprom.saveData()
+}
+
+func (m *MBC7) LoadSaveData(data []byte) {
+	m.eeprom.loadSaveData(data)
+}
+
+// True when the EEPROM changed since the last SaveData
+func (m *MBC7) Unsaved() bool {
+	return m.eeprom.dirty
+}
@@ -0,0 +1,185 @@
+package cartridge
+
+import (
+	"bytes"
+
+	"garboy/addresses"
+)
+
+// The menu of an MMM01 compilation is in the last 32KB, and so is the header that names the
+// mapper. The header at the start of the file belongs to the first game
+const mmm01MenuSize = 2 * RomBankSize
+
+func isMMM01Type(cartType uint8) bool {
+	return cartType >= 0x0B && cartType <= 0x0D
+}
+
+func hasMMM01Menu(data []byte) bool {
+	if len(data) < mmm01MenuSize {
+		return false
+	}
+	menu := data[len(data)-mmm01MenuSize:]
+	return isMMM01Type(menu[cartTypeAddress]) && bytes.HasPrefix(menu[logoAddress:], nintendoLogo)
+}
+
+// The MMM01 multicart mapper. It starts unmapped, showing the menu in the last 32KB, with every
+// register writable. The menu sets up where a game lives and which bank bits it may change, then
+// maps it in, after which the game sees something like an MBC1 and the outer bits are locked. The
+// multiplex bit that swaps ROM and RAM bank lines for MBC1 style games is ignored
+type MMM01 struct {
+	rom        []byte
+	ram        []byte
+	ramEnabled bool
+	mapped     bool
+	dirty      bool
+
+	romLow     byte // RB0-4, written by the game
+	romMid     byte // RB5-6
+	romHigh    byte // RB7-8
+	romMask    byte // RB1-4 bits the game can't change
+	ramLow     byte // RA13-14, written by the game
+	ramHigh    byte // RA15-16
+	ramMask    byte // RA13-14 bits the game can't change
+	bankMode   byte
+	modeLocked bool
+
+	romBanks int
+}
+
+func NewMMM01(data []byte, header CartridgeHeader) *MMM01 {
+	ramSize := header.RamSize
+	if hasMMM01Menu(data) {
+		ramSize = data[len(data)-mmm01MenuSize+ramSizeAddress]
+	}
+	return &MMM01{
+		rom:      data,
+		ram:      make([]byte, getRamSize(ramSize)),
+		romBanks: romBankCount(data, header),
+	}
+}
+
+func (m *MMM01) Read(address uint16) byte {
+	switch {
+	case address < addresses.RomBank1:
+		return m.readBank(m.romBank0(), address)
+	case address < addresses.Vram:
+		return m.readBank(m.RomBank(), address-addresses.RomBank1)
+	case address >= addresses.ExternalRam && address < addresses.Wram:
+		if !m.ramEnabled || len(m.ram) == 0 {
+			return 0xFF
+		}
+		return m.ram[m.ramAddress(address)]
+	default:
+		return 0xFF
+	}
+}
+
+func (m *MMM01) readBank(bank int, address uint16) byte {
+	bank &= m.romBanks - 1
+	return readRom(m.rom, bank*RomBankSize+int(address))
+}
+
+// The outer bank bits, with only the masked bits of RB0-4
+func (m *MMM01) outerBank() int {
+	return int(m.romHigh)<<7 | int(m.romMid)<<5 | int(m.romLow&m.romMask)
+}
+
+func (m *MMM01) romBank0() int {
+	if !m.mapped {
+		return m.romBanks - 2
+	}
+	return m.outerBank()
+}
+
+// Like the MBC1, bank 0 becomes 1, but only the bits the game controls are checked
+func (m *MMM01) RomBank() int {
+	if !m.mapped {
+		return m.romBanks - 1
+	}
+	inner := m.romLow &^ m.romMask
+	if inner == 0 {
+		inner = 1
+	}
+	return (m.outerBank() | int(inner)) & (m.romBanks - 1)
+}
+
+// In mode 0 the game's RAM bank bits read as 0
+func (m *MMM01) ramAddress(address uint16) int {
+	low := m.ramLow & m.ramMask
+	if m.bankMode == 1 {
+		low = m.ramLow
+	}
+	bank := int(m.ramHigh)<<2 | int(low)
+	return (bank*RamBankSize + int(address-addresses.ExternalRam)) % len(m.ram)
+}
+
+func (m *MMM01) Write(address uint16, val byte) {
+	switch {
+	case address < addresses.MMM01RomBankStart:
+		m.ramEnabled = val&0x0F == 0x0A
+		if !m.mapped {
+			m.ramMask = (val >> 4) & 0x03
+			m.mapped = val&0x40 != 0
+		}
+	case address < addresses.MMM01RamBankStart:
+		if m.mapped {
+			m.romLow = m.romLow&m.romMask | val&0x1F&^m.romMask
+			return
+		}
+		m.romLow = val & 0x1F
+		m.romMid = (val >> 5) & 0x03
+	case address < addresses.MMM01BankModeStart:
+		if m.mapped {
+			m.ramLow = m.ramLow&m.ramMask | val&0x03&^m.ramMask
+			return
+		}
+		m.ramLow = val & 0x03
+		m.ramHigh = (val >> 2) & 0x03
+		m.romHigh = (val >> 4) & 0x03
+		m.modeLocked = val&0x40 != 0
+	case address < addresses.Vram:
+		if !m.mapped {
+			m.romMask = ((val >> 2) & 0x0F) << 1
+		}
+		if !m.mapped || !m.modeLocked {
+			m.bankMode = val & 0x01
+		}
+	case address >= addresses.ExternalRam && address < addresses.Wram:
+		if m.ramEnabled && len(m.ram) > 0 {
+			m.ram[m.ramAddress(address)] = val
+			m.dirty = true
+		}
+	}
+}
+
+// Type 0x0D is MMM01+RAM+BATTERY. Only that type keeps its RAM in a .sav, so it gets the
+// batteryCart methods while the other MMM01 types don't
+type batteryMMM01 struct {
+	*MMM01
+}
+
+// The type byte comes from the menu's header when there is one
+func newMMM01(data []byte, header CartridgeHeader) MBC {
+	cartType := header.CartType
+	if hasMMM01Menu(data) {
+		cartType = data[len(data)-mmm01MenuSize+cartTypeAddress]
+	}
+	m := NewMMM01(data, header)
+	if cartType == 0x0D {
+		return &batteryMMM01{m}
+	}
+	return m
+}
+
+func (m *batteryMMM01) SaveData() []byte {
+	m.dirty = false
+	return append([]byte(nil), m.ram...)
+}
+
+func (m *batteryMMM01) LoadSaveData(data []byte) {
+	copy(m.ram, data)
+}
+
+func (m *batteryMMM01) Unsaved() bool {
+	return m.dirty
+}
@@ -0,0 +1,255 @@
+package cartridge
+
+import (
+	"encoding/binary"
+	"time"
+
+	"garboy/addresses"
+)
+
+// TAMA5 registers, selected by writing to A001 and accessed a nibble at a time through A000
+const (
+	tama5BankLow     = 0x0
+	tama5BankHigh    = 0x1
+	tama5WriteLow    = 0x4
+	tama5WriteHigh   = 0x5
+	tama5AddressHigh = 0x6 // Bit 0 is address bit 4, bits 1-3 the command
+	tama5AddressLow  = 0x7 // Writing this runs the command
+	tama5Registers   = 0x8
+	tama5Ready       = 0xA // Reads bit 0 set when the MCU can take a command
+	tama5ReadLow     = 0xC
+	tama5ReadHigh    = 0xD
+)
+
+// Commands, run when the low address nibble is written
+const (
+	tama5RamWrite = 0x0
+	tama5RamRead  = 0x1
+	tama5RtcRead  = 0x2
+	tama5RtcWrite = 0x4
+)
+
+const tama5RamSize = 32
+
+// Saves hold the RAM, then the RTC digits, then when they were last brought up to date as 64 bit
+// little endian Unix seconds
+const tama5RtcSaveSize = tama5RtcCount + 8
+
+// RTC registers in the TC8521 layout, one BCD digit each. Two digit fields are the ones
+// followed by the tens
+const (
+	tama5Seconds  = 0x0
+	tama5Minutes  = 0x2
+	tama5Hours    = 0x4
+	tama5Weekday  = 0x6
+	tama5Day      = 0x7
+	tama5Month    = 0x9
+	tama5Year     = 0xB
+	tama5RtcCount = 0xD
+)
+
+// Bandai's TAMA5, used by Tamagotchi 3. A TAMA6 microcontroller sits behind two registers at
+// A000-A001 and holds 32 bytes of battery backed memory and an RTC. Commands finish straight away
+type TAMA5 struct {
+	rom       []byte
+	ram       [tama5RamSize]byte
+	romBank   byte
+	selected  byte
+	registers [tama5Registers]byte
+	rtc       [tama5RtcCount]byte // Digits as the game last saw or wrote them
+	rtcBase   time.Time           // Host time the digits were last advanced to
+	dirty     bool
+}
+
+func NewTAMA5(data []byte, header CartridgeHeader) *TAMA5 {
+	now := time.Now()
+	return &TAMA5{
+		rom:     data,
+		romBank: 1,
+		rtc:     rtcFields(now.Local()),
+		rtcBase: now,
+	}
+}
+
+func (m *TAMA5) Read(address uint16) byte {
+	switch {
+	case address < addresses.RomBank1:
+		return readRom(m.rom, int(address))
+	case address < addresses.Vram:
+		return readRom(m.rom, m.RomBank()*RomBankSize+int(address-addresses.RomBank1))
+	case address == addresses.TAMA5Data:
+		switch m.selected {
+		case tama5Ready:
+			return 0xF1
+		case tama5ReadLow:
+			return 0xF0 | m.result()&0x0F
+		case tama5ReadHigh:
+			return 0xF0 | m.result()>>4
+		}
+		return 0xF1
+	default:
+		return 0xFF
+	}
+}
+
+func (m *TAMA5) RomBank() int {
+	return int(m.romBank)
+}
+
+func (m *TAMA5) address() byte {
+	return (m.registers[tama5AddressHigh]&0x01)<<4 | m.registers[tama5AddressLow]
+}
+
+func (m *TAMA5) command() byte {
+	return m.registers[tama5AddressHigh] >> 1
+}
+
+// What the last read command returns
+func (m *TAMA5) result() byte {
+	switch m.command() {
+	case tama5RamRead:
+		return m.ram[m.address()]
+	case tama5RtcRead:
+		return m.rtcRegister(m.address() & 0x0F)
+	}
+	return 0x00
+}
+
+func (m *TAMA5) Write(address uint16, val byte) {
+	switch address {
+	case addresses.TAMA5Register:
+		m.selected = val & 0x0F
+	case addresses.TAMA5Data:
+		if m.selected >= tama5Registers {
+			return
+		}
+		m.registers[m.selected] = val & 0x0F
+		switch m.selected {
+		case tama5BankLow, tama5BankHigh:
+			m.romBank = (m.registers[tama5BankHigh]&0x01)<<4 | m.registers[tama5BankLow]
+		case tama5AddressLow:
+			m.runCommand()
+		}
+	}
+}
+
+func (m *TAMA5) runCommand() {
+	val := m.registers[tama5WriteHigh]<<4 | m.registers[tama5WriteLow]
+	switch m.command() {
+	case tama5RamWrite:
+		m.ram[m.address()] = val
+		m.dirty = true
+	case tama5RtcWrite:
+		m.setRtcRegister(m.address()&0x0F, val&0x0F)
+	}
+}
+
+func (m *TAMA5) rtcRegister(reg byte) byte {
+	if reg >= tama5RtcCount {
+		return 0x00
+	}
+	m.advance()
+	return m.rtc[reg]
+}
+
+// Digits are stored as written, even out of range ones, and only carry as the clock ticks
+func (m *TAMA5) setRtcRegister(reg byte, digit byte) {
+	if reg >= tama5RtcCount {
+		return
+	}
+	m.advance()
+	m.rtc[reg] = digit
+	m.dirty = true
+}
+
+// Ticks the digits forward by the whole seconds the host clock moved since rtcBase
+func (m *TAMA5) advance() {
+	elapsed := time.Since(m.rtcBase) / time.Second
+	if elapsed <= 0 {
+		return
+	}
+	m.rtcBase = m.rtcBase.Add(elapsed * time.Second)
+	m.tick(int(elapsed))
+}
+
+func (m *TAMA5) tick(seconds int) {
+	field := func(reg int) int { return int(m.rtc[reg+1])*10 + int(m.rtc[reg]) }
+	setField := func(reg int, val int) { m.rtc[reg], m.rtc[reg+1] = byte(val%10), byte(val/10%10) }
+
+	minutes := (field(tama5Seconds) + seconds) / 60
+	setField(tama5Seconds, (field(tama5Seconds)+seconds)%60)
+	if minutes == 0 {
+		return
+	}
+	hours := (field(tama5Minutes) + minutes) / 60
+	setField(tama5Minutes, (field(tama5Minutes)+minutes)%60)
+	if hours == 0 {
+		return
+	}
+	days := (field(tama5Hours) + hours) / 24
+	setField(tama5Hours, (field(tama5Hours)+hours)%24)
+
+	for ; days > 0; days-- {
+		m.rtc[tama5Weekday] = (m.rtc[tama5Weekday] + 1) % 7
+		day, month, year := field(tama5Day)+1, field(tama5Month), field(tama5Year)
+		if day > daysInMonth(month, year) {
+			day, month = 1, month+1
+			if month > 12 {
+				month, year = 1, (year+1)%100
+			}
+		}
+		setField(tama5Day, day)
+		setField(tama5Month, month)
+		setField(tama5Year, year)
+	}
+}
+
+// The TC8521 counts every fourth year as a leap year
+func daysInMonth(month, year int) int {
+	switch month {
+	case 2:
+		if year%4 == 0 {
+			return 29
+		}
+		return 28
+	case 4, 6, 9, 11:
+		return 30
+	}
+	return 31
+}
+
+func rtcFields(t time.Time) [tama5RtcCount]byte {
+	var fields [tama5RtcCount]byte
+	digits := func(reg int, val int) {
+		fields[reg], fields[reg+1] = byte(val%10), byte(val/10%10)
+	}
+	digits(tama5Seconds, t.Second())
+	digits(tama5Minutes, t.Minute())
+	digits(tama5Hours, t.Hour())
+	fields[tama5Weekday] = byte(t.Weekday())
+	digits(tama5Day, t.Day())
+	digits(tama5Month, int(t.Month()))
+	digits(tama5Year, t.Year()%100)
+	return fields
+}
+
+func (m *TAMA5) SaveData() []byte {
+	m.dirty = false
+	m.advance()
+	data := append(append([]byte(nil), m.ram[:]...), m.rtc[:]...)
+	return binary.LittleEndian.AppendUint64(data, uint64(m.rtcBase.Unix()))
+}
+
+// Saves without the RTC, from before it was saved or from other emulators, keep the host time
+func (m *TAMA5) LoadSaveData(data []byte) {
+	copy(m.ram[:], data)
+	if len(data) < tama5RamSize+tama5RtcSaveSize {
+		return
+	}
+	copy(m.rtc[:], data[tama5RamSize:])
+	m.rtcBase = time.Unix(int64(binary.LittleEndian.Uint64(data[tama5RamSize+tama5RtcCount:])), 0)
+}
+
+func (m *TAMA5) Unsaved() bool {
+	return m.dirty
+}
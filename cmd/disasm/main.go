@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"garboy/disasm"
+	"garboy/symbols"
+)
+
+func main() {
+	bank := flag.Int("bank", -1, "only disassemble this ROM bank")
+	symPath := flag.String("sym", "", "RGBDS .sym or .map file (default: next to the ROM)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: disasm [-bank n] [-sym file] rom.gb\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rom, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var table *symbols.Table
+	if *symPath != "" {
+		table, err = symbols.Load(*symPath)
+	} else {
+		table, err = symbols.LoadForROM(flag.Arg(0))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	analysis := disasm.Analyze(rom)
+	analysis.SetSymbols(table)
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	banks := []int{*bank}
+	if *bank < 0 {
+		banks = banks[:0]
+		for b := 0; b < analysis.Banks(); b++ {
+			banks = append(banks, b)
+		}
+	}
+
+	for _, b := range banks {
+		if err := analysis.WriteBank(out, b); err != nil {
+			out.Flush()
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(out)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"garboy/trace"
+)
+
+func main() {
+	context := flag.Int("context", 10, "matching lines to show before the divergence")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: tracediff [-context n] ours.log reference.log\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ours, err := trace.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer ours.Close()
+
+	reference, err := trace.Open(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer reference.Close()
+
+	divergence, err := trace.Diff(ours, reference, *context)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if divergence == nil {
+		fmt.Println("Traces match")
+		return
+	}
+
+	divergence.Write(os.Stdout)
+	os.Exit(1)
+}
@@ -2,6 +2,7 @@ package cpu
 
 import (
 	"fmt"
+	"io"
 
 	"garboy/interrupts"
 	"garboy/memory"
@@ -33,6 +34,9 @@ type CPU struct {
 	interruptMasterEnable bool // IME
 
 	imeDelay uint8
+
+	labeler func(address uint16) string
+	tracer  func(c *CPU)
 }
 
 func NewCPU(mmu mmu.MmuInterface, interrupts *interrupts.Interrupts) *CPU {
@@ -62,6 +66,10 @@ func (c *CPU) Step() uint16 {
 		return HaltedCycles
 	}
 
+	if c.tracer != nil {
+		c.tracer(c)
+	}
+
 	opcode := c.fetch()
 	instruction := c.decode(opcode)
 	return c.execute(instruction)
@@ -176,11 +184,45 @@ func (c *CPU) GetState() (memory.Register8, memory.Register8, memory.Register8,
 		c.reg.pc
 }
 
-func (c *CPU) PrintState() {
+func (c *CPU) IsHalted() bool {
+	return c.halted
+}
+
+func (c *CPU) InterruptMasterEnable() bool {
+	return c.interruptMasterEnable
+}
+
+// Names addresses in PrintState, e.g. with labels from a symbol file
+func (c *CPU) SetLabeler(labeler func(address uint16) string) {
+	c.labeler = labeler
+}
+
+// Called before every instruction is fetched. Interrupt dispatches and halted steps are skipped
+func (c *CPU) SetTracer(tracer func(c *CPU)) {
+	c.tracer = tracer
+}
+
+// Writes one line in the Gameboy Doctor log format
+func (c *CPU) WriteState(w io.Writer) error {
 	pc := c.reg.pc.Read()
-	fmt.Printf("[CPU] A:%.2X F:%.2X B:%.2X C:%.2X D:%.2X E:%.2X H:%.2X L:%.2X SP:%.4X PC:%.4X PCMEM:%02X,%02X,%02X,%02X\n",
+	_, err := fmt.Fprintf(w, "A:%.2X F:%.2X B:%.2X C:%.2X D:%.2X E:%.2X H:%.2X L:%.2X SP:%.4X PC:%.4X PCMEM:%02X,%02X,%02X,%02X\n",
 		c.reg.a.Read(), c.reg.f.Read(), c.reg.b.Read(), c.reg.c.Read(), c.reg.d.Read(), c.reg.e.Read(), c.reg.h.Read(),
 		c.reg.l.Read(), c.reg.sp.Read(), pc, c.byteAt(pc).Read(), c.byteAt(pc+1).Read(), c.byteAt(pc+2).Read(), c.byteAt(pc+3).Read())
+	return err
+}
+
+func (c *CPU) PrintState() {
+	pc := c.reg.pc.Read()
+	label := ""
+	if c.labeler != nil {
+		if name := c.labeler(pc); name != "" {
+			label = " (" + name + ")"
+		}
+	}
+
+	fmt.Printf("[CPU] A:%.2X F:%.2X B:%.2X C:%.2X D:%.2X E:%.2X H:%.2X L:%.2X SP:%.4X PC:%.4X PCMEM:%02X,%02X,%02X,%02X%s\n",
+		c.reg.a.Read(), c.reg.f.Read(), c.reg.b.Read(), c.reg.c.Read(), c.reg.d.Read(), c.reg.e.Read(), c.reg.h.Read(),
+		c.reg.l.Read(), c.reg.sp.Read(), pc, c.byteAt(pc).Read(), c.byteAt(pc+1).Read(), c.byteAt(pc+2).Read(), c.byteAt(pc+3).Read(), label)
 }
 
 func (c *CPU) PrintStateDecimal() {
@@ -694,12 +694,14 @@ func (i *Instruction) ld_imm16_sp(c *CPU) {
 func (i *Instruction) inc_r16(c *CPU) {
 	r16 := c.getRegister16(i.Opcode, []int{5, 4})
 
+	c.mmu.IncDec16(r16.Read())
 	r16.Increment()
 }
 
 func (i *Instruction) dec_r16(c *CPU) {
 	r16 := c.getRegister16(i.Opcode, []int{5, 4})
 
+	c.mmu.IncDec16(r16.Read())
 	r16.Decrement()
 }
 
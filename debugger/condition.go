@@ -0,0 +1,224 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Evaluated before stopping at a conditional breakpoint
+type Condition func(d *Debugger) bool
+
+type operand func(d *Debugger) uint16
+
+// Parses conditions such as "A == $3F", "[HL] != 0 && BC > C000" or "[FF44] >= 90 || fZ == 1".
+// Registers (A-L, AF-HL, SP, PC) and flags take precedence over hex numbers, so use $A or $FC for the numbers.
+// Flags are written as fZ, fN, fH and fC to avoid clashing with registers H and C.
+func ParseCondition(expr string) (Condition, error) {
+	p := &conditionParser{tokens: tokenize(expr)}
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in condition", p.tokens[p.pos])
+	}
+	return cond, nil
+}
+
+type conditionParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *conditionParser) parseOr() (Condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(d *Debugger) bool { return l(d) || right(d) }
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (Condition, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "&&" {
+		p.next()
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(d *Debugger) bool { return l(d) && right(d) }
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseComparison() (Condition, error) {
+	if p.peek() == "(" {
+		p.next()
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing ) in condition")
+		}
+		return cond, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.next()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "==":
+		return func(d *Debugger) bool { return left(d) == right(d) }, nil
+	case "!=":
+		return func(d *Debugger) bool { return left(d) != right(d) }, nil
+	case "<":
+		return func(d *Debugger) bool { return left(d) < right(d) }, nil
+	case "<=":
+		return func(d *Debugger) bool { return left(d) <= right(d) }, nil
+	case ">":
+		return func(d *Debugger) bool { return left(d) > right(d) }, nil
+	case ">=":
+		return func(d *Debugger) bool { return left(d) >= right(d) }, nil
+	default:
+		return nil, fmt.Errorf("expected comparison operator, got %q", op)
+	}
+}
+
+func (p *conditionParser) parseOperand() (operand, error) {
+	tok := p.next()
+
+	if tok == "[" {
+		address, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != "]" {
+			return nil, fmt.Errorf("missing ] in condition")
+		}
+		return func(d *Debugger) uint16 { return uint16(d.ReadMemory(address(d))) }, nil
+	}
+
+	upper := strings.ToUpper(tok)
+	if len(upper) == 2 && upper[0] == 'F' {
+		if _, ok := flagBits[upper[1:]]; ok {
+			return func(d *Debugger) uint16 {
+				set, _ := d.Flag(upper[1:])
+				if set {
+					return 1
+				}
+				return 0
+			}, nil
+		}
+	}
+
+	if isRegisterName(upper) {
+		return func(d *Debugger) uint16 {
+			val, _ := d.Register(upper)
+			return val
+		}, nil
+	}
+
+	val, err := ParseValue(tok)
+	if err != nil {
+		return nil, err
+	}
+	return func(d *Debugger) uint16 { return val }, nil
+}
+
+func isRegisterName(name string) bool {
+	switch name {
+	case "A", "F", "B", "C", "D", "E", "H", "L", "AF", "BC", "DE", "HL", "SP", "PC":
+		return true
+	}
+	return false
+}
+
+// Numbers are hexadecimal by default ($FF, 0xFF and FF are equivalent). Prefix with # for decimal
+func ParseValue(tok string) (uint16, error) {
+	base := 16
+	switch {
+	case strings.HasPrefix(tok, "$"):
+		tok = tok[1:]
+	case strings.HasPrefix(tok, "0x"), strings.HasPrefix(tok, "0X"):
+		tok = tok[2:]
+	case strings.HasPrefix(tok, "#"):
+		tok = tok[1:]
+		base = 10
+	}
+
+	val, err := strconv.ParseUint(tok, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", tok)
+	}
+	return uint16(val), nil
+}
+
+func tokenize(expr string) []string {
+	var tokens []string
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.ContainsRune("([])", r):
+			tokens = append(tokens, string(r))
+			i++
+		case strings.ContainsRune("=!<>&|", r):
+			j := i + 1
+			for j < len(runes) && strings.ContainsRune("=&|", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("([])=!<>&|", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		}
+	}
+	return tokens
+}
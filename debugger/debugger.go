@@ -0,0 +1,623 @@
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"garboy/cpu"
+	"garboy/disasm"
+	"garboy/display"
+	"garboy/interrupts"
+	"garboy/memory"
+	"garboy/mmu"
+	"garboy/scheduler"
+	"garboy/symbols"
+	"garboy/utils"
+)
+
+type StopReason int
+
+const (
+	StopStep StopReason = iota
+	StopBreakpoint
+	StopWatchpoint
+	StopInterrupted
+)
+
+type WatchKind uint8
+
+const (
+	WatchRead  WatchKind = 1 << 0
+	WatchWrite WatchKind = 1 << 1
+	WatchAny             = WatchRead | WatchWrite
+)
+
+var interruptVectors = []uint16{
+	interrupts.VBlankInterruptSource,
+	interrupts.StatInterruptSource,
+	interrupts.TimerInterruptSource,
+	interrupts.SerialInterruptSource,
+	interrupts.JoypadInterruptSource,
+}
+
+type Breakpoint struct {
+	Address   uint16
+	Bank      int // Only used for 4000-7FFF, symbols.AnyBank matches every bank
+	Condition string
+	Hits      int
+
+	cond Condition
+}
+
+type Watchpoint struct {
+	Address uint16
+	Kind    WatchKind
+	Hits    int
+}
+
+// A call made by CALL, RST or an interrupt dispatch that hasn't returned yet
+type Frame struct {
+	Caller    uint16
+	Target    uint16
+	Interrupt bool
+}
+
+type StopEvent struct {
+	Reason     StopReason
+	PC         uint16
+	Breakpoint *Breakpoint
+	Watchpoint *Watchpoint
+	Access     WatchKind
+	Address    uint16
+	Value      uint8
+}
+
+type Debugger struct {
+	cpu       *cpu.CPU
+	mmu       *mmu.MMU
+	scheduler *scheduler.Scheduler
+
+	breakpoints map[uint16][]*Breakpoint
+	watchpoints map[uint16]*Watchpoint
+	callStack   []Frame
+	symbols     *symbols.Table
+	ppu         *display.PPU
+
+	pendingWatch *StopEvent
+	inspecting   bool
+	interrupted  atomic.Bool
+
+	lastCycles   uint16
+	lastOpcode   uint8
+	lastExecuted bool
+
+	cyclesPerFrame int
+	timePerFrame   time.Duration
+}
+
+func NewDebugger(cpu *cpu.CPU, mmu *mmu.MMU, scheduler *scheduler.Scheduler) *Debugger {
+	d := &Debugger{
+		cpu:         cpu,
+		mmu:         mmu,
+		scheduler:   scheduler,
+		breakpoints: make(map[uint16][]*Breakpoint),
+		watchpoints: make(map[uint16]*Watchpoint),
+	}
+	mmu.SetAccessHook(d)
+	return d
+}
+
+// Limits Continue to real hardware speed so a window running alongside stays watchable
+func (d *Debugger) SetFrameLimit(cyclesPerFrame int, timePerFrame time.Duration) {
+	d.cyclesPerFrame = cyclesPerFrame
+	d.timePerFrame = timePerFrame
+}
+
+// Labels used for breakpoints by name and when printing addresses
+func (d *Debugger) SetSymbols(table *symbols.Table) {
+	d.symbols = table
+}
+
+// Enables the oam and vram commands
+func (d *Debugger) SetPPU(ppu *display.PPU) {
+	d.ppu = ppu
+}
+
+// Names address as "Label" or "Label+offset" using the current ROM bank
+func (d *Debugger) Label(address uint16) string {
+	return d.symbols.Label(address, d.mmu.RomBank())
+}
+
+// Formats an address for output, with its label when there is one
+func (d *Debugger) Describe(address uint16) string {
+	if label := d.Label(address); label != "" {
+		return fmt.Sprintf("%04X (%s)", address, label)
+	}
+	return fmt.Sprintf("%04X", address)
+}
+
+// Stops whenever PC reaches address, in any ROM bank
+func (d *Debugger) AddBreakpoint(address uint16, condition string) (*Breakpoint, error) {
+	return d.AddBankedBreakpoint(symbols.AnyBank, address, condition)
+}
+
+// Stops when PC reaches address while bank is mapped at 4000-7FFF
+func (d *Debugger) AddBankedBreakpoint(bank int, address uint16, condition string) (*Breakpoint, error) {
+	if address < 0x4000 || address > 0x7FFF {
+		bank = symbols.AnyBank
+	}
+	bp := &Breakpoint{Address: address, Bank: bank, Condition: condition}
+
+	if condition != "" {
+		cond, err := ParseCondition(condition)
+		if err != nil {
+			return nil, err
+		}
+		bp.cond = cond
+	}
+
+	existing := d.breakpoints[address]
+	for i, other := range existing {
+		if other.Bank == bank {
+			existing[i] = bp
+			return bp, nil
+		}
+	}
+	d.breakpoints[address] = append(existing, bp)
+	return bp, nil
+}
+
+// Stops at the address of a label from the symbol file, in the label's bank
+func (d *Debugger) AddBreakpointAtLabel(name string, condition string) (*Breakpoint, error) {
+	sym, ok := d.symbols.Resolve(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown label %q", name)
+	}
+	return d.AddBankedBreakpoint(sym.Bank, sym.Address, condition)
+}
+
+// Removes every breakpoint at address, whatever its bank
+func (d *Debugger) RemoveBreakpoint(address uint16) bool {
+	_, ok := d.breakpoints[address]
+	delete(d.breakpoints, address)
+	return ok
+}
+
+// Removes the breakpoint for one bank at address, leaving other banks' alone
+func (d *Debugger) RemoveBankedBreakpoint(bank int, address uint16) bool {
+	if address < 0x4000 || address > 0x7FFF {
+		bank = symbols.AnyBank
+	}
+	existing := d.breakpoints[address]
+	for i, bp := range existing {
+		if bp.Bank == bank {
+			existing = append(existing[:i], existing[i+1:]...)
+			if len(existing) == 0 {
+				delete(d.breakpoints, address)
+			} else {
+				d.breakpoints[address] = existing
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	var res []*Breakpoint
+	for _, bps := range d.breakpoints {
+		res = append(res, bps...)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Address != res[j].Address {
+			return res[i].Address < res[j].Address
+		}
+		return res[i].Bank < res[j].Bank
+	})
+	return res
+}
+
+func (d *Debugger) AddWatchpoint(address uint16, kind WatchKind) *Watchpoint {
+	wp := &Watchpoint{Address: address, Kind: kind}
+	d.watchpoints[address] = wp
+	return wp
+}
+
+func (d *Debugger) RemoveWatchpoint(address uint16) bool {
+	_, ok := d.watchpoints[address]
+	delete(d.watchpoints, address)
+	return ok
+}
+
+func (d *Debugger) Watchpoints() []*Watchpoint {
+	res := make([]*Watchpoint, 0, len(d.watchpoints))
+	for _, wp := range d.watchpoints {
+		res = append(res, wp)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Address < res[j].Address })
+	return res
+}
+
+func (d *Debugger) OnRead(address uint16, val uint8) {
+	d.checkWatchpoint(address, val, WatchRead)
+}
+
+func (d *Debugger) OnWrite(address uint16, val uint8) {
+	d.checkWatchpoint(address, val, WatchWrite)
+}
+
+func (d *Debugger) checkWatchpoint(address uint16, val uint8, access WatchKind) {
+	if d.inspecting || d.pendingWatch != nil {
+		return
+	}
+
+	wp, ok := d.watchpoints[address]
+	if !ok || wp.Kind&access == 0 {
+		return
+	}
+
+	wp.Hits++
+	d.pendingWatch = &StopEvent{
+		Reason:     StopWatchpoint,
+		Watchpoint: wp,
+		Access:     access,
+		Address:    address,
+		Value:      val,
+	}
+}
+
+// Safe to call from another goroutine. Stops a running Continue, StepOver or StepOut
+func (d *Debugger) Interrupt() {
+	d.interrupted.Store(true)
+}
+
+// Executes a single instruction (or interrupt dispatch)
+func (d *Debugger) Step() StopEvent {
+	d.interrupted.Store(false)
+	if ev, stopped := d.step(); stopped {
+		return ev
+	}
+	return StopEvent{Reason: StopStep, PC: d.PC()}
+}
+
+// Steps over CALL and RST instructions, running the whole subroutine
+func (d *Debugger) StepOver() StopEvent {
+	pc := d.PC()
+	opcode := d.ReadMemory(pc)
+	if !isCall(opcode) && !isRst(opcode) {
+		return d.Step()
+	}
+
+	returnAddress := pc + uint16(cpu.INSTRUCTIONS[opcode].Length)
+	sp := d.SP()
+	return d.runUntil(func() bool {
+		return d.PC() == returnAddress && d.SP() >= sp
+	})
+}
+
+// Runs until the current subroutine returns to its caller
+func (d *Debugger) StepOut() StopEvent {
+	sp := d.SP()
+	return d.runUntil(func() bool {
+		return d.lastExecuted && isReturn(d.lastOpcode) && d.SP() > sp
+	})
+}
+
+// Runs until a breakpoint, watchpoint or Interrupt stops execution
+func (d *Debugger) Continue() StopEvent {
+	return d.runUntil(func() bool { return false })
+}
+
+func (d *Debugger) runUntil(done func() bool) StopEvent {
+	d.interrupted.Store(false)
+
+	frameStart := time.Now()
+	cyclesThisFrame := 0
+	first := true
+
+	for {
+		if !first {
+			if ev, hit := d.checkBreakpoint(); hit {
+				return ev
+			}
+		}
+		first = false
+
+		if d.interrupted.Load() {
+			return StopEvent{Reason: StopInterrupted, PC: d.PC()}
+		}
+
+		if ev, stopped := d.step(); stopped {
+			return ev
+		}
+
+		if done() {
+			return StopEvent{Reason: StopStep, PC: d.PC()}
+		}
+
+		if d.cyclesPerFrame > 0 {
+			cyclesThisFrame += int(d.lastCycles)
+			if cyclesThisFrame >= d.cyclesPerFrame {
+				if elapsed := time.Since(frameStart); elapsed < d.timePerFrame {
+					time.Sleep(d.timePerFrame - elapsed)
+				}
+				frameStart = time.Now()
+				cyclesThisFrame = 0
+			}
+		}
+	}
+}
+
+func (d *Debugger) checkBreakpoint() (StopEvent, bool) {
+	pc := d.PC()
+	for _, bp := range d.breakpoints[pc] {
+		if bp.Bank != symbols.AnyBank && bp.Bank != d.mmu.RomBank() {
+			continue
+		}
+
+		if bp.cond != nil {
+			d.inspecting = true
+			hit := bp.cond(d)
+			d.inspecting = false
+			if !hit {
+				continue
+			}
+		}
+
+		bp.Hits++
+		return StopEvent{Reason: StopBreakpoint, PC: pc, Breakpoint: bp}, true
+	}
+	return StopEvent{}, false
+}
+
+func (d *Debugger) step() (StopEvent, bool) {
+	pc := d.PC()
+	sp := d.SP()
+	opcode := d.ReadMemory(pc)
+	halted := d.cpu.IsHalted()
+
+	d.lastCycles = d.scheduler.Step()
+
+	newPC := d.PC()
+	dispatched := d.lastCycles == cpu.InterruptCycles && d.SP() == sp-2 && isInterruptVector(newPC)
+
+	d.lastOpcode = opcode
+	d.lastExecuted = !dispatched && !(halted && d.cpu.IsHalted())
+
+	switch {
+	case dispatched:
+		d.callStack = append(d.callStack, Frame{Caller: pc, Target: newPC, Interrupt: true})
+	case !d.lastExecuted:
+		// Still halted, nothing ran
+	case (isCall(opcode) || isRst(opcode)) && d.SP() == sp-2:
+		d.callStack = append(d.callStack, Frame{Caller: pc, Target: newPC})
+	case isReturn(opcode) && d.SP() == sp+2 && len(d.callStack) > 0:
+		d.callStack = d.callStack[:len(d.callStack)-1]
+	}
+
+	if d.pendingWatch != nil {
+		ev := *d.pendingWatch
+		ev.PC = newPC
+		d.pendingWatch = nil
+		return ev, true
+	}
+	return StopEvent{}, false
+}
+
+// Innermost frame last
+func (d *Debugger) CallStack() []Frame {
+	return append([]Frame(nil), d.callStack...)
+}
+
+func (d *Debugger) ReadMemory(address uint16) uint8 {
+	d.inspecting = true
+	defer func() { d.inspecting = false }()
+	return d.mmu.Read(address)
+}
+
+func (d *Debugger) WriteMemory(address uint16, val uint8) {
+	d.inspecting = true
+	defer func() { d.inspecting = false }()
+	d.mmu.Write(address, val)
+}
+
+// Writes a hexdump with 16 bytes per row
+func (d *Debugger) Dump(w io.Writer, address uint16, length int) {
+	for row := 0; row < length; row += 16 {
+		base := address + uint16(row)
+		var hex, ascii strings.Builder
+
+		for col := 0; col < 16; col++ {
+			if row+col >= length {
+				hex.WriteString("   ")
+				continue
+			}
+
+			val := d.ReadMemory(base + uint16(col))
+			fmt.Fprintf(&hex, "%02X ", val)
+			if val >= 0x20 && val < 0x7F {
+				ascii.WriteByte(val)
+			} else {
+				ascii.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(w, "%04X: %s %s\n", base, hex.String(), ascii.String())
+	}
+}
+
+// Marks the current PC with => and breakpoints with *
+func (d *Debugger) Disassemble(w io.Writer, address uint16, count int) {
+	for i := 0; i < count; i++ {
+		instr := disasm.DecodeAt(inspectBus{d}, address)
+
+		marker := "  "
+		if address == d.PC() {
+			marker = "=>"
+		}
+		if _, ok := d.breakpoints[address]; ok {
+			marker = marker[:1] + "*"
+		}
+
+		if sym, ok := d.symbols.Lookup(address, d.mmu.RomBank()); ok {
+			fmt.Fprintf(w, "%s:\n", sym.Name)
+		}
+		fmt.Fprintf(w, "%s %04X  %s\n", marker, address, instr.Labeled(d.Label))
+		address += uint16(instr.Length())
+	}
+}
+
+// Reads memory without triggering watchpoints
+type inspectBus struct {
+	d *Debugger
+}
+
+func (b inspectBus) Read(address uint16) uint8 {
+	return b.d.ReadMemory(address)
+}
+
+func (d *Debugger) PC() uint16 {
+	_, _, _, _, _, _, _, _, _, pc := d.cpu.GetState()
+	return pc.Read()
+}
+
+func (d *Debugger) SP() uint16 {
+	_, _, _, _, _, _, _, _, sp, _ := d.cpu.GetState()
+	return sp.Read()
+}
+
+func (d *Debugger) registers8() map[string]memory.Register8 {
+	a, f, b, c, dr, e, h, l, _, _ := d.cpu.GetState()
+	return map[string]memory.Register8{
+		"A": a, "F": f, "B": b, "C": c, "D": dr, "E": e, "H": h, "L": l,
+	}
+}
+
+// Reads A-L, AF, BC, DE, HL, SP or PC by name
+func (d *Debugger) Register(name string) (uint16, error) {
+	name = strings.ToUpper(name)
+	regs := d.registers8()
+
+	if r, ok := regs[name]; ok {
+		return uint16(r.Read()), nil
+	}
+
+	switch name {
+	case "AF", "BC", "DE", "HL":
+		hi, lo := regs[name[:1]], regs[name[1:]]
+		return uint16(hi.Read())<<8 | uint16(lo.Read()), nil
+	case "SP":
+		return d.SP(), nil
+	case "PC":
+		return d.PC(), nil
+	}
+	return 0, fmt.Errorf("unknown register %q", name)
+}
+
+// Writes A-L, AF, BC, DE, HL, SP or PC by name
+func (d *Debugger) SetRegister(name string, val uint16) error {
+	name = strings.ToUpper(name)
+	regs := d.registers8()
+
+	if r, ok := regs[name]; ok {
+		if val > 0xFF {
+			return fmt.Errorf("value %X does not fit in register %s", val, name)
+		}
+		r.Write(uint8(val))
+		return nil
+	}
+
+	_, _, _, _, _, _, _, _, sp, pc := d.cpu.GetState()
+	switch name {
+	case "AF", "BC", "DE", "HL":
+		regs[name[:1]].Write(uint8(val >> 8))
+		regs[name[1:]].Write(uint8(val))
+	case "SP":
+		sp.Write(val)
+	case "PC":
+		pc.Write(val)
+	default:
+		return fmt.Errorf("unknown register %q", name)
+	}
+	return nil
+}
+
+var flagBits = map[string]uint8{"Z": 7, "N": 6, "H": 5, "C": 4}
+
+func (d *Debugger) Flag(name string) (bool, error) {
+	bit, ok := flagBits[strings.ToUpper(name)]
+	if !ok {
+		return false, fmt.Errorf("unknown flag %q", name)
+	}
+	_, f, _, _, _, _, _, _, _, _ := d.cpu.GetState()
+	return f.Read()&(1<<bit) != 0, nil
+}
+
+func (d *Debugger) SetFlag(name string, val bool) error {
+	bit, ok := flagBits[strings.ToUpper(name)]
+	if !ok {
+		return fmt.Errorf("unknown flag %q", name)
+	}
+	_, f, _, _, _, _, _, _, _, _ := d.cpu.GetState()
+	if val {
+		f.Write(f.Read() | (1 << bit))
+	} else {
+		f.Write(f.Read() &^ (1 << bit))
+	}
+	return nil
+}
+
+func (d *Debugger) PrintRegisters(w io.Writer) {
+	flags := []byte("----")
+	for i, name := range []string{"Z", "N", "H", "C"} {
+		if set, _ := d.Flag(name); set {
+			flags[i] = name[0]
+		}
+	}
+
+	af, _ := d.Register("AF")
+	bc, _ := d.Register("BC")
+	de, _ := d.Register("DE")
+	hl, _ := d.Register("HL")
+	fmt.Fprintf(w, "AF:%04X BC:%04X DE:%04X HL:%04X SP:%04X PC:%s FLAGS:%s IME:%d HALT:%d\n",
+		af, bc, de, hl, d.SP(), d.Describe(d.PC()), flags,
+		utils.AsUint8(d.cpu.InterruptMasterEnable()), utils.AsUint8(d.cpu.IsHalted()))
+}
+
+// Describes why execution stopped, naming addresses with labels
+func (d *Debugger) DescribeStop(ev StopEvent) string {
+	switch ev.Reason {
+	case StopBreakpoint:
+		return fmt.Sprintf("Breakpoint hit at %s", d.Describe(ev.PC))
+	case StopWatchpoint:
+		return fmt.Sprintf("Watchpoint %s of %02X at %s, stopped at %s", ev.Access, ev.Value, d.Describe(ev.Address), d.Describe(ev.PC))
+	case StopInterrupted:
+		return fmt.Sprintf("Interrupted at %s", d.Describe(ev.PC))
+	default:
+		return fmt.Sprintf("Stopped at %s", d.Describe(ev.PC))
+	}
+}
+
+func isCall(opcode uint8) bool {
+	return opcode == 0xCD || (opcode&0xE7 == 0xC4)
+}
+
+func isRst(opcode uint8) bool {
+	return opcode&0xC7 == 0xC7
+}
+
+func isReturn(opcode uint8) bool {
+	return opcode == 0xC9 || opcode == 0xD9 || (opcode&0xE7 == 0xC0)
+}
+
+func isInterruptVector(address uint16) bool {
+	for _, vector := range interruptVectors {
+		if address == vector {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,478 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Register numbers used by p/P and the order of g/G. 8 bit registers first, then SP and PC
+// little endian
+var gdbRegisters = []string{"A", "F", "B", "C", "D", "E", "H", "L", "SP", "PC"}
+
+const gdbTargetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.garboy.sm83">
+    <reg name="a" bitsize="8" regnum="0"/>
+    <reg name="f" bitsize="8" regnum="1"/>
+    <reg name="b" bitsize="8" regnum="2"/>
+    <reg name="c" bitsize="8" regnum="3"/>
+    <reg name="d" bitsize="8" regnum="4"/>
+    <reg name="e" bitsize="8" regnum="5"/>
+    <reg name="h" bitsize="8" regnum="6"/>
+    <reg name="l" bitsize="8" regnum="7"/>
+    <reg name="sp" bitsize="16" type="data_ptr" regnum="8"/>
+    <reg name="pc" bitsize="16" type="code_ptr" regnum="9"/>
+  </feature>
+</target>
+`
+
+const (
+	gdbSignalTrap      = 5
+	gdbSignalInterrupt = 2
+)
+
+type gdbEvent struct {
+	packet    string
+	interrupt bool // Ctrl-C (0x03) outside of a packet
+	bad       bool // Checksum mismatch
+}
+
+type gdbSession struct {
+	d     *Debugger
+	conn  net.Conn
+	w     *bufio.Writer
+	noAck bool
+
+	events   chan gdbEvent
+	quit     chan struct{}
+	lastStop string
+}
+
+// Serves GDB Remote Serial Protocol clients one at a time until the listener is closed
+func (d *Debugger) ServeGDB(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		d.serveGDBConn(conn)
+	}
+}
+
+func (d *Debugger) serveGDBConn(conn net.Conn) {
+	s := &gdbSession{
+		d:        d,
+		conn:     conn,
+		w:        bufio.NewWriter(conn),
+		events:   make(chan gdbEvent, 16),
+		quit:     make(chan struct{}),
+		lastStop: fmt.Sprintf("S%02x", gdbSignalTrap),
+	}
+	defer conn.Close()
+	defer close(s.quit)
+	go s.readPackets(bufio.NewReader(conn))
+
+	for ev := range s.events {
+		if ev.interrupt {
+			continue // Nothing is running
+		}
+		if ev.bad {
+			s.w.WriteByte('-')
+			s.w.Flush()
+			continue
+		}
+		if !s.noAck {
+			s.w.WriteByte('+')
+			s.w.Flush()
+		}
+
+		reply, done := s.handle(ev.packet)
+		if done {
+			if reply != "" {
+				s.send(reply)
+			}
+			return
+		}
+		if err := s.send(reply); err != nil {
+			return
+		}
+
+		// Acks stop after the OK
+		if ev.packet == "QStartNoAckMode" {
+			s.noAck = true
+		}
+	}
+}
+
+// Splits the byte stream into packets. Acks are dropped and checksums verified
+func (s *gdbSession) readPackets(r *bufio.Reader) {
+	defer close(s.events)
+
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+
+		var ev gdbEvent
+		switch c {
+		case 0x03:
+			ev.interrupt = true
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+
+			sum := make([]byte, 2)
+			if _, err := io.ReadFull(r, sum); err != nil {
+				return
+			}
+
+			want, err := strconv.ParseUint(string(sum), 16, 8)
+			if err != nil || uint8(want) != checksum(data) {
+				ev.bad = true
+			} else {
+				ev.packet = data
+			}
+		default:
+			continue // Acks and noise between packets
+		}
+
+		select {
+		case s.events <- ev:
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *gdbSession) send(data string) error {
+	var escaped strings.Builder
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '#', '$', '}', '*':
+			escaped.WriteByte('}')
+			escaped.WriteByte(c ^ 0x20)
+		default:
+			escaped.WriteByte(c)
+		}
+	}
+	out := escaped.String()
+
+	fmt.Fprintf(s.w, "$%s#%02x", out, checksum(out))
+	return s.w.Flush()
+}
+
+// Returns the reply to packet, and true when the client detached or killed the session
+func (s *gdbSession) handle(packet string) (string, bool) {
+	if packet == "" {
+		return "", false
+	}
+	cmd, args := packet[0], packet[1:]
+
+	switch cmd {
+	case '?':
+		return s.lastStop, false
+	case 'g':
+		return s.readRegisters(), false
+	case 'G':
+		return s.writeRegisters(args), false
+	case 'p':
+		return s.readRegister(args), false
+	case 'P':
+		return s.writeRegister(args), false
+	case 'm':
+		return s.readMemory(args), false
+	case 'M':
+		return s.writeMemory(args), false
+	case 'Z', 'z':
+		return s.breakpoint(cmd == 'Z', args), false
+	case 'c', 's':
+		if args != "" {
+			address, err := strconv.ParseUint(args, 16, 16)
+			if err != nil {
+				return "E01", false
+			}
+			s.d.SetRegister("PC", uint16(address))
+		}
+
+		var ev StopEvent
+		if cmd == 's' {
+			ev = s.d.Step()
+		} else {
+			var ok bool
+			if ev, ok = s.run(); !ok {
+				return "", true
+			}
+		}
+		s.lastStop = stopReply(ev)
+		return s.lastStop, false
+	case 'H':
+		return "OK", false
+	case 'D':
+		return "OK", true
+	case 'k':
+		return "", true
+	case 'q':
+		return s.query(args), false
+	case 'Q':
+		if args == "StartNoAckMode" {
+			return "OK", false
+		}
+		return "", false
+	}
+	return "", false
+}
+
+// Continues on another goroutine so a Ctrl-C from the client can stop it. Returns false if the
+// connection dropped
+func (s *gdbSession) run() (StopEvent, bool) {
+	done := make(chan StopEvent)
+	go func() { done <- s.d.Continue() }()
+
+	for {
+		select {
+		case ev := <-done:
+			return ev, true
+		case ev, ok := <-s.events:
+			if !ok {
+				s.d.Interrupt()
+				<-done
+				return StopEvent{}, false
+			}
+			if ev.interrupt {
+				s.d.Interrupt()
+			}
+		}
+	}
+}
+
+func (s *gdbSession) query(args string) string {
+	name, _, _ := strings.Cut(args, ":")
+	switch name {
+	case "Supported":
+		return "PacketSize=1000;qXfer:features:read+;QStartNoAckMode+"
+	case "Attached":
+		return "1"
+	case "C":
+		return "QC1"
+	case "fThreadInfo":
+		return "m1"
+	case "sThreadInfo":
+		return "l"
+	case "Xfer":
+		// Xfer:features:read:target.xml:offset,length
+		parts := strings.Split(args, ":")
+		if len(parts) != 5 || parts[1] != "features" || parts[2] != "read" || parts[3] != "target.xml" {
+			return ""
+		}
+		offset, length, ok := parseRange(parts[4])
+		if !ok {
+			return "E01"
+		}
+		if offset >= len(gdbTargetXML) {
+			return "l"
+		}
+		end := min(offset+length, len(gdbTargetXML))
+		prefix := "m"
+		if end == len(gdbTargetXML) {
+			prefix = "l"
+		}
+		return prefix + gdbTargetXML[offset:end]
+	}
+	return ""
+}
+
+func (s *gdbSession) readRegisters() string {
+	var res strings.Builder
+	for i := range gdbRegisters {
+		res.WriteString(s.registerHex(i))
+	}
+	return res.String()
+}
+
+func (s *gdbSession) writeRegisters(args string) string {
+	data, err := hex.DecodeString(args)
+	if err != nil || len(data) < 12 {
+		return "E01"
+	}
+
+	for i, name := range gdbRegisters[:8] {
+		s.d.SetRegister(name, uint16(data[i]))
+	}
+	s.d.SetRegister("SP", uint16(data[8])|uint16(data[9])<<8)
+	s.d.SetRegister("PC", uint16(data[10])|uint16(data[11])<<8)
+	return "OK"
+}
+
+func (s *gdbSession) readRegister(args string) string {
+	n, err := strconv.ParseUint(args, 16, 8)
+	if err != nil || int(n) >= len(gdbRegisters) {
+		return "E01"
+	}
+	return s.registerHex(int(n))
+}
+
+func (s *gdbSession) writeRegister(args string) string {
+	num, value, ok := strings.Cut(args, "=")
+	if !ok {
+		return "E01"
+	}
+	n, err := strconv.ParseUint(num, 16, 8)
+	if err != nil || int(n) >= len(gdbRegisters) {
+		return "E01"
+	}
+	data, err := hex.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return "E01"
+	}
+
+	val := uint16(data[0])
+	if len(data) > 1 {
+		val |= uint16(data[1]) << 8
+	}
+	if err := s.d.SetRegister(gdbRegisters[n], val); err != nil {
+		return "E01"
+	}
+	return "OK"
+}
+
+// Little endian like every other GDB target
+func (s *gdbSession) registerHex(n int) string {
+	val, _ := s.d.Register(gdbRegisters[n])
+	if n < 8 {
+		return fmt.Sprintf("%02x", val)
+	}
+	return fmt.Sprintf("%02x%02x", val&0xFF, val>>8)
+}
+
+func (s *gdbSession) readMemory(args string) string {
+	address, length, ok := parseRange(args)
+	if !ok {
+		return "E01"
+	}
+
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = s.d.ReadMemory(uint16(address + i))
+	}
+	return hex.EncodeToString(data)
+}
+
+// Writes go through the MMU like the REPL's poke, so writes to ROM switch banks
+func (s *gdbSession) writeMemory(args string) string {
+	header, value, ok := strings.Cut(args, ":")
+	if !ok {
+		return "E01"
+	}
+	address, length, ok := parseRange(header)
+	if !ok {
+		return "E01"
+	}
+	data, err := hex.DecodeString(value)
+	if err != nil || len(data) != length {
+		return "E01"
+	}
+
+	for i, b := range data {
+		s.d.WriteMemory(uint16(address+i), b)
+	}
+	return "OK"
+}
+
+// Z0/Z1 are both execution breakpoints, Z2/Z3/Z4 are write/read/access watchpoints
+func (s *gdbSession) breakpoint(insert bool, args string) string {
+	parts := strings.Split(args, ",")
+	if len(parts) < 3 {
+		return "E01"
+	}
+	address, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	length, err := strconv.ParseUint(parts[2], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+
+	var kind WatchKind
+	switch parts[0] {
+	case "0", "1":
+		if insert {
+			if _, err := s.d.AddBreakpoint(uint16(address), ""); err != nil {
+				return "E01"
+			}
+		} else {
+			s.d.RemoveBreakpoint(uint16(address))
+		}
+		return "OK"
+	case "2":
+		kind = WatchWrite
+	case "3":
+		kind = WatchRead
+	case "4":
+		kind = WatchAny
+	default:
+		return ""
+	}
+
+	for i := uint16(0); i < max(uint16(length), 1); i++ {
+		if insert {
+			s.d.AddWatchpoint(uint16(address)+i, kind)
+		} else {
+			s.d.RemoveWatchpoint(uint16(address) + i)
+		}
+	}
+	return "OK"
+}
+
+func stopReply(ev StopEvent) string {
+	switch ev.Reason {
+	case StopInterrupted:
+		return fmt.Sprintf("S%02x", gdbSignalInterrupt)
+	case StopWatchpoint:
+		name := "awatch"
+		switch ev.Watchpoint.Kind {
+		case WatchWrite:
+			name = "watch"
+		case WatchRead:
+			name = "rwatch"
+		}
+		return fmt.Sprintf("T%02x%s:%x;", gdbSignalTrap, name, ev.Address)
+	default:
+		return fmt.Sprintf("S%02x", gdbSignalTrap)
+	}
+}
+
+// Parses "addr,length" in hex
+func parseRange(s string) (int, int, bool) {
+	a, l, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, false
+	}
+	address, err := strconv.ParseUint(a, 16, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+	length, err := strconv.ParseUint(l, 16, 32)
+	if err != nil || length > 0x10000 {
+		return 0, 0, false
+	}
+	return int(address), int(length), true
+}
+
+func checksum(data string) uint8 {
+	var sum uint8
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
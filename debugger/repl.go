@@ -0,0 +1,395 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+
+	"garboy/addresses"
+	"garboy/display"
+	"garboy/symbols"
+)
+
+const replHelp = `Commands (numbers are hex unless prefixed with #):
+  s, step [count]            Step into the next instruction
+  n, next                    Step over CALL/RST
+  finish                     Run until the current subroutine returns
+  c, continue                Run until a breakpoint, watchpoint or Ctrl-C
+  b, break <addr> [if cond]  Set a breakpoint, e.g. "b 150 if A == 3F". <addr> can
+                             also be a label or BB:AAAA for a ROM bank
+  d, delete <addr>           Remove a breakpoint. <addr> works like break, and a plain
+                             address removes it from every bank
+  w, watch <addr> [r|w|rw]   Set a watchpoint (default rw)
+  unwatch <addr>             Remove a watchpoint
+  info                       List breakpoints and watchpoints
+  r, regs                    Print registers
+  set <reg> <val>            Write A-L, AF-HL, SP or PC
+  flag <Z|N|H|C> <0|1>       Write a flag
+  x <addr> [len]             Dump memory (default 64 bytes)
+  dis [addr] [count]         Disassemble (default PC, 10 instructions)
+  poke <addr> <val>          Write a byte to memory
+  access <strict|relaxed>    Block VRAM/OAM by PPU mode like hardware, or always allow it
+  oam                        List the 40 OAM entries with decoded flags
+  vram <view> <file.png>     Save a viewer as PNG. <view> is tiles, 9800, 9c00 or oam
+  screenshot <file> [scale]  Save the last frame as PNG
+  bt                         Print the call stack
+  q, quit                    Exit
+Pressing enter repeats the last command.
+`
+
+// Reads commands from in until quit or EOF. Ctrl-C handling is left to the caller through Interrupt
+func (d *Debugger) RunREPL(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	lastLine := ""
+
+	d.PrintRegisters(out)
+	for {
+		fmt.Fprint(out, "(garboy) ")
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			line = lastLine
+		}
+		lastLine = line
+		if line == "" {
+			continue
+		}
+
+		quit, err := d.execCommand(line, out)
+		if err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+func (d *Debugger) execCommand(line string, out io.Writer) (bool, error) {
+	fields := strings.Fields(line)
+	cmd, args := fields[0], fields[1:]
+
+	switch cmd {
+	case "s", "step":
+		count := 1
+		if len(args) > 0 {
+			n, err := ParseValue(args[0])
+			if err != nil {
+				return false, fmt.Errorf("invalid step count %q", args[0])
+			}
+			count = int(n)
+		}
+
+		var ev StopEvent
+		for i := 0; i < count; i++ {
+			ev = d.Step()
+			if ev.Reason != StopStep {
+				break
+			}
+		}
+		d.reportStop(ev, out)
+	case "n", "next":
+		d.reportStop(d.StepOver(), out)
+	case "finish":
+		d.reportStop(d.StepOut(), out)
+	case "c", "continue":
+		d.reportStop(d.Continue(), out)
+	case "b", "break":
+		if len(args) < 1 {
+			return false, fmt.Errorf("usage: break <addr> [if cond]")
+		}
+		condition := ""
+		if len(args) > 1 {
+			if args[1] != "if" || len(args) < 3 {
+				return false, fmt.Errorf("usage: break <addr> [if cond]")
+			}
+			condition = strings.Join(args[2:], " ")
+		}
+
+		bp, err := d.addBreakpoint(args[0], condition)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(out, "Breakpoint at %s\n", bp.location(d))
+	case "d", "delete":
+		if len(args) < 1 {
+			return false, fmt.Errorf("usage: delete <addr>")
+		}
+		if err := d.removeBreakpoint(args[0]); err != nil {
+			return false, err
+		}
+	case "w", "watch":
+		if len(args) < 1 {
+			return false, fmt.Errorf("usage: watch <addr> [r|w|rw]")
+		}
+		address, err := ParseValue(args[0])
+		if err != nil {
+			return false, err
+		}
+
+		kind := WatchAny
+		if len(args) > 1 {
+			switch args[1] {
+			case "r":
+				kind = WatchRead
+			case "w":
+				kind = WatchWrite
+			case "rw":
+				kind = WatchAny
+			default:
+				return false, fmt.Errorf("watch kind must be r, w or rw")
+			}
+		}
+		d.AddWatchpoint(address, kind)
+		fmt.Fprintf(out, "Watchpoint at %04X\n", address)
+	case "unwatch":
+		if len(args) < 1 {
+			return false, fmt.Errorf("usage: unwatch <addr>")
+		}
+		address, err := ParseValue(args[0])
+		if err != nil {
+			return false, err
+		}
+		if !d.RemoveWatchpoint(address) {
+			return false, fmt.Errorf("no watchpoint at %04X", address)
+		}
+	case "info":
+		for _, bp := range d.Breakpoints() {
+			fmt.Fprintf(out, "break %s hits=%d", bp.location(d), bp.Hits)
+			if bp.Condition != "" {
+				fmt.Fprintf(out, " if %s", bp.Condition)
+			}
+			fmt.Fprintln(out)
+		}
+		for _, wp := range d.Watchpoints() {
+			fmt.Fprintf(out, "watch %04X %s hits=%d\n", wp.Address, wp.Kind, wp.Hits)
+		}
+	case "r", "regs":
+		d.PrintRegisters(out)
+	case "set":
+		if len(args) != 2 {
+			return false, fmt.Errorf("usage: set <reg> <val>")
+		}
+		val, err := ParseValue(args[1])
+		if err != nil {
+			return false, err
+		}
+		return false, d.SetRegister(args[0], val)
+	case "flag":
+		if len(args) != 2 || (args[1] != "0" && args[1] != "1") {
+			return false, fmt.Errorf("usage: flag <Z|N|H|C> <0|1>")
+		}
+		return false, d.SetFlag(args[0], args[1] == "1")
+	case "x":
+		if len(args) < 1 {
+			return false, fmt.Errorf("usage: x <addr> [len]")
+		}
+		address, err := ParseValue(args[0])
+		if err != nil {
+			return false, err
+		}
+		length := uint16(64)
+		if len(args) > 1 {
+			if length, err = ParseValue(args[1]); err != nil {
+				return false, err
+			}
+		}
+		d.Dump(out, address, int(length))
+	case "dis":
+		address := d.PC()
+		count := 10
+		if len(args) > 0 {
+			val, err := ParseValue(args[0])
+			if err != nil {
+				return false, err
+			}
+			address = val
+		}
+		if len(args) > 1 {
+			n, err := ParseValue(args[1])
+			if err != nil {
+				return false, fmt.Errorf("invalid instruction count %q", args[1])
+			}
+			count = int(n)
+		}
+		d.Disassemble(out, address, count)
+	case "poke":
+		if len(args) != 2 {
+			return false, fmt.Errorf("usage: poke <addr> <val>")
+		}
+		address, err := ParseValue(args[0])
+		if err != nil {
+			return false, err
+		}
+		val, err := ParseValue(args[1])
+		if err != nil {
+			return false, err
+		}
+		if val > 0xFF {
+			return false, fmt.Errorf("value %X does not fit in a byte", val)
+		}
+		d.WriteMemory(address, uint8(val))
+	case "access":
+		if len(args) != 1 || (args[0] != "strict" && args[0] != "relaxed") {
+			return false, fmt.Errorf("usage: access <strict|relaxed>")
+		}
+		d.mmu.SetAccessRestrictions(args[0] == "strict")
+	case "oam":
+		if d.ppu == nil {
+			return false, fmt.Errorf("no PPU attached")
+		}
+		d.ppu.WriteOamTable(out)
+	case "vram":
+		if len(args) != 2 {
+			return false, fmt.Errorf("usage: vram <tiles|9800|9c00|oam> <file.png>")
+		}
+		return false, d.saveViewer(args[0], args[1])
+	case "screenshot":
+		if len(args) < 1 {
+			return false, fmt.Errorf("usage: screenshot <file.png> [scale]")
+		}
+		if d.ppu == nil {
+			return false, fmt.Errorf("no PPU attached")
+		}
+		scale := 1
+		if len(args) > 1 {
+			n, err := ParseValue(args[1])
+			if err != nil || n < 1 {
+				return false, fmt.Errorf("invalid scale %q", args[1])
+			}
+			scale = int(n)
+		}
+		return false, d.ppu.SaveScreenshot(args[0], scale)
+	case "bt":
+		stack := d.CallStack()
+		for i := len(stack) - 1; i >= 0; i-- {
+			frame := stack[i]
+			kind := "call"
+			if frame.Interrupt {
+				kind = "interrupt"
+			}
+			fmt.Fprintf(out, "#%d %s from %s (%s)\n", len(stack)-1-i, d.Describe(frame.Target), d.Describe(frame.Caller), kind)
+		}
+	case "q", "quit":
+		return true, nil
+	case "h", "help":
+		fmt.Fprint(out, replHelp)
+	default:
+		return false, fmt.Errorf("unknown command %q, try help", cmd)
+	}
+	return false, nil
+}
+
+// Accepts a hex address, BB:AAAA for an address in one ROM bank, or a label from the symbol file
+// A breakpoint address as typed: BB:AAAA for one ROM bank, a label for its own bank, or a plain
+// address for any bank
+func (d *Debugger) parseBreakpointTarget(target string) (int, uint16, error) {
+	if bank, address, ok := strings.Cut(target, ":"); ok {
+		b, err := strconv.ParseUint(bank, 16, 16)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid bank %q", bank)
+		}
+		a, err := ParseValue(address)
+		if err != nil {
+			return 0, 0, err
+		}
+		return int(b), a, nil
+	}
+
+	// Labels win over hex so names like "Add" still work
+	if sym, ok := d.symbols.Resolve(target); ok {
+		return sym.Bank, sym.Address, nil
+	}
+	address, err := ParseValue(target)
+	if err != nil {
+		return 0, 0, err
+	}
+	return symbols.AnyBank, address, nil
+}
+
+func (d *Debugger) addBreakpoint(target string, condition string) (*Breakpoint, error) {
+	bank, address, err := d.parseBreakpointTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	return d.AddBankedBreakpoint(bank, address, condition)
+}
+
+// A plain address removes the breakpoints of every bank there, a bank or label only its own
+func (d *Debugger) removeBreakpoint(target string) error {
+	bank, address, err := d.parseBreakpointTarget(target)
+	if err != nil {
+		return err
+	}
+	removed := false
+	if bank == symbols.AnyBank {
+		removed = d.RemoveBreakpoint(address)
+	} else {
+		removed = d.RemoveBankedBreakpoint(bank, address)
+	}
+	if !removed {
+		return fmt.Errorf("no breakpoint at %s", target)
+	}
+	return nil
+}
+
+func (d *Debugger) saveViewer(view string, path string) error {
+	if d.ppu == nil {
+		return fmt.Errorf("no PPU attached")
+	}
+
+	var img image.Image
+	switch strings.ToLower(view) {
+	case "tiles":
+		img = d.ppu.TileSheet(d.ReadMemory(addresses.BgPalette))
+	case "9800":
+		img = d.ppu.TileMap(display.TileMap0)
+	case "9c00":
+		img = d.ppu.TileMap(display.TileMap1)
+	case "oam":
+		img = d.ppu.OamSheet()
+	default:
+		return fmt.Errorf("unknown view %q, expected tiles, 9800, 9c00 or oam", view)
+	}
+	return display.SavePNG(img, path)
+}
+
+func (bp *Breakpoint) location(d *Debugger) string {
+	address := fmt.Sprintf("%04X", bp.Address)
+	if bp.Bank != symbols.AnyBank {
+		address = fmt.Sprintf("%02X:%04X", bp.Bank, bp.Address)
+	}
+
+	bank := bp.Bank
+	if bank == symbols.AnyBank {
+		bank = d.mmu.RomBank()
+	}
+	if label := d.symbols.Label(bp.Address, bank); label != "" {
+		return fmt.Sprintf("%s (%s)", address, label)
+	}
+	return address
+}
+
+func (d *Debugger) reportStop(ev StopEvent, out io.Writer) {
+	if ev.Reason != StopStep {
+		fmt.Fprintln(out, d.DescribeStop(ev))
+	}
+	d.PrintRegisters(out)
+}
+
+func (k WatchKind) String() string {
+	switch k {
+	case WatchRead:
+		return "r"
+	case WatchWrite:
+		return "w"
+	default:
+		return "rw"
+	}
+}
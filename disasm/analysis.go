@@ -0,0 +1,241 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"garboy/symbols"
+)
+
+const (
+	bankSize     = 0x4000
+	bytesPerData = 8
+)
+
+// Entry point, RST vectors and interrupt vectors
+var entryPoints = []uint16{
+	0x0100,
+	0x0000, 0x0008, 0x0010, 0x0018, 0x0020, 0x0028, 0x0030, 0x0038,
+	0x0040, 0x0048, 0x0050, 0x0058, 0x0060,
+}
+
+// Splits a ROM image into code and data by following control flow from the entry points
+type Analysis struct {
+	rom   []uint8
+	code  []bool // Byte belongs to an instruction
+	start []bool // Instruction starts at this byte
+
+	symbols *symbols.Table
+}
+
+type location struct {
+	bank    int
+	address uint16
+	// ROM bank the code last switched to, -1 when unknown
+	selected int
+}
+
+func Analyze(rom []uint8) *Analysis {
+	a := &Analysis{
+		rom:   rom,
+		code:  make([]bool, len(rom)),
+		start: make([]bool, len(rom)),
+	}
+
+	var queue []location
+	for _, entry := range entryPoints {
+		queue = append(queue, location{bank: 0, address: entry, selected: -1})
+	}
+
+	for len(queue) > 0 {
+		loc := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		queue = a.trace(loc, queue)
+	}
+	return a
+}
+
+// Labels used by WriteBank
+func (a *Analysis) SetSymbols(table *symbols.Table) {
+	a.symbols = table
+}
+
+func (a *Analysis) Banks() int {
+	return (len(a.rom) + bankSize - 1) / bankSize
+}
+
+func (a *Analysis) IsCode(offset int) bool {
+	return offset >= 0 && offset < len(a.code) && a.code[offset]
+}
+
+// Decodes linearly from loc until control flow can't continue, queueing every branch target
+func (a *Analysis) trace(loc location, queue []location) []location {
+	lastA := -1
+	address := loc.address
+
+	for {
+		offset, end, ok := a.offset(loc.bank, address)
+		if !ok || a.start[offset] {
+			return queue
+		}
+
+		instr := Decode(a.rom[offset:end], address)
+		if !instr.Valid {
+			return queue
+		}
+
+		for i := 0; i < instr.Length(); i++ {
+			a.code[offset+i] = true
+		}
+		a.start[offset] = true
+
+		// Follow "LD A,n / LD ($2000),A" style bank switches so calls into 4000-7FFF resolve
+		opcode := instr.Bytes[0]
+		switch {
+		case opcode == 0x3E:
+			lastA = int(instr.Bytes[1])
+		case opcode == 0xEA:
+			target := instr.imm16()
+			if target >= 0x2000 && target < 0x4000 && lastA >= 0 {
+				loc.selected = lastA
+				if loc.selected == 0 {
+					loc.selected = 1
+				}
+			}
+		case opcode == 0xE0:
+		default:
+			lastA = -1
+		}
+
+		if instr.HasTarget {
+			if bank, ok := a.resolveBank(instr.Target, loc); ok {
+				queue = append(queue, location{bank: bank, address: instr.Target, selected: loc.selected})
+			}
+		}
+
+		if instr.EndsFlow() {
+			return queue
+		}
+		address += uint16(instr.Length())
+	}
+}
+
+// Which ROM bank a branch target lands in, given where the branch was made from
+func (a *Analysis) resolveBank(target uint16, from location) (int, bool) {
+	switch {
+	case target < bankSize:
+		return 0, true
+	case target >= 2*bankSize:
+		return 0, false // Code running from RAM
+	case from.bank > 0:
+		return from.bank, true
+	case from.selected > 0 && from.selected < a.Banks():
+		return from.selected, true
+	case a.Banks() == 2:
+		return 1, true
+	default:
+		return 0, false
+	}
+}
+
+// ROM offset of address within bank, and the end of that bank
+func (a *Analysis) offset(bank int, address uint16) (int, int, bool) {
+	base := 0
+	if bank > 0 {
+		if address < bankSize || address >= 2*bankSize {
+			return 0, 0, false
+		}
+		base = bank*bankSize - bankSize
+	} else if address >= bankSize {
+		return 0, 0, false
+	}
+
+	offset := base + int(address)
+	end := min((bank+1)*bankSize, len(a.rom))
+	if offset >= end {
+		return 0, 0, false
+	}
+	return offset, end, true
+}
+
+// Writes a bank as code and DB lines. Bank 0 is mapped at 0000, every other bank at 4000
+func (a *Analysis) WriteBank(w io.Writer, bank int) error {
+	if bank < 0 || bank >= a.Banks() {
+		return fmt.Errorf("bank %d out of range, ROM has %d banks", bank, a.Banks())
+	}
+
+	base := bank * bankSize
+	end := min(base+bankSize, len(a.rom))
+	address := uint16(0)
+	if bank > 0 {
+		address = bankSize
+	}
+
+	// Bank 0 code can't know which bank is mapped at 4000-7FFF
+	romBank := bank
+	if bank == 0 {
+		romBank = symbols.AnyBank
+	}
+	label := func(address uint16) string {
+		return a.symbols.Label(address, romBank)
+	}
+
+	if _, err := fmt.Fprintf(w, "; ROM bank $%02X\n", bank); err != nil {
+		return err
+	}
+
+	for offset := base; offset < end; {
+		var line string
+		var length int
+
+		if name := a.labelAt(address, bank); name != "" {
+			if _, err := fmt.Fprintf(w, "%s:\n", name); err != nil {
+				return err
+			}
+		}
+
+		if a.start[offset] {
+			instr := Decode(a.rom[offset:end], address)
+			line = fmt.Sprintf("%02X:%04X  %-9s %s", bank, address, hexBytes(instr.Bytes), instr.Labeled(label))
+			length = instr.Length()
+		} else {
+			length = 1
+			for length < bytesPerData && offset+length < end && !a.code[offset+length] &&
+				a.labelAt(address+uint16(length), bank) == "" {
+				length++
+			}
+			line = fmt.Sprintf("%02X:%04X  %-9s %s", bank, address, "", dataBytes(a.rom[offset:offset+length]))
+		}
+
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+		offset += length
+		address += uint16(length)
+	}
+	return nil
+}
+
+func (a *Analysis) labelAt(address uint16, bank int) string {
+	if sym, ok := a.symbols.Lookup(address, bank); ok {
+		return sym.Name
+	}
+	return ""
+}
+
+func hexBytes(data []uint8) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, " ")
+}
+
+func dataBytes(data []uint8) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("$%02X", b)
+	}
+	return "DB " + strings.Join(parts, ",")
+}
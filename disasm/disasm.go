@@ -0,0 +1,175 @@
+package disasm
+
+import (
+	"fmt"
+	"strings"
+
+	"garboy/cpu"
+)
+
+// Anything that can be read like the memory bus. mmu.MMU and memory.Memory both qualify
+type Bus interface {
+	Read(address uint16) uint8
+}
+
+type Instruction struct {
+	Address uint16
+	Bytes   []uint8
+	Text    string
+	Valid   bool
+
+	// Filled in for jumps, calls and RSTs
+	Target    uint16
+	HasTarget bool
+
+	// Address operand and how it appears in Text, for swapping in labels
+	ref     uint16
+	refText string
+}
+
+func (i Instruction) Length() int {
+	return len(i.Bytes)
+}
+
+// Decodes the instruction at the start of data, which is mapped at address.
+// Truncated or invalid opcodes decode to a single DB byte
+func Decode(data []uint8, address uint16) Instruction {
+	if len(data) == 0 {
+		return Instruction{Address: address}
+	}
+
+	opcode := data[0]
+	instr := cpu.INSTRUCTIONS[opcode]
+	if opcode == 0xCB {
+		if len(data) < 2 {
+			return dataByte(address, opcode)
+		}
+		instr = cpu.CB_INSTRUCTIONS[data[1]]
+	}
+
+	length := int(instr.Length)
+	if length == 0 || len(data) < length {
+		return dataByte(address, opcode)
+	}
+
+	res := Instruction{
+		Address: address,
+		Bytes:   append([]uint8(nil), data[:length]...),
+		Valid:   true,
+	}
+	res.Text = res.format(instr.Mnemonic)
+	return res
+}
+
+// Decodes the instruction at address through a live bus such as the MMU
+func DecodeAt(bus Bus, address uint16) Instruction {
+	var buf [3]uint8
+	for i := range buf {
+		buf[i] = bus.Read(address + uint16(i))
+	}
+	return Decode(buf[:], address)
+}
+
+// Replaces the address operand with its label, e.g. "CALL $0150" becomes "CALL Start"
+func (i Instruction) Labeled(label func(address uint16) string) string {
+	if i.refText == "" {
+		return i.Text
+	}
+
+	name := label(i.ref)
+	if name == "" {
+		return i.Text
+	}
+	return strings.Replace(i.Text, i.refText, name, 1)
+}
+
+// True for unconditional jumps and returns, after which the next bytes aren't necessarily code
+func (i Instruction) EndsFlow() bool {
+	if !i.Valid {
+		return true
+	}
+
+	switch i.Bytes[0] {
+	case 0xC3, 0x18, 0xC9, 0xD9, 0xE9:
+		return true
+	}
+	return false
+}
+
+// Linear sweep over data, mapped at address
+func Disassemble(data []uint8, address uint16) []Instruction {
+	var res []Instruction
+	for offset := 0; offset < len(data); {
+		instr := Decode(data[offset:], address+uint16(offset))
+		res = append(res, instr)
+		offset += instr.Length()
+	}
+	return res
+}
+
+func dataByte(address uint16, val uint8) Instruction {
+	return Instruction{
+		Address: address,
+		Bytes:   []uint8{val},
+		Text:    fmt.Sprintf("DB $%02X", val),
+	}
+}
+
+// Turns a table mnemonic such as "JR NZ, r8" into "JR NZ,$0150"
+func (i *Instruction) format(mnemonic string) string {
+	text := strings.ReplaceAll(mnemonic, ", ", ",")
+	next := i.Address + uint16(len(i.Bytes))
+
+	switch {
+	case strings.Contains(text, "SP+r8"):
+		text = strings.Replace(text, "SP+r8", "SP"+signed(i.imm8()), 1)
+	case strings.HasPrefix(text, "JR"):
+		i.setTarget(uint16(int(next) + int(int8(i.imm8()))))
+		text = strings.Replace(text, "r8", i.setRef(i.Target), 1)
+	case strings.Contains(text, "r8"):
+		text = strings.Replace(text, "r8", signed(i.imm8()), 1)
+	case strings.Contains(text, "(a8)"):
+		text = strings.Replace(text, "LDH", "LD", 1)
+		text = strings.Replace(text, "(a8)", "("+i.setRef(0xFF00|uint16(i.imm8()))+")", 1)
+	case strings.Contains(text, "a16"):
+		if strings.HasPrefix(text, "JP") || strings.HasPrefix(text, "CALL") {
+			i.setTarget(i.imm16())
+		}
+		text = strings.Replace(text, "a16", i.setRef(i.imm16()), 1)
+	case strings.Contains(text, "d16"):
+		text = strings.Replace(text, "d16", fmt.Sprintf("$%04X", i.imm16()), 1)
+	case strings.Contains(text, "d8"):
+		text = strings.Replace(text, "d8", fmt.Sprintf("$%02X", i.imm8()), 1)
+	case strings.HasPrefix(text, "RST"):
+		i.setTarget(uint16(i.Bytes[0] & 0x38))
+		text = fmt.Sprintf("RST $%02X", i.Target)
+	}
+	return text
+}
+
+func (i *Instruction) setTarget(target uint16) {
+	i.Target = target
+	i.HasTarget = true
+}
+
+func (i *Instruction) setRef(address uint16) string {
+	i.ref = address
+	i.refText = fmt.Sprintf("$%04X", address)
+	return i.refText
+}
+
+func (i *Instruction) imm8() uint8 {
+	return i.Bytes[1]
+}
+
+func (i *Instruction) imm16() uint16 {
+	return uint16(i.Bytes[2])<<8 | uint16(i.Bytes[1])
+}
+
+func signed(val uint8) string {
+	offset := int8(val)
+	if offset < 0 {
+		return fmt.Sprintf("-$%02X", -int(offset))
+	}
+	return fmt.Sprintf("+$%02X", offset)
+}
@@ -0,0 +1,142 @@
+package display
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"time"
+)
+
+const (
+	// The LCD refreshes at about 59.73Hz, and GIF delays are in hundredths of a second
+	framesPerSecond = 59.73
+	// Most viewers slow anything shorter down to 10
+	minGifDelay = 2
+)
+
+// The last finished frame through the current upscaler, then scaled up by a whole number with
+// nearest neighbour
+func (p *PPU) Screenshot(scale int) *image.RGBA {
+	frame := p.upscaledFrame(CurrentUpscaler())
+	scale = max(scale, 1)
+
+	img := image.NewRGBA(image.Rect(0, 0, frame.Width*scale, frame.Height*scale))
+	for y := 0; y < frame.Height*scale; y++ {
+		for x := 0; x < frame.Width*scale; x++ {
+			img.SetRGBA(x, y, gameBoyColorToRgba(frame.At(x/scale, y/scale)))
+		}
+	}
+	return img
+}
+
+func (p *PPU) SaveScreenshot(path string, scale int) error {
+	return SavePNG(p.Screenshot(scale), path)
+}
+
+// The front buffer can be swapped by the emulator goroutine at any time
+func (p *PPU) copyFrame() [ScreenHeight][ScreenWidth]Color {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return *p.frontBuffer
+}
+
+func (p *PPU) upscaledFrame(u Upscaler) UpscaledFrame {
+	frame := p.copyFrame()
+	var upscaled UpscaledFrame
+	u.Apply(&frame, &upscaled)
+	return upscaled
+}
+
+// RGB shown on screen for a shade
+func ScreenColor(c Color) color.RGBA {
+	return gameBoyColorToRgba(c)
+}
+
+// Every framebuffer color as it is currently shown on screen, indexed by Color
+func screenPalette() color.Palette {
+	palette := make(color.Palette, Obj1Source+ShadeMask+1)
+	for i := range palette {
+		palette[i] = gameBoyColorToRgba(Color(i))
+	}
+	return palette
+}
+
+// Records frames into an animated GIF. Capture can be called as often as convenient, only every
+// skip-th emulated frame is kept. The upscaler is the one current when recording started
+type GifRecorder struct {
+	ppu      *PPU
+	path     string
+	scale    int
+	skip     uint64
+	upscaler Upscaler
+
+	anim      gif.GIF
+	lastFrame uint64
+	elapsed   float64 // Centiseconds of emulated time not yet given to a GIF frame
+}
+
+func NewGifRecorder(ppu *PPU, path string, scale int, skip int) *GifRecorder {
+	return &GifRecorder{
+		ppu:       ppu,
+		path:      path,
+		scale:     max(scale, 1),
+		skip:      uint64(max(skip, 1)),
+		upscaler:  CurrentUpscaler(),
+		lastFrame: ppu.Frames(),
+	}
+}
+
+// Adds the current frame if enough frames have passed since the last one
+func (r *GifRecorder) Capture() {
+	frames := r.ppu.Frames()
+	if n := len(r.anim.Image); n > 0 {
+		if frames < r.lastFrame+r.skip {
+			return
+		}
+
+		// The previous image stays up until this one replaces it. Rounding errors carry over
+		r.elapsed += float64(frames-r.lastFrame) * 100 / framesPerSecond
+		delay := max(int(r.elapsed+0.5), minGifDelay)
+		r.anim.Delay[n-1] = delay
+		r.elapsed -= float64(delay)
+	}
+	r.lastFrame = frames
+
+	frame := r.ppu.upscaledFrame(r.upscaler)
+	img := image.NewPaletted(image.Rect(0, 0, frame.Width*r.scale, frame.Height*r.scale), screenPalette())
+	for y := 0; y < frame.Height*r.scale; y++ {
+		for x := 0; x < frame.Width*r.scale; x++ {
+			img.SetColorIndex(x, y, uint8(frame.At(x/r.scale, y/r.scale)))
+		}
+	}
+	r.anim.Image = append(r.anim.Image, img)
+	r.anim.Delay = append(r.anim.Delay, max(int(float64(r.skip)*100/framesPerSecond+0.5), minGifDelay))
+}
+
+func (r *GifRecorder) Frames() int {
+	return len(r.anim.Image)
+}
+
+// Writes the GIF. Nothing is written if no frames were captured
+func (r *GifRecorder) Stop() error {
+	if len(r.anim.Image) == 0 {
+		return fmt.Errorf("no frames recorded")
+	}
+
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(f, &r.anim); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// garboy-20060102-150405.ext in the working directory
+func captureName(ext string) string {
+	return fmt.Sprintf("garboy-%s.%s", time.Now().Format("20060102-150405"), ext)
+}
@@ -1,21 +1,73 @@
 package display
 
 import (
+	"fmt"
+	"image"
 	"image/color"
+	"os"
 
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 )
 
 const (
 	Scale         = 3
 	DisplayWidth  = ScreenWidth * Scale
 	DisplayHeight = ScreenHeight * Scale
+
+	// How much of the previous frame F6 leaves on screen
+	defaultBlend = 0.5
+)
+
+// What the window shows. F1 is the game, F2-F5 are the VRAM and OAM viewers
+type View int
+
+const (
+	GameView View = iota
+	TilesView
+	TileMap0View
+	TileMap1View
+	OamView
 )
 
+var viewKeys = map[ebiten.Key]View{
+	ebiten.KeyF1: GameView,
+	ebiten.KeyF2: TilesView,
+	ebiten.KeyF3: TileMap0View,
+	ebiten.KeyF4: TileMap1View,
+	ebiten.KeyF5: OamView,
+}
+
+// Palettes the tile viewer cycles through with P
+var tilePalettes = []struct {
+	name string
+	read func(p *PPU) uint8
+}{
+	{"BGP", func(p *PPU) uint8 { return p.bgp }},
+	{"OBP0", func(p *PPU) uint8 { return p.obp0 }},
+	{"OBP1", func(p *PPU) uint8 { return p.obp1 }},
+	{"raw", func(p *PPU) uint8 { return 0xE4 }},
+}
+
 type Display struct {
 	ppu    *PPU
 	joypad *Joypad
 	screen *ebiten.Image
+	scale  int
+	filter *PostFilter
+
+	view        View
+	tilePalette int
+
+	captureScale int
+	gifSkip      int
+	recorder     *GifRecorder
+
+	palettes     []Palette
+	paletteIndex int
+
+	tilt TiltInput
 }
 
 func NewDisplay(ppu *PPU, joypad *Joypad) *Display {
@@ -23,11 +75,66 @@ func NewDisplay(ppu *PPU, joypad *Joypad) *Display {
 		ppu:    ppu,
 		joypad: joypad,
 		screen: ebiten.NewImage(ScreenWidth, ScreenHeight),
+		scale:  Scale,
+		filter: NewPostFilter(Scale, FilterOptions{}),
+
+		captureScale: 1,
+		gifSkip:      2,
+
+		palettes: Presets(),
+	}
+}
+
+// Switches to palette, adding it to the ones F9 cycles through if it isn't there yet
+func (d *Display) UsePalette(palette Palette) {
+	d.paletteIndex = -1
+	for i, p := range d.palettes {
+		if p.Name == palette.Name {
+			d.palettes[i] = palette
+			d.paletteIndex = i
+		}
 	}
+	if d.paletteIndex < 0 {
+		d.palettes = append(d.palettes, palette)
+		d.paletteIndex = len(d.palettes) - 1
+	}
+	SetPalette(palette)
+}
+
+// F12 saves a PNG screenshot and F11 starts and stops a GIF recording, both scaled by scale.
+// GIFs keep every gifSkip-th frame
+func (d *Display) SetCaptureOptions(scale int, gifSkip int) {
+	d.captureScale = max(scale, 1)
+	d.gifSkip = max(gifSkip, 1)
+}
+
+// Window size as a multiple of the 160x144 screen. Call before RunDisplay
+func (d *Display) SetScale(scale int) {
+	d.scale = max(scale, 1)
+	d.SetFilter(d.filter.Options())
+}
+
+// Frame blending and post filters for the game view. F6 toggles blending, F7 cycles through no
+// overlay, grid and scanlines and F8 toggles smooth scaling
+func (d *Display) SetFilter(opts FilterOptions) {
+	d.filter = NewPostFilter(d.scale, opts)
+}
+
+// Tilt from the keyboard, mouse or gamepad, for accelerometer cartridges
+func (d *Display) Tilt() *TiltInput {
+	return &d.tilt
+}
+
+func (d *Display) width() int {
+	return ScreenWidth * d.scale
+}
+
+func (d *Display) height() int {
+	return ScreenHeight * d.scale
 }
 
 func RunDisplay(display *Display) {
-	ebiten.SetWindowSize(DisplayWidth, DisplayHeight)
+	ebiten.SetWindowSize(display.width(), display.height())
 	ebiten.SetWindowTitle("Garboy")
 
 	if err := ebiten.RunGame(display); err != nil {
@@ -36,46 +143,173 @@ func RunDisplay(display *Display) {
 }
 
 func (d *Display) Draw(screen *ebiten.Image) {
+	if d.view != GameView {
+		d.drawViewer(screen)
+		return
+	}
+
 	d.updateScreen()
 
+	// The filtered frame can be smaller or, with a big upscaler, larger than the window
+	scale := float64(d.width()) / float64(d.screen.Bounds().Dx())
 	options := &ebiten.DrawImageOptions{}
-	options.GeoM.Scale(Scale, Scale)
+	options.GeoM.Scale(scale, scale)
 	screen.DrawImage(d.screen, options)
 }
 
 func (d *Display) Update() error {
 	d.joypad.Update()
+	d.tilt.update(d.width(), d.height())
+
+	for key, view := range viewKeys {
+		if inpututil.IsKeyJustPressed(key) {
+			d.view = view
+		}
+	}
+	if d.view == GameView {
+		d.ppu.closeViewers()
+	}
+	if d.view == TilesView && inpututil.IsKeyJustPressed(ebiten.KeyP) {
+		d.tilePalette = (d.tilePalette + 1) % len(tilePalettes)
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyF9) {
+		d.paletteIndex = (d.paletteIndex + 1) % len(d.palettes)
+		SetPalette(d.palettes[d.paletteIndex])
+		fmt.Fprintln(os.Stderr, "palette", d.palettes[d.paletteIndex].Name)
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyF10) {
+		upscaler := (CurrentUpscaler() + 1) % Upscaler(len(upscalerNames))
+		SetUpscaler(upscaler)
+		fmt.Fprintln(os.Stderr, "upscaler", upscaler)
+	}
+
+	d.updateFilterKeys()
+	d.updateCapture()
 	return nil
 }
 
+func (d *Display) updateFilterKeys() {
+	opts := d.filter.Options()
+	switch {
+	case inpututil.IsKeyJustPressed(ebiten.KeyF6):
+		if opts.Blend > 0 {
+			opts.Blend = 0
+		} else {
+			opts.Blend = defaultBlend
+		}
+	case inpututil.IsKeyJustPressed(ebiten.KeyF7):
+		// None, grid, scanlines
+		opts.Grid, opts.Scanlines = !opts.Grid && !opts.Scanlines, opts.Grid
+	case inpututil.IsKeyJustPressed(ebiten.KeyF8):
+		opts.Smooth = !opts.Smooth
+	default:
+		return
+	}
+	d.SetFilter(opts)
+	fmt.Fprintf(os.Stderr, "blend %.2f, grid %t, scanlines %t, smooth %t\n", opts.Blend, opts.Grid, opts.Scanlines, opts.Smooth)
+}
+
+func (d *Display) updateCapture() {
+	if inpututil.IsKeyJustPressed(ebiten.KeyF12) {
+		path := captureName("png")
+		if err := d.ppu.SaveScreenshot(path, d.captureScale); err != nil {
+			fmt.Fprintln(os.Stderr, "screenshot:", err)
+		} else {
+			fmt.Fprintln(os.Stderr, "saved", path)
+		}
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyF11) {
+		if d.recorder == nil {
+			d.recorder = NewGifRecorder(d.ppu, captureName("gif"), d.captureScale, d.gifSkip)
+			fmt.Fprintln(os.Stderr, "recording GIF, press F11 again to stop")
+		} else {
+			if err := d.recorder.Stop(); err != nil {
+				fmt.Fprintln(os.Stderr, "GIF:", err)
+			} else {
+				fmt.Fprintf(os.Stderr, "saved %s (%d frames)\n", d.recorder.path, d.recorder.Frames())
+			}
+			d.recorder = nil
+		}
+	}
+
+	if d.recorder != nil {
+		d.recorder.Capture()
+	}
+}
+
+// The viewers draw from a snapshot of the last frame since the emulator keeps running
+func (d *Display) drawViewer(screen *ebiten.Image) {
+	ppu := d.ppu.ViewerSnapshot()
+	var img *image.RGBA
+	var title string
+	switch d.view {
+	case TilesView:
+		palette := tilePalettes[d.tilePalette]
+		img = ppu.TileSheet(palette.read(ppu))
+		title = fmt.Sprintf("Tiles (%s, P to change)", palette.name)
+	case TileMap0View:
+		img = ppu.TileMap(TileMap0)
+		title = "BG map 9800"
+	case TileMap1View:
+		img = ppu.TileMap(TileMap1)
+		title = "BG map 9C00"
+	case OamView:
+		d.drawOamViewer(screen, ppu)
+		return
+	}
+
+	drawFitted(screen, img, 0, 0, d.width(), d.height())
+	ebitenutil.DebugPrint(screen, title)
+}
+
+// Sprite sheet on the left and the decoded entries in two columns next to it
+func (d *Display) drawOamViewer(screen *ebiten.Image, ppu *PPU) {
+	const sheetWidth = 144
+	const columnWidth = 168
+	const lineHeight = 16
+
+	drawFitted(screen, ppu.OamSheet(), 0, lineHeight, sheetWidth, d.height()-lineHeight)
+	ebitenutil.DebugPrint(screen, "OAM")
+
+	half := MaxSprites / 2
+	for i, entry := range ppu.OamEntries() {
+		x := sheetWidth + (i/half)*columnWidth
+		y := (i % half) * lineHeight
+		ebitenutil.DebugPrintAt(screen, entry.String(), x, y)
+	}
+}
+
+// Scales img by a whole number so it fits in the given area
+func drawFitted(screen *ebiten.Image, img image.Image, x, y, width, height int) {
+	bounds := img.Bounds()
+	scale := max(1, min(width/bounds.Dx(), height/bounds.Dy()))
+
+	options := &ebiten.DrawImageOptions{}
+	options.GeoM.Scale(float64(scale), float64(scale))
+	options.GeoM.Translate(float64(x), float64(y))
+
+	source := ebiten.NewImageFromImage(img)
+	screen.DrawImage(source, options)
+	source.Dispose()
+}
+
 func (d *Display) Layout(outsideWidth int, outsideHeight int) (int, int) {
-	return DisplayWidth, DisplayHeight
+	return d.width(), d.height()
 }
 
 func (d *Display) updateScreen() {
-	d.ppu.mu.Lock()
-	defer d.ppu.mu.Unlock()
-
-	framebuffer := d.ppu.GetFrameBuffer()
-	for y := 0; y < ScreenHeight; y++ {
-		for x := 0; x < ScreenWidth; x++ {
-			gbColor := framebuffer[y][x]
-			rgbaColor := gameBoyColorToRgba(gbColor)
-			d.screen.Set(x, y, rgbaColor)
-		}
+	frame := d.ppu.copyFrame()
+	img := d.filter.Apply(&frame)
+	if d.screen.Bounds() != img.Bounds() {
+		d.screen.Dispose()
+		d.screen = ebiten.NewImage(img.Bounds().Dx(), img.Bounds().Dy())
 	}
+	d.screen.WritePixels(img.Pix)
 }
+
 func gameBoyColorToRgba(gbColor Color) color.RGBA {
-	switch gbColor {
-	case 0:
-		return color.RGBA{197, 219, 212, 255}
-	case 1:
-		return color.RGBA{119, 142, 152, 255}
-	case 2:
-		return color.RGBA{65, 72, 93, 255}
-	case 3:
-		return color.RGBA{34, 30, 49, 255}
-	default:
-		return color.RGBA{0, 0, 0, 255}
-	}
+	return currentPalette.Load().color(gbColor)
 }
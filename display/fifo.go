@@ -0,0 +1,375 @@
+package display
+
+import (
+	"garboy/utils"
+)
+
+const (
+	// Each fetcher step (tile number, data low, data high) takes 2 dots
+	fetcherStepDots  = 2
+	spriteFetchDots  = 6
+	windowXOffset    = 7
+	maxSpriteX       = 168
+	bgFifoSize       = 16
+	spriteFifoSize   = 8
+	fetcherReadyStep = 3 // Tile data fetched, waiting for the BG FIFO to empty
+	noSpriteFetch    = -1
+)
+
+type bgPixel struct {
+	color Color // Raw color index before BGP
+}
+
+type spritePixel struct {
+	color      Color // Raw color index before OBP0/OBP1, 0 is transparent
+	obp1       bool
+	bgPriority bool // BG colors 1-3 are drawn over this pixel
+}
+
+// Background/window fetcher and the two pixel FIFOs used during mode 3
+type pixelFifo struct {
+	bg      [bgFifoSize]bgPixel
+	bgHead  int
+	bgLen   int
+	obj     [spriteFifoSize]spritePixel
+	objHead int
+	objLen  int
+
+	// Fetcher
+	step      int
+	stepDots  int
+	fetchX    uint8 // Tile column within the BG or window map
+	tileIndex uint8
+	dataLow   uint8
+	dataHigh  uint8
+
+	lcdX        int  // Next pixel to be drawn on screen
+	discard     int  // Pixels dropped for SCX fine scroll or WX < 7
+	warmedUp    bool // The first fetch of every line has been thrown away
+	window      bool // Fetching from the window map
+	drewWindow  bool
+	sprites     []Sprite
+	fetched     [SpritesPerLine]bool
+	spriteFetch int // Index into sprites being fetched, or noSpriteFetch
+	spriteDots  int
+}
+
+// Resets the fetcher and FIFOs at the start of mode 3. Sprites are the ones found during OAM scan
+func (p *PPU) startPixelTransfer() {
+	f := &p.fifo
+	f.bgHead, f.bgLen = 0, 0
+	f.objHead, f.objLen = 0, 0
+	f.step, f.stepDots = 0, 0
+	f.fetchX = 0
+	f.lcdX = 0
+	f.discard = int(p.scx & 7)
+	f.warmedUp = false
+	f.window = false
+	f.drewWindow = false
+	f.spriteFetch = noSpriteFetch
+	f.spriteDots = 0
+	f.sprites = p.scanOam(f.sprites[:0])
+	for i := range f.fetched {
+		f.fetched[i] = false
+	}
+
+	if p.ly == p.wy {
+		p.wyTriggered = true
+	}
+}
+
+// Up to 10 sprites overlapping the current line, in OAM order
+func (p *PPU) scanOam(sprites []Sprite) []Sprite {
+	if p.oamDma {
+		return sprites
+	}
+
+	spriteHeight := p.getSpriteHeight()
+	for spriteIndex := 0; spriteIndex < MaxSprites && len(sprites) < SpritesPerLine; spriteIndex++ {
+		sprite := p.getSprite(spriteIndex)
+		if p.isSpriteOnCurrentScanline(sprite, spriteHeight) {
+			sprites = append(sprites, sprite)
+		}
+	}
+	return sprites
+}
+
+// Advances mode 3 by one dot. Returns true once all 160 pixels of the line have been drawn
+func (p *PPU) stepPixelTransfer() bool {
+	f := &p.fifo
+
+	if f.spriteFetch != noSpriteFetch {
+		p.stepSpriteFetch()
+		return false
+	}
+
+	p.stepFetcher()
+
+	if f.bgLen == 0 {
+		return false
+	}
+
+	if !f.window && p.windowStartsAt(f.lcdX) {
+		p.startWindow()
+		return false
+	}
+
+	// A sprite starting here pauses pixel output. The fetcher has already used this dot, but
+	// an idle fetcher lets the sprite fetch start straight away
+	if f.discard == 0 {
+		if i := p.nextSprite(); i != noSpriteFetch {
+			f.spriteFetch = i
+			f.spriteDots = 0
+			if f.step == fetcherReadyStep {
+				f.spriteDots = 1
+			}
+			return false
+		}
+	}
+
+	pixel := f.popBg()
+	if f.discard > 0 {
+		f.discard--
+		return false
+	}
+
+	p.drawPixel(pixel)
+	f.lcdX++
+	if f.lcdX < ScreenWidth {
+		return false
+	}
+
+	if f.drewWindow {
+		p.windowLineCounter++
+	}
+	return true
+}
+
+func (p *PPU) stepFetcher() {
+	f := &p.fifo
+
+	if f.step == fetcherReadyStep {
+		p.pushTile()
+		return
+	}
+
+	f.stepDots++
+	if f.stepDots < fetcherStepDots {
+		return
+	}
+	f.stepDots = 0
+
+	switch f.step {
+	case 0:
+		f.tileIndex = p.readVram(p.fetcherTileMapAddress())
+	case 1:
+		f.dataLow = p.readVram(p.fetcherTileDataAddress())
+	case 2:
+		f.dataHigh = p.readVram(p.fetcherTileDataAddress() + 1)
+	}
+	f.step++
+
+	// The first fetch of the line is thrown away as soon as it completes
+	if f.step == fetcherReadyStep && !f.warmedUp {
+		f.warmedUp = true
+		f.step = 0
+	}
+}
+
+// Pushes the fetched row of 8 pixels once the BG FIFO is empty
+func (p *PPU) pushTile() {
+	f := &p.fifo
+	if f.bgLen > 0 {
+		return
+	}
+
+	// The next tile's fetch starts on the same dot
+	f.step, f.stepDots = 0, 1
+	for bit := 7; bit >= 0; bit-- {
+		color := Color(((f.dataHigh>>bit)&1)<<1 | (f.dataLow>>bit)&1)
+		f.bg[(f.bgHead+f.bgLen)%bgFifoSize] = bgPixel{color: color}
+		f.bgLen++
+	}
+	f.fetchX++
+}
+
+func (p *PPU) fetcherTileMapAddress() uint16 {
+	f := &p.fifo
+	if f.window {
+		row := uint16(p.windowLineCounter) / TileSize
+		col := uint16(f.fetchX) % TileMapSize
+		return p.getWindowTileMapBase() + row*TileMapSize + col
+	}
+
+	row := uint16(p.ly+p.scy) / TileSize
+	col := (uint16(p.scx/TileSize) + uint16(f.fetchX)) % TileMapSize
+	return p.getBackgroundTileMapBase() + row*TileMapSize + col
+}
+
+func (p *PPU) fetcherTileDataAddress() uint16 {
+	f := &p.fifo
+	line := uint16((p.ly + p.scy) % TileSize)
+	if f.window {
+		line = uint16(p.windowLineCounter % TileSize)
+	}
+	return p.getTileDataAddress(f.tileIndex) + line*2
+}
+
+// Window starts once WY has matched LY this frame and the next pixel reaches WX-7
+func (p *PPU) windowStartsAt(x int) bool {
+	if !utils.IsBitSet(p.lcdc, WindowEnable) || !p.wyTriggered || p.wx > 166 {
+		return false
+	}
+	return x >= int(p.wx)-windowXOffset
+}
+
+// Throws away the BG pixels and restarts the fetcher on the window map
+func (p *PPU) startWindow() {
+	f := &p.fifo
+	f.window = true
+	f.drewWindow = true
+	f.fetchX = 0
+	f.bgHead, f.bgLen = 0, 0
+	f.step, f.stepDots = 0, 1
+
+	f.discard = 0
+	if p.wx < windowXOffset && f.lcdX == 0 {
+		f.discard = int(windowXOffset - p.wx)
+	}
+}
+
+// Sprite that starts at the current pixel and hasn't been fetched yet
+func (p *PPU) nextSprite() int {
+	f := &p.fifo
+	if !utils.IsBitSet(p.lcdc, SpriteEnable) {
+		return noSpriteFetch
+	}
+
+	for i, sprite := range f.sprites {
+		if f.fetched[i] || sprite.x >= maxSpriteX {
+			continue
+		}
+		if int(sprite.x) <= f.lcdX+8 {
+			return i
+		}
+	}
+	return noSpriteFetch
+}
+
+// Pixel output stops until the BG fetcher has finished its current tile, then the sprite row is
+// fetched in another 6 dots and merged into the sprite FIFO
+func (p *PPU) stepSpriteFetch() {
+	f := &p.fifo
+
+	// The sprite fetch overlaps the last dot of the BG fetch
+	if f.step < fetcherReadyStep {
+		p.stepFetcher()
+		if f.step < fetcherReadyStep {
+			return
+		}
+	}
+
+	f.spriteDots++
+	if f.spriteDots < spriteFetchDots {
+		return
+	}
+
+	sprite := f.sprites[f.spriteFetch]
+	f.fetched[f.spriteFetch] = true
+	f.spriteFetch = noSpriteFetch
+	p.mergeSprite(sprite)
+}
+
+func (p *PPU) mergeSprite(sprite Sprite) {
+	f := &p.fifo
+	spriteHeight := p.getSpriteHeight()
+	spriteLine := int(p.ly) - (int(sprite.y) - 16)
+
+	if utils.IsBitSet(sprite.flags, SpriteYFlip) {
+		spriteLine = spriteHeight - 1 - spriteLine
+	}
+
+	tileIndex := sprite.tileIndex
+	if spriteHeight == 16 {
+		tileIndex &= 0xFE
+		if spriteLine >= 8 {
+			tileIndex |= 0x01
+			spriteLine -= 8
+		}
+	}
+
+	for f.objLen < spriteFifoSize {
+		f.obj[(f.objHead+f.objLen)%spriteFifoSize] = spritePixel{}
+		f.objLen++
+	}
+
+	// Sprites hanging off the left edge lose their first 8-X pixels
+	skip := max(0, f.lcdX+8-int(sprite.x))
+	for pixelX := skip; pixelX < 8; pixelX++ {
+		spritePixelX := pixelX
+		if utils.IsBitSet(sprite.flags, SpriteXFlip) {
+			spritePixelX = 7 - pixelX
+		}
+
+		color := p.getSpriteTilePixel(tileIndex, spritePixelX, spriteLine)
+		slot := &f.obj[(f.objHead+pixelX-skip)%spriteFifoSize]
+
+		// Sprites fetched earlier have a lower X or OAM index and win
+		if color.isTransparent() || !slot.color.isTransparent() {
+			continue
+		}
+		*slot = spritePixel{
+			color:      color,
+			obp1:       utils.IsBitSet(sprite.flags, SpritePalette),
+			bgPriority: utils.IsBitSet(sprite.flags, SpritePriority),
+		}
+	}
+}
+
+func (f *pixelFifo) popBg() bgPixel {
+	pixel := f.bg[f.bgHead]
+	f.bgHead = (f.bgHead + 1) % bgFifoSize
+	f.bgLen--
+	return pixel
+}
+
+func (f *pixelFifo) popSprite() (spritePixel, bool) {
+	if f.objLen == 0 {
+		return spritePixel{}, false
+	}
+	pixel := f.obj[f.objHead]
+	f.objHead = (f.objHead + 1) % spriteFifoSize
+	f.objLen--
+	return pixel, true
+}
+
+// Mixes the BG and sprite pixels using the palettes and LCDC as they are right now
+func (p *PPU) drawPixel(bg bgPixel) {
+	f := &p.fifo
+
+	bgColor := bg.color
+	if !utils.IsBitSet(p.lcdc, BgWindowEnable) {
+		bgColor = 0
+	}
+
+	sprite, ok := f.popSprite()
+	if ok && !sprite.color.isTransparent() && utils.IsBitSet(p.lcdc, SpriteEnable) &&
+		!(sprite.bgPriority && bgColor != 0) {
+		palette, source := p.obp0, Color(Obj0Source)
+		if sprite.obp1 {
+			palette, source = p.obp1, Obj1Source
+		}
+		p.backBuffer[p.ly][f.lcdX] = applyPalette(palette, sprite.color) | source
+		return
+	}
+
+	if !utils.IsBitSet(p.lcdc, BgWindowEnable) {
+		p.backBuffer[p.ly][f.lcdX] = 0
+		return
+	}
+	p.backBuffer[p.ly][f.lcdX] = applyPalette(p.bgp, bgColor)
+}
+
+func applyPalette(palette uint8, color Color) Color {
+	return Color((palette >> (color * 2)) & 3)
+}
@@ -0,0 +1,245 @@
+package display
+
+import (
+	"image"
+)
+
+const (
+	// Brightness out of 256 for the darkened rows and columns
+	gridShade     = 192
+	scanlineShade = 150
+	fullShade     = 256
+)
+
+type FilterOptions struct {
+	Blend     float64 // 0-1, how much of the previous frame is still on screen, like a DMG LCD
+	Grid      bool    // Darken the edges of every pixel
+	Scanlines bool    // Darken the bottom row of every pixel
+	Smooth    bool    // Bilinear instead of nearest neighbour scaling
+}
+
+// Turns frames into RGBA on the CPU. Blending happens after the current upscaler and the other
+// filters need scaling, so they run at scale times the screen size
+type PostFilter struct {
+	opts  FilterOptions
+	scale int
+
+	upscaler Upscaler
+	factor   int // Upscaler.Factor
+	size     int // Output pixels per screen pixel, at least factor
+	upscaled UpscaledFrame
+
+	blend   uint16 // Weight of the previous frame out of 256
+	native  *image.RGBA
+	hasPrev bool
+	out     *image.RGBA
+
+	rowShade []uint16
+	colShade []uint16
+
+	// Sources per output row and column, and for bilinear the weight out of 256 of the next one
+	rowSrc    []int
+	rowWeight []uint16
+	colSrc    []int
+	colWeight []uint16
+	wide      []uint8 // Upscaled rows stretched to the output width
+}
+
+func NewPostFilter(scale int, opts FilterOptions) *PostFilter {
+	f := &PostFilter{
+		opts:  opts,
+		scale: max(scale, 1),
+	}
+	f.blend = uint16(min(max(opts.Blend, 0), 0.99) * 256)
+	f.setUpscaler(CurrentUpscaler())
+	return f
+}
+
+// Sizes everything for u and starts blending over
+func (f *PostFilter) setUpscaler(u Upscaler) {
+	f.upscaler = u
+	f.factor = u.Factor()
+	f.size = max(f.scale, f.factor)
+	f.native = image.NewRGBA(image.Rect(0, 0, ScreenWidth*f.factor, ScreenHeight*f.factor))
+	f.hasPrev = false
+	f.out = nil
+
+	if !f.Scaled() {
+		return
+	}
+
+	width, height := ScreenWidth*f.size, ScreenHeight*f.size
+	f.out = image.NewRGBA(image.Rect(0, 0, width, height))
+	f.rowShade = f.shades(height, f.opts.Grid, f.opts.Scanlines)
+	f.colShade = f.shades(width, f.opts.Grid, false)
+	f.rowSrc, f.rowWeight = f.samples(height, ScreenHeight*f.factor)
+	f.colSrc, f.colWeight = f.samples(width, ScreenWidth*f.factor)
+	if f.opts.Smooth {
+		f.wide = make([]uint8, ScreenHeight*f.factor*width*4)
+	}
+}
+
+func (f *PostFilter) Options() FilterOptions {
+	return f.opts
+}
+
+// True when Apply scales the frame itself, otherwise it returns the frame at the upscaler's size
+// and scaling is left to the caller
+func (f *PostFilter) Scaled() bool {
+	return f.size > f.factor && (f.opts.Grid || f.opts.Scanlines || f.opts.Smooth)
+}
+
+// The returned image is reused by the next call. Its size changes with the current upscaler
+func (f *PostFilter) Apply(frame *[ScreenHeight][ScreenWidth]Color) *image.RGBA {
+	if u := CurrentUpscaler(); u != f.upscaler {
+		f.setUpscaler(u)
+	}
+
+	f.upscaler.Apply(frame, &f.upscaled)
+	f.blendFrame()
+	if !f.Scaled() {
+		return f.native
+	}
+
+	if f.opts.Smooth {
+		f.scaleSmooth()
+	} else {
+		f.scaleNearest()
+	}
+	return f.out
+}
+
+// Mixes the new frame into the last one, so pixels fade in and out over a few frames
+func (f *PostFilter) blendFrame() {
+	palette := currentPalette.Load()
+	blend := f.blend
+	if !f.hasPrev {
+		blend = 0
+		f.hasPrev = true
+	}
+
+	pix := f.native.Pix
+	for y := 0; y < f.upscaled.Height; y++ {
+		row := pix[y*f.native.Stride:]
+		for x, c := range f.upscaled.Pix[y*f.upscaled.Width : (y+1)*f.upscaled.Width] {
+			rgba := palette.color(c)
+			i := x * 4
+			row[i] = mix(rgba.R, row[i], blend)
+			row[i+1] = mix(rgba.G, row[i+1], blend)
+			row[i+2] = mix(rgba.B, row[i+2], blend)
+			row[i+3] = 0xFF
+		}
+	}
+}
+
+// Moves prev towards cur, rounding away from prev so a still image always settles
+func mix(cur, prev uint8, weight uint16) uint8 {
+	step := (int(cur) - int(prev)) * int(256-weight)
+	if step > 0 {
+		step = (step + 255) >> 8
+	} else {
+		step = -((-step + 255) >> 8)
+	}
+	return uint8(int(prev) + step)
+}
+
+func (f *PostFilter) scaleNearest() {
+	src, dst := f.native.Pix, f.out.Pix
+	for y := 0; y < f.out.Rect.Dy(); y++ {
+		srcRow := src[f.rowSrc[y]*f.native.Stride:]
+		dstRow := dst[y*f.out.Stride:]
+		rowShade := uint32(f.rowShade[y])
+		for x := 0; x < f.out.Rect.Dx(); x++ {
+			s := f.colSrc[x] * 4
+			shade := rowShade * uint32(f.colShade[x])
+			d := x * 4
+			dstRow[d] = uint8(uint32(srcRow[s]) * shade >> 16)
+			dstRow[d+1] = uint8(uint32(srcRow[s+1]) * shade >> 16)
+			dstRow[d+2] = uint8(uint32(srcRow[s+2]) * shade >> 16)
+			dstRow[d+3] = 0xFF
+		}
+	}
+}
+
+// Bilinear in two passes: every upscaled row is stretched horizontally once, then output rows
+// mix the two stretched rows around them
+func (f *PostFilter) scaleSmooth() {
+	width, srcWidth, srcHeight := f.out.Rect.Dx(), f.native.Rect.Dx(), f.native.Rect.Dy()
+	src := f.native.Pix
+	for y := 0; y < srcHeight; y++ {
+		srcRow := src[y*f.native.Stride:]
+		wide := f.wide[y*width*4:]
+		for x := 0; x < width; x++ {
+			left := f.colSrc[x] * 4
+			right := left
+			if f.colSrc[x]+1 < srcWidth {
+				right += 4
+			}
+			wx := uint32(f.colWeight[x])
+			d := x * 4
+			for c := 0; c < 3; c++ {
+				wide[d+c] = uint8((uint32(srcRow[left+c])*(256-wx) + uint32(srcRow[right+c])*wx) >> 8)
+			}
+		}
+	}
+
+	dst := f.out.Pix
+	for y := 0; y < f.out.Rect.Dy(); y++ {
+		top := f.wide[f.rowSrc[y]*width*4:]
+		bottom := top
+		if f.rowSrc[y]+1 < srcHeight {
+			bottom = f.wide[(f.rowSrc[y]+1)*width*4:]
+		}
+		wy := uint32(f.rowWeight[y])
+		dstRow := dst[y*f.out.Stride:]
+		rowShade := uint32(f.rowShade[y])
+
+		for x := 0; x < width; x++ {
+			shade := rowShade * uint32(f.colShade[x])
+			d := x * 4
+			for c := 0; c < 3; c++ {
+				val := (uint32(top[d+c])*(256-wy) + uint32(bottom[d+c])*wy) >> 8
+				dstRow[d+c] = uint8(val * shade >> 16)
+			}
+			dstRow[d+3] = 0xFF
+		}
+	}
+}
+
+// Brightness per output row or column. Grids and scanlines need at least 2 output pixels per
+// screen pixel to leave something undarkened
+func (f *PostFilter) shades(length int, grid bool, scanlines bool) []uint16 {
+	shades := make([]uint16, length)
+	for i := range shades {
+		shades[i] = fullShade
+		if f.size < 2 || i%f.size != f.size-1 {
+			continue
+		}
+		if grid {
+			shades[i] = shades[i] * gridShade / fullShade
+		}
+		if scanlines {
+			shades[i] = shades[i] * scanlineShade / fullShade
+		}
+	}
+	return shades
+}
+
+// Nearest neighbour sources, or with smoothing bilinear ones where pixel centers line up between
+// both sizes
+func (f *PostFilter) samples(length int, srcLength int) ([]int, []uint16) {
+	src := make([]int, length)
+	weight := make([]uint16, length)
+	ratio := float64(srcLength) / float64(length)
+	for i := range src {
+		if !f.opts.Smooth {
+			src[i] = i * srcLength / length
+			continue
+		}
+		pos := (float64(i)+0.5)*ratio - 0.5
+		pos = min(max(pos, 0), float64(srcLength-1))
+		src[i] = int(pos)
+		weight[i] = uint16((pos - float64(src[i])) * 256)
+	}
+	return src, weight
+}
@@ -0,0 +1,194 @@
+package display
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Framebuffer colors keep the palette register they went through in bits 2-3 so BG, OBJ0 and
+// OBJ1 pixels can be shown with different colors
+const (
+	ShadeMask  = 0x03
+	Obj0Source = 1 << 2
+	Obj1Source = 2 << 2
+)
+
+// Screen colors for the four shades of BGP, OBP0 and OBP1, lightest first
+type Palette struct {
+	Name string
+	BG   [4]color.RGBA
+	Obj0 [4]color.RGBA
+	Obj1 [4]color.RGBA
+}
+
+func uniformPalette(name string, shades [4]color.RGBA) Palette {
+	return Palette{Name: name, BG: shades, Obj0: shades, Obj1: shades}
+}
+
+func rgb(hex uint32) color.RGBA {
+	return color.RGBA{uint8(hex >> 16), uint8(hex >> 8), uint8(hex), 255}
+}
+
+var presets = []Palette{
+	uniformPalette("default", [4]color.RGBA{rgb(0xC5DBD4), rgb(0x778E98), rgb(0x41485D), rgb(0x221E31)}),
+	uniformPalette("dmg", [4]color.RGBA{rgb(0x9BBC0F), rgb(0x8BAC0F), rgb(0x306230), rgb(0x0F380F)}),
+	uniformPalette("pocket", [4]color.RGBA{rgb(0xC4CFA1), rgb(0x8B956D), rgb(0x4D533C), rgb(0x1F1F1F)}),
+	uniformPalette("light", [4]color.RGBA{rgb(0x00B581), rgb(0x009A71), rgb(0x00694A), rgb(0x004F3B)}),
+	uniformPalette("contrast", [4]color.RGBA{rgb(0xFFFFFF), rgb(0xAAAAAA), rgb(0x555555), rgb(0x000000)}),
+}
+
+var currentPalette atomic.Pointer[Palette]
+
+func init() {
+	SetPalette(presets[0])
+}
+
+// The built in palettes, default first
+func Presets() []Palette {
+	return append([]Palette(nil), presets...)
+}
+
+func PresetPalette(name string) (Palette, bool) {
+	for _, preset := range presets {
+		if strings.EqualFold(preset.Name, name) {
+			return preset, true
+		}
+	}
+	return Palette{}, false
+}
+
+// Used by the window, screenshots, recordings and viewers from now on
+func SetPalette(palette Palette) {
+	currentPalette.Store(&palette)
+}
+
+func CurrentPalette() Palette {
+	return *currentPalette.Load()
+}
+
+func (p *Palette) color(c Color) color.RGBA {
+	shade := c & ShadeMask
+	switch c &^ ShadeMask {
+	case Obj0Source:
+		return p.Obj0[shade]
+	case Obj1Source:
+		return p.Obj1[shade]
+	default:
+		return p.BG[shade]
+	}
+}
+
+// JSON palette file, e.g. {"name": "red", "bg": ["#FFFFFF", "#FF8484", "#943A3A", "#000000"]}.
+// obj0 and obj1 default to bg, and obj1 to obj0
+type paletteFile struct {
+	Name string   `json:"name"`
+	BG   []string `json:"bg"`
+	Obj0 []string `json:"obj0"`
+	Obj1 []string `json:"obj1"`
+}
+
+// Loads a palette from a .json file, or from a text file with lines like
+//
+//	bg:   FFFFFF FF8484 943A3A 000000
+//	obj0: ...
+//
+// The name defaults to the file name
+func LoadPalette(path string) (Palette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Palette{}, err
+	}
+
+	var file paletteFile
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, &file); err != nil {
+			return Palette{}, fmt.Errorf("%s: %w", path, err)
+		}
+	} else if file, err = parsePaletteText(string(data)); err != nil {
+		return Palette{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if file.Name == "" {
+		file.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if file.Obj0 == nil {
+		file.Obj0 = file.BG
+	}
+	if file.Obj1 == nil {
+		file.Obj1 = file.Obj0
+	}
+
+	palette := Palette{Name: file.Name}
+	for _, group := range []struct {
+		name   string
+		colors []string
+		dst    *[4]color.RGBA
+	}{
+		{"bg", file.BG, &palette.BG},
+		{"obj0", file.Obj0, &palette.Obj0},
+		{"obj1", file.Obj1, &palette.Obj1},
+	} {
+		if len(group.colors) != 4 {
+			return Palette{}, fmt.Errorf("%s: %s needs 4 colors, got %d", path, group.name, len(group.colors))
+		}
+		for i, s := range group.colors {
+			c, err := parseHexColor(s)
+			if err != nil {
+				return Palette{}, fmt.Errorf("%s: %s: %w", path, group.name, err)
+			}
+			group.dst[i] = c
+		}
+	}
+	return palette, nil
+}
+
+// "key: value" lines. Lines starting with # are comments
+func parsePaletteText(text string) (paletteFile, error) {
+	var file paletteFile
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for line := 1; scanner.Scan(); line++ {
+		content := strings.TrimSpace(scanner.Text())
+		if content == "" || strings.HasPrefix(content, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(content, ":")
+		if !ok {
+			return file, fmt.Errorf("line %d: expected key: value", line)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "name":
+			file.Name = value
+		case "bg":
+			file.BG = strings.Fields(value)
+		case "obj0":
+			file.Obj0 = strings.Fields(value)
+		case "obj1":
+			file.Obj1 = strings.Fields(value)
+		default:
+			return file, fmt.Errorf("line %d: unknown key %q", line, key)
+		}
+	}
+	return file, scanner.Err()
+}
+
+// RRGGBB with an optional leading #
+func parseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	val, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	return rgb(uint32(val)), nil
+}
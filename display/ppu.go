@@ -2,8 +2,8 @@ package display
 
 import (
 	"fmt"
-	"sort"
 	"sync"
+	"sync/atomic"
 
 	"garboy/addresses"
 	"garboy/interrupts"
@@ -45,12 +45,18 @@ const (
 	OamMode    = 2
 	VramMode   = 3
 
-	// PPU timing in T-cycles
+	// PPU timing in T-cycles. Mode 3 is 172 dots at its shortest and is lengthened by SCX fine
+	// scroll, the window and sprites, which shortens HBlank by the same amount
 	OamScanCycles  = 80
 	VramScanCycles = 172
 	HBlankCycles   = 204
 	ScanlineCycles = 456
 	VBlankLines    = 10
+	LastLine       = 153
+	FrameCycles    = ScanlineCycles * (LastLine + 1)
+
+	// LY=LYC is compared this many dots into a line, and LY reads 0 this far into line 153
+	LyCompareDelay = 4
 
 	// Sprite flags
 	SpritePriority = 7
@@ -68,11 +74,6 @@ type Sprite struct {
 	flags     uint8
 }
 
-type SpriteWithIndex struct {
-	sprite Sprite
-	index  int
-}
-
 type PPU struct {
 	vram memory.Memory
 	oam  memory.Memory
@@ -91,13 +92,28 @@ type PPU struct {
 	wx   uint8
 
 	mode              uint8
-	cycles            uint16
+	cycles            uint16 // Dots into the current scanline
 	windowLineCounter uint8
+	wyTriggered       bool          // LY matched WY at some point this frame
+	statLine          bool          // OR of every enabled STAT interrupt source
+	lcdOnLine         bool          // First line after the LCD is turned on, which skips OAM scan
+	skipFrame         bool          // First frame after the LCD is turned on never reaches the screen
+	frames            atomic.Uint64 // Read by the display goroutine for captures
+	offDots           uint32        // Dots since the last blank frame while the LCD is off
+	frameHook         func(frame *[ScreenHeight][ScreenWidth]Color)
+	fifo              pixelFifo
+
+	// Block CPU access to VRAM in mode 3 and OAM in modes 2 and 3 like hardware does
+	restrictAccess bool
+	oamDma         bool // OAM DMA is copying, so OAM scan can't read sprites
 
 	frontBuffer *[ScreenHeight][ScreenWidth]Color
 	backBuffer  *[ScreenHeight][ScreenWidth]Color
 	mu          sync.Mutex
 
+	viewersOpen atomic.Bool // Set by the display goroutine while a viewer is shown
+	viewerState *PPU        // Copy for the viewers from the end of the last frame, guarded by mu
+
 	interrupts *interrupts.Interrupts
 }
 
@@ -113,52 +129,60 @@ func NewPPU(interrupts *interrupts.Interrupts) *PPU {
 		frontBuffer: new([ScreenHeight][ScreenWidth]Color),
 		backBuffer:  new([ScreenHeight][ScreenWidth]Color),
 		interrupts:  interrupts,
+
+		restrictAccess: true,
 	}
 }
 
 func (p *PPU) Step(cycles uint16) {
 	if !p.isLcdEnabled() {
+		p.stepLcdOff(cycles)
 		return
 	}
 
-	p.cycles += cycles
-
-	switch p.mode {
-	case OamMode:
-		p.handleOamMode()
-	case VramMode:
-		p.handleVramMode()
-	case HBlankMode:
-		p.handleHBlankMode()
-	case VBlankMode:
-		p.handleVBlankMode()
+	// Pixels are produced one dot at a time
+	for ; cycles > 0; cycles-- {
+		p.cycles++
+
+		switch p.mode {
+		case OamMode:
+			p.handleOamMode()
+		case VramMode:
+			p.handleVramMode()
+		case HBlankMode:
+			p.handleHBlankMode()
+		case VBlankMode:
+			p.handleVBlankMode()
+		}
+
+		p.updateLyCompare()
+		p.updateStatLine()
 	}
 }
 
 func (p *PPU) handleOamMode() {
 	if p.cycles >= OamScanCycles {
+		p.lcdOnLine = false
 		p.enterMode(VramMode)
+		p.startPixelTransfer()
 	}
 }
 
 func (p *PPU) handleVramMode() {
-	if p.cycles >= VramScanCycles {
+	if p.stepPixelTransfer() {
 		p.enterMode(HBlankMode)
-		p.renderScanline()
-		p.checkHBlankInterrupt()
 	}
 }
 
 func (p *PPU) handleHBlankMode() {
-	if p.cycles >= HBlankCycles {
+	if p.cycles >= ScanlineCycles {
 		p.moveToNextScanline()
 
 		if p.ly == ScreenHeight {
 			p.enterMode(VBlankMode)
-			p.checkVBlankInterrupt()
+			p.interrupts.Request(interrupts.VBlankInterrupt)
 		} else {
 			p.enterMode(OamMode)
-			p.checkOamInterrupt()
 		}
 	}
 }
@@ -167,110 +191,105 @@ func (p *PPU) handleVBlankMode() {
 	if p.cycles >= ScanlineCycles {
 		p.moveToNextScanline()
 
-		if p.ly > 153 {
-			p.mu.Lock()
-			p.frontBuffer, p.backBuffer = p.backBuffer, p.frontBuffer
-			p.mu.Unlock()
-
+		if p.ly > LastLine {
+			if p.skipFrame {
+				p.skipFrame = false
+			} else {
+				p.mu.Lock()
+				p.frontBuffer, p.backBuffer = p.backBuffer, p.frontBuffer
+				p.mu.Unlock()
+			}
+			p.finishFrame()
+
+			// LY already reads 0 and matched LYC during line 153
 			p.ly = 0
 			p.windowLineCounter = 0
+			p.wyTriggered = false
 			p.enterMode(OamMode)
-			p.updateLyc()
-			p.checkOamInterrupt()
 		}
 	}
 }
 
+// Time keeps passing with the LCD off, so blank frames are still counted every 70224 dots
+func (p *PPU) stepLcdOff(cycles uint16) {
+	p.offDots += uint32(cycles)
+	if p.offDots >= FrameCycles {
+		p.offDots -= FrameCycles
+		p.finishFrame()
+	}
+}
+
+func (p *PPU) finishFrame() {
+	p.frames.Add(1)
+	p.takeViewerSnapshot()
+	if p.frameHook != nil {
+		p.frameHook(p.frontBuffer)
+	}
+}
+
 func (p *PPU) enterMode(mode uint8) {
 	p.mode = mode
 	p.stat = (p.stat & ^uint8(ModeFlag)) | mode
-	p.cycles = 0
 }
 
+// The LY=LYC flag drops at the start of lines 1-153 and is compared again a few dots later
 func (p *PPU) moveToNextScanline() {
 	p.ly++
-	p.updateLyc()
-}
-
-func (p *PPU) updateLyc() {
-	if p.ly == p.lyc {
-		p.stat |= LycFlagBit
-		if utils.IsBitSet(p.stat, LycInterruptBit) {
-			p.interrupts.Request(interrupts.LcdInterrupt)
-		}
-	} else {
-		p.stat &= ^uint8(1 << LycFlagBit)
+	p.cycles = 0
+	if p.ly <= LastLine {
+		p.setLyCoincidence(false)
 	}
 }
 
-func (p *PPU) checkVBlankInterrupt() {
-	p.interrupts.Request(interrupts.VBlankInterrupt)
-
-	if utils.IsBitSet(p.stat, VBlankInterruptBit) {
-		p.interrupts.Request(interrupts.LcdInterrupt)
+func (p *PPU) updateLyCompare() {
+	switch {
+	case p.cycles == LyCompareDelay:
+		p.setLyCoincidence(p.ly == p.lyc)
+	case p.ly == LastLine && p.cycles == 2*LyCompareDelay:
+		// LY has read 0 since dot 4 of line 153
+		p.setLyCoincidence(p.lyc == 0)
 	}
 }
 
-func (p *PPU) checkHBlankInterrupt() {
-	if utils.IsBitSet(p.stat, HBlankInterruptBit) {
-		p.interrupts.Request(interrupts.LcdInterrupt)
+// LY as the CPU sees it. Line 153 only shows up for its first 4 dots
+func (p *PPU) readLy() uint8 {
+	if p.ly == LastLine && p.cycles >= LyCompareDelay {
+		return 0
 	}
+	return p.ly
 }
 
-func (p *PPU) checkOamInterrupt() {
-	if utils.IsBitSet(p.stat, OamInterruptBit) {
-		p.interrupts.Request(interrupts.LcdInterrupt)
+func (p *PPU) setLyCoincidence(equal bool) {
+	if equal {
+		p.stat |= 1 << LycFlagBit
+	} else {
+		p.stat &^= 1 << LycFlagBit
 	}
 }
 
-func (p *PPU) renderScanline() {
-	if p.ly >= ScreenHeight {
+// Every enabled source is ORed into one STAT line and LcdInterrupt is only requested on its
+// rising edge, so a source going high while another one already holds the line is lost. The
+// line is frozen while the LCD is off
+func (p *PPU) updateStatLine() {
+	if !p.isLcdEnabled() {
 		return
 	}
 
-	p.clearScanline()
-
-	if utils.IsBitSet(p.lcdc, BgWindowEnable) {
-		p.renderBackground()
-	}
-
-	if utils.IsBitSet(p.lcdc, WindowEnable) && p.shouldRenderWindow() {
-		p.renderWindow()
-	}
+	mode := p.stat & ModeFlag
+	line := (utils.IsBitSet(p.stat, LycInterruptBit) && utils.IsBitSet(p.stat, LycFlagBit)) ||
+		(utils.IsBitSet(p.stat, HBlankInterruptBit) && mode == HBlankMode && !p.lcdOnLine) ||
+		(utils.IsBitSet(p.stat, VBlankInterruptBit) && mode == VBlankMode) ||
+		(utils.IsBitSet(p.stat, OamInterruptBit) && (mode == OamMode || p.isVBlankOamPulse()))
 
-	if utils.IsBitSet(p.lcdc, SpriteEnable) {
-		p.renderSprites()
-	}
-}
-
-func (p *PPU) clearScanline() {
-	for x := 0; x < ScreenWidth; x++ {
-		p.backBuffer[p.ly][x] = 0
+	if line && !p.statLine {
+		p.interrupts.Request(interrupts.LcdInterrupt)
 	}
+	p.statLine = line
 }
 
-func (p *PPU) shouldRenderWindow() bool {
-	return p.ly >= p.wy && p.wx <= 166
-}
-
-func (p *PPU) renderBackground() {
-	tileMapBase := p.getBackgroundTileMapBase()
-
-	scrolledY := int(p.ly) + int(p.scy)
-	tileRow := (scrolledY / TileSize) % TileMapSize
-	pixelRow := scrolledY % TileSize
-
-	for screenX := 0; screenX < ScreenWidth; screenX++ {
-		scrolledX := screenX + int(p.scx)
-		tileCol := (scrolledX / TileSize) % TileMapSize
-		pixelCol := scrolledX % TileSize
-
-		tileMapAddress := tileMapBase + uint16(tileRow*TileMapSize+tileCol)
-		tileIndex := p.readVram(tileMapAddress)
-
-		color := p.getTilePixel(tileIndex, pixelCol, pixelRow)
-		p.backBuffer[p.ly][screenX] = p.applyBackgroundPalette(color)
-	}
+// The mode 2 source also fires as line 144 starts, even though the PPU goes to mode 1
+func (p *PPU) isVBlankOamPulse() bool {
+	return p.ly == ScreenHeight && p.cycles < LyCompareDelay
 }
 
 func (p *PPU) getBackgroundTileMapBase() uint16 {
@@ -287,65 +306,6 @@ func (p *PPU) getWindowTileMapBase() uint16 {
 	return 0x9800
 }
 
-func (p *PPU) renderWindow() {
-	tileMapBase := p.getWindowTileMapBase()
-
-	tileRow := int(p.windowLineCounter) / TileSize
-	pixelRow := int(p.windowLineCounter) % TileSize
-
-	windowStartX := int(p.wx) - 7
-	if windowStartX < 0 {
-		windowStartX = 0
-	}
-
-	for screenX := windowStartX; screenX < ScreenWidth; screenX++ {
-		windowX := screenX - windowStartX
-		tileCol := windowX / TileSize
-		pixelCol := windowX % TileSize
-
-		tileMapAddress := tileMapBase + uint16(tileRow*TileMapSize+tileCol)
-		tileIndex := p.readVram(tileMapAddress)
-
-		color := p.getTilePixel(tileIndex, pixelCol, pixelRow)
-		p.backBuffer[p.ly][screenX] = p.applyBackgroundPalette(color)
-	}
-	p.windowLineCounter++
-}
-
-func (p *PPU) applyBackgroundPalette(color Color) Color {
-	shift := color * 2
-	return Color((p.bgp >> shift) & 3)
-}
-
-func (p *PPU) renderSprites() {
-	spriteHeight := p.getSpriteHeight()
-	visibleSprites := make([]SpriteWithIndex, 0, MaxSprites)
-
-	for spriteIndex := 0; spriteIndex < MaxSprites; spriteIndex++ {
-		if len(visibleSprites) >= SpritesPerLine {
-			break
-		}
-
-		sprite := p.getSprite(spriteIndex)
-		if p.isSpriteOnCurrentScanline(sprite, spriteHeight) {
-			visibleSprites = append(visibleSprites, SpriteWithIndex{sprite, spriteIndex})
-		}
-	}
-
-	sort.Slice(visibleSprites, func(i, j int) bool {
-		s1 := visibleSprites[i]
-		s2 := visibleSprites[j]
-		if s1.sprite.x != s2.sprite.x {
-			return s1.sprite.x > s2.sprite.x
-		}
-		return s1.index > s2.index
-	})
-
-	for _, sw := range visibleSprites {
-		p.renderSprite(sw.sprite, spriteHeight)
-	}
-}
-
 func (p *PPU) getSpriteHeight() int {
 	if utils.IsBitSet(p.lcdc, SpriteSize) {
 		return 16
@@ -372,65 +332,10 @@ func (p *PPU) isSpriteOnCurrentScanline(sprite Sprite, spriteHeight int) bool {
 	return int(p.ly) >= spriteY && int(p.ly) < spriteY+spriteHeight
 }
 
-func (p *PPU) renderSprite(sprite Sprite, spriteHeight int) {
-	spriteY := int(sprite.y) - 16
-	spriteLine := int(p.ly) - spriteY
-
-	if utils.IsBitSet(sprite.flags, SpriteYFlip) {
-		spriteLine = spriteHeight - 1 - spriteLine
-	}
-
-	tileIndex := sprite.tileIndex
-	if spriteHeight == 16 {
-		tileIndex &= 0xFE
-
-		if spriteLine >= 8 {
-			tileIndex |= 0x01
-			spriteLine -= 8
-		}
-	}
-
-	for pixelX := 0; pixelX < 8; pixelX++ {
-		screenX := int(sprite.x) - 8 + pixelX
-
-		if screenX < 0 || screenX >= ScreenWidth {
-			continue
-		}
-
-		spritePixelX := pixelX
-		if utils.IsBitSet(sprite.flags, SpriteXFlip) {
-			spritePixelX = 7 - pixelX
-		}
-
-		color := p.getSpriteTilePixel(tileIndex, spritePixelX, spriteLine)
-
-		if color.isTransparent() {
-			continue
-		}
-
-		if utils.IsBitSet(sprite.flags, SpritePriority) && p.backBuffer[p.ly][screenX] != 0 {
-			continue
-		}
-
-		paletteColor := p.applySpritePalette(sprite, color)
-		p.backBuffer[p.ly][screenX] = paletteColor
-	}
-}
-
 func (c Color) isTransparent() bool {
 	return c == 0
 }
 
-func (p *PPU) applySpritePalette(sprite Sprite, color Color) Color {
-	palette := p.obp0
-	if utils.IsBitSet(sprite.flags, SpritePalette) {
-		palette = p.obp1
-	}
-
-	shift := color * 2
-	return Color((palette >> shift) & 3)
-}
-
 func (p *PPU) getTilePixel(tileIndex uint8, pixelX int, pixelY int) Color {
 	tileDataAddress := p.getTileDataAddress(tileIndex)
 	return p.getPixelFromTileData(tileDataAddress, pixelX, pixelY)
@@ -498,7 +403,7 @@ func (p *PPU) Read(address uint16) uint8 {
 	case addresses.ScrollX:
 		return p.scx
 	case addresses.Ly:
-		return p.ly
+		return p.readLy()
 	case addresses.Lyc:
 		return p.lyc
 	case addresses.Dma:
@@ -514,10 +419,10 @@ func (p *PPU) Read(address uint16) uint8 {
 	case addresses.ObP1Palette:
 		return p.obp1
 	default:
-		if address >= addresses.Vram && address <= addresses.VramEnd {
+		if address >= addresses.Vram && address <= addresses.VramEnd && !p.isVramBlocked() {
 			return p.readVram(address)
 		}
-		if address >= addresses.Oam && address <= addresses.OamEnd {
+		if address >= addresses.Oam && address <= addresses.OamEnd && !p.isOamBlocked() {
 			return p.readOam(address)
 		}
 		return 0xFF
@@ -531,19 +436,25 @@ func (p *PPU) Write(address uint16, val uint8) {
 		p.lcdc = val
 
 		if prevEnabled && !p.isLcdEnabled() {
-			p.ly = 0
-			p.windowLineCounter = 0
-			p.enterMode(HBlankMode)
+			p.turnOff()
 		}
+		if !prevEnabled && p.isLcdEnabled() {
+			p.turnOn()
+		}
+		p.updateStatLine()
 	case addresses.LcdStatus:
 		p.stat = (p.stat & 0x87) | (val & 0x78) // Only bits 6-3 are writable
+		p.updateStatLine()
 	case addresses.ScrollY:
 		p.scy = val
 	case addresses.ScrollX:
 		p.scx = val
 	case addresses.Lyc:
 		p.lyc = val
-		p.updateLyc()
+		if p.isLcdEnabled() {
+			p.setLyCoincidence(p.readLy() == p.lyc)
+		}
+		p.updateStatLine()
 		// DMA Transfer handled in MMU
 	case addresses.WindowY:
 		p.wy = val
@@ -556,17 +467,140 @@ func (p *PPU) Write(address uint16, val uint8) {
 	case addresses.ObP1Palette:
 		p.obp1 = val
 	default:
-		if address >= addresses.Vram && address <= addresses.VramEnd {
+		if address >= addresses.Vram && address <= addresses.VramEnd && !p.isVramBlocked() {
 			p.writeVram(address, val)
 			return
 		}
-		if address >= addresses.Oam && address <= addresses.OamEnd {
+		if address >= addresses.Oam && address <= addresses.OamEnd && !p.isOamBlocked() {
 			p.writeOam(address, val)
 			return
 		}
 	}
 }
 
+// While the LCD is off LY reads 0, STAT reports mode 0 and keeps its LY=LYC flag, and the
+// screen is blank
+func (p *PPU) turnOff() {
+	p.ly = 0
+	p.cycles = 0
+	p.windowLineCounter = 0
+	p.wyTriggered = false
+	p.lcdOnLine = false
+	p.skipFrame = false
+	p.offDots = 0
+	p.enterMode(HBlankMode)
+	p.blankScreen()
+}
+
+// Line 0 after turning the LCD on is 4 dots short and reports mode 0 instead of OAM scan. The
+// screen stays blank until the second frame
+func (p *PPU) turnOn() {
+	p.ly = 0
+	p.cycles = LyCompareDelay
+	p.lcdOnLine = true
+	p.skipFrame = true
+	p.enterMode(OamMode)
+	p.stat &^= ModeFlag
+	p.setLyCoincidence(p.ly == p.lyc)
+}
+
+// A blank LCD shows the same shade as color 0
+func (p *PPU) blankScreen() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for y := range p.frontBuffer {
+		for x := range p.frontBuffer[y] {
+			p.frontBuffer[y][x] = 0
+		}
+	}
+}
+
+// Turning restrictions off lets the CPU see VRAM and OAM in every mode and disables the OAM
+// corruption bug, which is handy when debugging
+func (p *PPU) SetAccessRestrictions(enabled bool) {
+	p.restrictAccess = enabled
+}
+
+func (p *PPU) AccessRestricted() bool {
+	return p.restrictAccess
+}
+
+func (p *PPU) isVramBlocked() bool {
+	return p.restrictAccess && p.isLcdEnabled() && p.stat&ModeFlag == VramMode
+}
+
+func (p *PPU) isOamBlocked() bool {
+	mode := p.stat & ModeFlag
+	return p.restrictAccess && p.isLcdEnabled() && (mode == OamMode || mode == VramMode)
+}
+
+// OAM DMA writes bypass the mode checks
+func (p *PPU) DmaWrite(index uint8, val uint8) {
+	p.oam.Write(uint16(index), val)
+}
+
+// OAM DMA has its own path to VRAM, so reads bypass the CPU's mode checks too
+func (p *PPU) DmaRead(address uint16) uint8 {
+	return p.readVram(address)
+}
+
+// OAM scan finds no sprites while DMA owns the OAM bus
+func (p *PPU) SetOamDma(active bool) {
+	p.oamDma = active
+}
+
+// A 16-bit INC/DEC with a register pointing at FE00-FEFF puts that address on the bus. During
+// mode 2 this corrupts the OAM row the PPU is scanning
+func (p *PPU) TriggerOamBug(address uint16) {
+	if !p.restrictAccess || address < addresses.Oam || address > 0xFEFF {
+		return
+	}
+	if !p.isLcdEnabled() || p.stat&ModeFlag != OamMode {
+		return
+	}
+
+	// 20 rows of 8 bytes, one row every 4 dots. The access lands in the instruction's second M-cycle
+	row := int(p.cycles+4) / 4
+	if row < 1 || row >= OamSize/8 {
+		return
+	}
+	p.corruptOamRow(row)
+}
+
+// Write corruption pattern: the first word becomes ((a ^ c) & (b ^ c)) ^ c and the rest of the
+// row is copied from the row before it
+func (p *PPU) corruptOamRow(row int) {
+	base := uint16(row * 8)
+	prev := base - 8
+
+	a := p.oamWord(base)
+	b := p.oamWord(prev)
+	c := p.oamWord(prev + 4)
+	word := ((a ^ c) & (b ^ c)) ^ c
+	p.oam.Write(base, uint8(word))
+	p.oam.Write(base+1, uint8(word>>8))
+
+	for i := uint16(2); i < 8; i++ {
+		p.oam.Write(base+i, p.oam.Read(prev+i))
+	}
+}
+
+func (p *PPU) oamWord(offset uint16) uint16 {
+	return uint16(p.oam.Read(offset)) | uint16(p.oam.Read(offset+1))<<8
+}
+
+// Number of frames completed since power on, including blank ones while the LCD is off
+func (p *PPU) Frames() uint64 {
+	return p.frames.Load()
+}
+
+// Calls hook from the emulation goroutine once per frame with what the LCD shows
+func (p *PPU) SetFrameHook(hook func(frame *[ScreenHeight][ScreenWidth]Color)) {
+	p.frameHook = hook
+}
+
+// Shades 0-3 after BGP/OBP, with sprite pixels tagged Obj0Source or Obj1Source
 func (p *PPU) GetFrameBuffer() *[ScreenHeight][ScreenWidth]Color {
 	return p.frontBuffer
 }
@@ -586,6 +620,13 @@ func (p *PPU) Reset() {
 	p.mode = 0
 	p.cycles = 0
 	p.windowLineCounter = 0
+	p.wyTriggered = false
+	p.statLine = false
+	p.lcdOnLine = false
+	p.skipFrame = false
+	p.frames.Store(0)
+	p.offDots = 0
+	p.oamDma = false
 
 	p.vram = memory.NewRAM(0x2000)
 	p.oam = memory.NewRAM(0xA0)
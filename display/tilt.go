@@ -0,0 +1,61 @@
+package display
+
+import (
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+
+	"garboy/cartridge"
+)
+
+// Stick movement smaller than this is ignored
+const gamepadDeadZone = 0.15
+
+// Tilt for accelerometer cartridges, in g with positive x to the right and positive y towards
+// the player. IJKL tilt fully, dragging with the right mouse button tilts towards the cursor and
+// the right stick of a gamepad tilts as far as it is pushed
+type TiltInput struct {
+	cartridge.TiltSensor
+}
+
+// The first of the keyboard, mouse and gamepad that is in use wins
+func (t *TiltInput) update(width int, height int) {
+	x, y := keyAxis(ebiten.KeyJ, ebiten.KeyL), keyAxis(ebiten.KeyI, ebiten.KeyK)
+	if x != 0 || y != 0 {
+		t.Set(x, y)
+		return
+	}
+
+	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonRight) {
+		cx, cy := ebiten.CursorPosition()
+		x = float64(2*cx-width) / float64(width)
+		y = float64(2*cy-height) / float64(height)
+		t.Set(min(max(x, -1), 1), min(max(y, -1), 1))
+		return
+	}
+
+	for _, id := range ebiten.AppendGamepadIDs(nil) {
+		if !ebiten.IsStandardGamepadLayoutAvailable(id) {
+			continue
+		}
+		x = ebiten.StandardGamepadAxisValue(id, ebiten.StandardGamepadAxisRightStickHorizontal)
+		y = ebiten.StandardGamepadAxisValue(id, ebiten.StandardGamepadAxisRightStickVertical)
+		if math.Abs(x) > gamepadDeadZone || math.Abs(y) > gamepadDeadZone {
+			t.Set(x, y)
+			return
+		}
+	}
+
+	t.Set(0, 0)
+}
+
+func keyAxis(negative, positive ebiten.Key) float64 {
+	val := 0.0
+	if ebiten.IsKeyPressed(negative) {
+		val--
+	}
+	if ebiten.IsKeyPressed(positive) {
+		val++
+	}
+	return val
+}
@@ -0,0 +1,264 @@
+package display
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Pixel art upscalers. They work on framebuffer colors rather than RGB, so edges between shades
+// are found the same way whatever the palette is and the result can still be saved as a GIF
+type Upscaler int32
+
+const (
+	NoUpscaler Upscaler = iota
+	Scale2x
+	Scale3x
+	Scale4x
+	XbrLite
+)
+
+var upscalerNames = []string{"none", "scale2x", "scale3x", "scale4x", "xbr"}
+
+func (u Upscaler) String() string {
+	if u < 0 || int(u) >= len(upscalerNames) {
+		return fmt.Sprintf("Upscaler(%d)", int(u))
+	}
+	return upscalerNames[u]
+}
+
+// How many times bigger the upscaled frame is in each direction
+func (u Upscaler) Factor() int {
+	switch u {
+	case Scale2x, XbrLite:
+		return 2
+	case Scale3x:
+		return 3
+	case Scale4x:
+		return 4
+	default:
+		return 1
+	}
+}
+
+// Accepts the names from String
+func ParseUpscaler(name string) (Upscaler, error) {
+	for i, upscalerName := range upscalerNames {
+		if strings.EqualFold(name, upscalerName) {
+			return Upscaler(i), nil
+		}
+	}
+	return NoUpscaler, fmt.Errorf("unknown upscaler %q, expected one of %s", name, strings.Join(upscalerNames, ", "))
+}
+
+// Every upscaler, NoUpscaler first
+func Upscalers() []Upscaler {
+	upscalers := make([]Upscaler, len(upscalerNames))
+	for i := range upscalers {
+		upscalers[i] = Upscaler(i)
+	}
+	return upscalers
+}
+
+var currentUpscaler atomic.Int32
+
+// Used by the window, screenshots, GIFs and recordings started from now on
+func SetUpscaler(u Upscaler) {
+	currentUpscaler.Store(int32(u))
+}
+
+func CurrentUpscaler() Upscaler {
+	return Upscaler(currentUpscaler.Load())
+}
+
+// An upscaled frame, row by row
+type UpscaledFrame struct {
+	Width, Height int
+	Pix           []Color
+}
+
+func (f *UpscaledFrame) At(x, y int) Color {
+	return f.Pix[y*f.Width+x]
+}
+
+// Upscales frame into dst, reusing its buffer when it is big enough
+func (u Upscaler) Apply(frame *[ScreenHeight][ScreenWidth]Color, dst *UpscaledFrame) {
+	src := UpscaledFrame{Width: ScreenWidth, Height: ScreenHeight, Pix: make([]Color, 0, ScreenWidth*ScreenHeight)}
+	for y := range frame {
+		src.Pix = append(src.Pix, frame[y][:]...)
+	}
+
+	switch u {
+	case Scale2x:
+		scale2x(&src, dst)
+	case Scale3x:
+		scale3x(&src, dst)
+	case Scale4x:
+		var half UpscaledFrame
+		scale2x(&src, &half)
+		scale2x(&half, dst)
+	case XbrLite:
+		xbrLite(&src, dst)
+	default:
+		dst.resize(ScreenWidth, ScreenHeight)
+		copy(dst.Pix, src.Pix)
+	}
+}
+
+func (f *UpscaledFrame) resize(width, height int) {
+	f.Width, f.Height = width, height
+	if cap(f.Pix) < width*height {
+		f.Pix = make([]Color, width*height)
+	}
+	f.Pix = f.Pix[:width*height]
+}
+
+// Pixels past the edges repeat the edge
+func (f *UpscaledFrame) clamped(x, y int) Color {
+	x = min(max(x, 0), f.Width-1)
+	y = min(max(y, 0), f.Height-1)
+	return f.Pix[y*f.Width+x]
+}
+
+// The 3x3 neighbourhood of a pixel:
+//
+//	A B C
+//	D E F
+//	G H I
+type neighbours struct {
+	a, b, c, d, e, f, g, h, i Color
+}
+
+func (f *UpscaledFrame) neighbours(x, y int) neighbours {
+	return neighbours{
+		f.clamped(x-1, y-1), f.clamped(x, y-1), f.clamped(x+1, y-1),
+		f.clamped(x-1, y), f.clamped(x, y), f.clamped(x+1, y),
+		f.clamped(x-1, y+1), f.clamped(x, y+1), f.clamped(x+1, y+1),
+	}
+}
+
+// AdvMAME2x: a corner takes the color of its two neighbours when they match
+func scale2x(src, dst *UpscaledFrame) {
+	dst.resize(src.Width*2, src.Height*2)
+	for y := 0; y < src.Height; y++ {
+		for x := 0; x < src.Width; x++ {
+			n := src.neighbours(x, y)
+			e0, e1, e2, e3 := n.e, n.e, n.e, n.e
+			if n.b != n.h && n.d != n.f {
+				if n.d == n.b {
+					e0 = n.d
+				}
+				if n.b == n.f {
+					e1 = n.f
+				}
+				if n.d == n.h {
+					e2 = n.d
+				}
+				if n.h == n.f {
+					e3 = n.f
+				}
+			}
+
+			i := 2*y*dst.Width + 2*x
+			dst.Pix[i], dst.Pix[i+1] = e0, e1
+			dst.Pix[i+dst.Width], dst.Pix[i+dst.Width+1] = e2, e3
+		}
+	}
+}
+
+// AdvMAME3x: like Scale2x, and the edge centers follow diagonals that don't end at a corner
+func scale3x(src, dst *UpscaledFrame) {
+	dst.resize(src.Width*3, src.Height*3)
+	for y := 0; y < src.Height; y++ {
+		for x := 0; x < src.Width; x++ {
+			n := src.neighbours(x, y)
+			out := [9]Color{n.e, n.e, n.e, n.e, n.e, n.e, n.e, n.e, n.e}
+			if n.b != n.h && n.d != n.f {
+				if n.d == n.b {
+					out[0] = n.d
+				}
+				if (n.d == n.b && n.e != n.c) || (n.b == n.f && n.e != n.a) {
+					out[1] = n.b
+				}
+				if n.b == n.f {
+					out[2] = n.f
+				}
+				if (n.d == n.b && n.e != n.g) || (n.d == n.h && n.e != n.a) {
+					out[3] = n.d
+				}
+				if (n.b == n.f && n.e != n.i) || (n.h == n.f && n.e != n.c) {
+					out[5] = n.f
+				}
+				if n.d == n.h {
+					out[6] = n.d
+				}
+				if (n.d == n.h && n.e != n.i) || (n.h == n.f && n.e != n.g) {
+					out[7] = n.h
+				}
+				if n.h == n.f {
+					out[8] = n.f
+				}
+			}
+
+			i := 3*y*dst.Width + 3*x
+			for row := 0; row < 3; row++ {
+				copy(dst.Pix[i+row*dst.Width:], out[row*3:row*3+3])
+			}
+		}
+	}
+}
+
+// A 2x take on xBR level 1. Each corner weighs the color differences along both diagonals in a
+// 5x5 area and, if an edge cuts the corner off, takes the color of the closer of its two
+// neighbours. Differences come from the current palette but no new colors are mixed in
+func xbrLite(src, dst *UpscaledFrame) {
+	dst.resize(src.Width*2, src.Height*2)
+
+	palette := currentPalette.Load()
+	var dist [Obj1Source + ShadeMask + 1][Obj1Source + ShadeMask + 1]int
+	for a := range dist {
+		for b := range dist[a] {
+			ca, cb := palette.color(Color(a)), palette.color(Color(b))
+			dist[a][b] = absDiff(ca.R, cb.R) + absDiff(ca.G, cb.G) + absDiff(ca.B, cb.B)
+		}
+	}
+	d := func(a, b Color) int {
+		return dist[a][b]
+	}
+
+	for y := 0; y < src.Height; y++ {
+		for x := 0; x < src.Width; x++ {
+			i := 2*y*dst.Width + 2*x
+			corners := [4]int{i, i + 1, i + dst.Width, i + dst.Width + 1}
+			for corner, dir := range [4][2]int{{-1, -1}, {1, -1}, {-1, 1}, {1, 1}} {
+				dx, dy := dir[0], dir[1]
+				// Rotated so the corner is always the bottom right one
+				at := func(u, v int) Color {
+					return src.clamped(x+u*dx, y+v*dy)
+				}
+				e, b, c, dd, f := at(0, 0), at(0, -1), at(1, -1), at(-1, 0), at(1, 0)
+				g, h, ii := at(-1, 1), at(0, 1), at(1, 1)
+				f4, h5, i4, i5 := at(2, 0), at(0, 2), at(2, 1), at(1, 2)
+
+				// Each sum is small when colors stay the same along that diagonal
+				out := e
+				alongFH := d(e, c) + d(e, g) + d(ii, f4) + d(ii, h5) + 4*d(h, f)
+				alongEI := d(h, dd) + d(h, i5) + d(f, i4) + d(f, b) + 4*d(e, ii)
+				if alongFH < alongEI {
+					out = h
+					if d(e, f) <= d(e, h) {
+						out = f
+					}
+				}
+				dst.Pix[corners[corner]] = out
+			}
+		}
+	}
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
@@ -0,0 +1,246 @@
+package display
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+
+	"garboy/memory"
+	"garboy/utils"
+)
+
+const (
+	TileCount        = 384
+	TileSheetColumns = 16
+	TileMapPixels    = TileMapSize * TileSize
+	OamSheetColumns  = 8
+
+	TileMap0 = 0x9800
+	TileMap1 = 0x9C00
+)
+
+// Drawn over the BG map to show what SCX/SCY put on screen
+var viewportColor = color.RGBA{220, 40, 40, 255}
+
+// Background for transparent sprite pixels in the OAM sheet
+var transparentColor = color.RGBA{255, 0, 255, 255}
+
+// One decoded OAM entry
+type OamEntry struct {
+	Index      int
+	Y, X       uint8
+	Tile       uint8
+	Flags      uint8
+	BgPriority bool // BG colors 1-3 are drawn over the sprite
+	YFlip      bool
+	XFlip      bool
+	Obp1       bool
+}
+
+func (e OamEntry) String() string {
+	flag := func(set bool, c byte) byte {
+		if set {
+			return c
+		}
+		return '-'
+	}
+	palette := 0
+	if e.Obp1 {
+		palette = 1
+	}
+	return fmt.Sprintf("%02d Y:%02X X:%02X T:%02X %c%c%c%d", e.Index, e.Y, e.X, e.Tile,
+		flag(e.BgPriority, 'P'), flag(e.YFlip, 'Y'), flag(e.XFlip, 'X'), palette)
+}
+
+// The emulator goroutine keeps writing VRAM, OAM and the registers, so viewers on another
+// goroutine draw from a copy taken at the end of each frame
+func (p *PPU) takeViewerSnapshot() {
+	if !p.viewersOpen.Load() {
+		return
+	}
+	snapshot := &PPU{
+		vram: copyMemory(p.vram, 0x2000),
+		oam:  copyMemory(p.oam, 0xA0),
+		lcdc: p.lcdc,
+		scy:  p.scy,
+		scx:  p.scx,
+		bgp:  p.bgp,
+		obp0: p.obp0,
+		obp1: p.obp1,
+	}
+	p.mu.Lock()
+	p.viewerState = snapshot
+	p.mu.Unlock()
+}
+
+func copyMemory(m memory.Memory, size int) *memory.RAM {
+	ram := memory.NewRAM(size)
+	for i := 0; i < size; i++ {
+		ram.Write(uint16(i), m.Read(uint16(i)))
+	}
+	return ram
+}
+
+// VRAM, OAM, LCDC, SCX/SCY and the palettes as of the last frame, for drawing viewers from the
+// display goroutine. Copies start being taken on the first call, so that one is blank
+func (p *PPU) ViewerSnapshot() *PPU {
+	p.viewersOpen.Store(true)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.viewerState == nil {
+		return &PPU{vram: memory.NewRAM(0x2000), oam: memory.NewRAM(0xA0)}
+	}
+	return p.viewerState
+}
+
+func (p *PPU) closeViewers() {
+	p.viewersOpen.Store(false)
+}
+
+// All 384 tiles in 0x8000-0x97FF, 16 per row, colored with palette (e.g. BGP)
+func (p *PPU) TileSheet(palette uint8) *image.RGBA {
+	rows := TileCount / TileSheetColumns
+	img := image.NewRGBA(image.Rect(0, 0, TileSheetColumns*TileSize, rows*TileSize))
+
+	for tile := 0; tile < TileCount; tile++ {
+		address := uint16(0x8000) + uint16(tile)*16
+		x0 := (tile % TileSheetColumns) * TileSize
+		y0 := (tile / TileSheetColumns) * TileSize
+		for y := 0; y < TileSize; y++ {
+			for x := 0; x < TileSize; x++ {
+				color := p.getPixelFromTileData(address, x, y)
+				img.SetRGBA(x0+x, y0+y, gameBoyColorToRgba(applyPalette(palette, color)))
+			}
+		}
+	}
+	return img
+}
+
+// The full 256x256 BG map at base (TileMap0 or TileMap1) using the current LCDC tile data area
+// and BGP, with the SCX/SCY viewport outlined
+func (p *PPU) TileMap(base uint16) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, TileMapPixels, TileMapPixels))
+
+	for row := 0; row < TileMapSize; row++ {
+		for col := 0; col < TileMapSize; col++ {
+			tileIndex := p.readVram(base + uint16(row*TileMapSize+col))
+			for y := 0; y < TileSize; y++ {
+				for x := 0; x < TileSize; x++ {
+					color := applyPalette(p.bgp, p.getTilePixel(tileIndex, x, y))
+					img.SetRGBA(col*TileSize+x, row*TileSize+y, gameBoyColorToRgba(color))
+				}
+			}
+		}
+	}
+
+	p.drawViewport(img)
+	return img
+}
+
+// The viewport wraps around the map edges like the BG does
+func (p *PPU) drawViewport(img *image.RGBA) {
+	set := func(x, y int) {
+		img.SetRGBA(x%TileMapPixels, y%TileMapPixels, viewportColor)
+	}
+
+	left, top := int(p.scx), int(p.scy)
+	right, bottom := left+ScreenWidth-1, top+ScreenHeight-1
+	for x := left; x <= right; x++ {
+		set(x, top)
+		set(x, bottom)
+	}
+	for y := top; y <= bottom; y++ {
+		set(left, y)
+		set(right, y)
+	}
+}
+
+// All 40 OAM entries in order
+func (p *PPU) OamEntries() []OamEntry {
+	entries := make([]OamEntry, MaxSprites)
+	for i := range entries {
+		sprite := p.getSprite(i)
+		entries[i] = OamEntry{
+			Index:      i,
+			Y:          sprite.y,
+			X:          sprite.x,
+			Tile:       sprite.tileIndex,
+			Flags:      sprite.flags,
+			BgPriority: utils.IsBitSet(sprite.flags, SpritePriority),
+			YFlip:      utils.IsBitSet(sprite.flags, SpriteYFlip),
+			XFlip:      utils.IsBitSet(sprite.flags, SpriteXFlip),
+			Obp1:       utils.IsBitSet(sprite.flags, SpritePalette),
+		}
+	}
+	return entries
+}
+
+// Prints one line per OAM entry
+func (p *PPU) WriteOamTable(w io.Writer) {
+	for _, entry := range p.OamEntries() {
+		fmt.Fprintln(w, entry)
+	}
+}
+
+// Every sprite drawn with its flips and OBP palette, 8 per row in OAM order. Cells are 8x16 so
+// tall sprites fit, and transparent pixels are magenta
+func (p *PPU) OamSheet() *image.RGBA {
+	const cellHeight = 2 * TileSize
+	rows := MaxSprites / OamSheetColumns
+	img := image.NewRGBA(image.Rect(0, 0, OamSheetColumns*TileSize, rows*cellHeight))
+
+	spriteHeight := p.getSpriteHeight()
+	for _, entry := range p.OamEntries() {
+		x0 := (entry.Index % OamSheetColumns) * TileSize
+		y0 := (entry.Index / OamSheetColumns) * cellHeight
+
+		tileIndex := entry.Tile
+		if spriteHeight == 16 {
+			tileIndex &= 0xFE
+		}
+		palette, source := p.obp0, Color(Obj0Source)
+		if entry.Obp1 {
+			palette, source = p.obp1, Obj1Source
+		}
+
+		for y := 0; y < cellHeight; y++ {
+			for x := 0; x < TileSize; x++ {
+				if y >= spriteHeight {
+					img.SetRGBA(x0+x, y0+y, transparentColor)
+					continue
+				}
+
+				spriteX, spriteY := x, y
+				if entry.XFlip {
+					spriteX = TileSize - 1 - x
+				}
+				if entry.YFlip {
+					spriteY = spriteHeight - 1 - y
+				}
+
+				color := p.getSpriteTilePixel(tileIndex+uint8(spriteY/TileSize), spriteX, spriteY%TileSize)
+				if color.isTransparent() {
+					img.SetRGBA(x0+x, y0+y, transparentColor)
+					continue
+				}
+				img.SetRGBA(x0+x, y0+y, gameBoyColorToRgba(applyPalette(palette, color)|source))
+			}
+		}
+	}
+	return img
+}
+
+func SavePNG(img image.Image, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
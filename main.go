@@ -1,15 +1,26 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
 	"time"
 
 	"garboy/cartridge"
 	"garboy/cpu"
+	"garboy/debugger"
 	"garboy/display"
 	"garboy/interrupts"
 	"garboy/mmu"
+	"garboy/record"
+	"garboy/romdb"
 	"garboy/scheduler"
+	"garboy/symbols"
 	"garboy/timer"
+	"garboy/trace"
 )
 
 var (
@@ -19,23 +30,172 @@ var (
 	TimePerFrame    = time.Second / time.Duration(Fps)
 )
 
+// Quirks go by the title a DAT gives the ROM
+var errQuirksWithoutDat = errors.New("-quirks needs -dat to find the ROM's title")
+
 func main() {
-	cartridge := cartridge.NewCartridge("./roms/pokemon-red.gb")
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// Deferred flushes and the save run before main reports an error
+func run() error {
+	romPath := flag.String("rom", "./roms/pokemon-red.gb", "path to the ROM to run, which can be zipped or gzipped")
+	romEntry := flag.String("rom-entry", "", "file to run from a zip holding several ROMs (default: the first .gb or .gbc)")
+	patchPath := flag.String("patch", "", "IPS, UPS or BPS patch to apply in memory (default: one named after the ROM next to it)")
+	datPath := flag.String("dat", "", "No-Intro DAT file (XML or ClrMamePro) to look the ROM up in by hash")
+	quirksPath := flag.String("quirks", "", "file of fixes by title for ROMs found with -dat, lines like \"Title: ram=32K multicart\"")
+	cameraSpec := flag.String("camera", "pattern", "Pocket Camera pictures: pattern, an image file, or a directory of PNGs to cycle through")
+	debug := flag.Bool("debug", false, "start the interactive debugger in the terminal")
+	gdbAddr := flag.String("gdb", "", "wait for a GDB remote protocol client on this address, e.g. localhost:2345")
+	headless := flag.Bool("headless", false, "don't open a window")
+	symPath := flag.String("sym", "", "RGBDS .sym or .map file (default: next to the ROM)")
+	skipBoot := flag.Bool("skip-boot", false, "start from the post boot ROM state at 0100")
+	relaxAccess := flag.Bool("relax-access", false, "let the CPU access VRAM/OAM in every PPU mode and disable the OAM bug")
+	tracePath := flag.String("trace", "", "log every instruction in Gameboy Doctor format to this file")
+	traceGzip := flag.Bool("trace-gzip", false, "gzip the trace (implied by a .gz extension)")
+	traceStart := flag.String("trace-start", "", "start tracing at pc:<hex> or frame:<n>")
+	traceStop := flag.String("trace-stop", "", "stop tracing at pc:<hex> or frame:<n>")
+	captureScale := flag.Int("capture-scale", 1, "scale of F12 screenshots and F11 GIF recordings")
+	gifSkip := flag.Int("gif-skip", 2, "keep every nth frame in GIF recordings")
+	paletteName := flag.String("palette", "default", "palette preset (default, dmg, pocket, light, contrast) or palette file")
+	scale := flag.Int("scale", display.Scale, "window size as a multiple of 160x144")
+	blend := flag.Float64("blend", 0, "blend in this much of the previous frame (0-1) to mimic LCD ghosting")
+	overlay := flag.String("filter", "none", "pixel overlay: none, grid or scanlines")
+	smooth := flag.Bool("smooth", false, "bilinear instead of nearest neighbour scaling")
+	upscale := flag.String("upscale", "none", "pixel art upscaler for the window, screenshots and recordings: none, scale2x, scale3x, scale4x or xbr")
+	recordPath := flag.String("record", "", "record every frame to this file, as Y4M for .y4m and raw RGB24 otherwise")
+	recordAudioPath := flag.String("record-audio", "", "record the sound output to this WAV file, in step with -record (needs sound emulation, which doesn't exist yet)")
+	flag.Parse()
+
+	var database *romdb.Database
+	if *datPath != "" {
+		var err error
+		if database, err = romdb.Load(*datPath); err != nil {
+			return err
+		}
+	}
+	if *quirksPath != "" {
+		if database == nil {
+			return errQuirksWithoutDat
+		}
+		if err := database.LoadQuirks(*quirksPath); err != nil {
+			return fmt.Errorf("%s: %w", *quirksPath, err)
+		}
+	}
+	cartridge, err := cartridge.NewCartridgeWith(*romPath, cartridge.LoadOptions{Entry: *romEntry, Patch: *patchPath, Database: database})
+	if err != nil {
+		return err
+	}
+	if database != nil {
+		printMatch(cartridge.Match())
+	}
+	if cartridge.PatchPath() != "" {
+		fmt.Println("patched with", cartridge.PatchPath())
+	}
+	defer func() {
+		if err := cartridge.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, "save:", err)
+		}
+	}()
+	setCameraSource(cartridge, *cameraSpec)
+	for _, warning := range cartridge.Header().Warnings() {
+		fmt.Fprintln(os.Stderr, "warning:", warning)
+	}
+	table, err := loadSymbols(*symPath, *romPath)
+	if err != nil {
+		return err
+	}
+	palette, err := loadPalette(*paletteName)
+	if err != nil {
+		return err
+	}
+	filter, err := filterOptions(*blend, *overlay, *smooth)
+	if err != nil {
+		return err
+	}
+	upscaler, err := display.ParseUpscaler(*upscale)
+	if err != nil {
+		return err
+	}
 
 	interrupts := interrupts.NewInterrupts()
 	ppu := display.NewPPU(interrupts)
 	joypad := display.NewJoypad()
 	lcd := display.NewDisplay(ppu, joypad)
+	cartridge.SetAccelerometer(lcd.Tilt())
+	lcd.SetCaptureOptions(*captureScale, *gifSkip)
+	lcd.UsePalette(palette)
+	lcd.SetScale(*scale)
+	lcd.SetFilter(filter)
+	display.SetUpscaler(upscaler)
 	timer := timer.NewTimer(interrupts)
 	mmu := mmu.NewMMU(cartridge, ppu, timer, joypad, interrupts)
+	mmu.SetAccessRestrictions(!*relaxAccess)
 	cpu := cpu.NewCPU(mmu, interrupts)
+	if table != nil {
+		cpu.SetLabeler(func(address uint16) string { return table.Label(address, mmu.RomBank()) })
+	}
+
+	scheduler := scheduler.NewScheduler(cpu, mmu, ppu, timer)
+
+	if *skipBoot {
+		cpu.SkipBootROM()
+	}
+
+	var logger *trace.Logger
+	if *tracePath != "" {
+		if logger, err = startTrace(*tracePath, *traceGzip, *traceStart, *traceStop, cpu, ppu); err != nil {
+			return err
+		}
+		defer logger.Close()
+	}
+
+	var recorder *record.VideoRecorder
+	if *recordPath != "" {
+		if recorder, err = record.NewVideoRecorder(*recordPath, ppu); err != nil {
+			return err
+		}
+		defer recorder.Close()
+	}
+
+	var audioRecorder *record.AudioRecorder
+	if *recordAudioPath != "" {
+		// There's no sound unit to record from yet, so this reports record.ErrNoSound
+		if audioRecorder, err = record.NewAudioRecorder(*recordAudioPath, scheduler, nil); err != nil {
+			return err
+		}
+		defer audioRecorder.Close()
+	}
 
-	scheduler := scheduler.NewScheduler(cpu, ppu, timer)
+	if !*headless {
+		go display.RunDisplay(lcd)
+	}
+
+	if *debug || *gdbAddr != "" {
+		dbg := debugger.NewDebugger(cpu, mmu, scheduler)
+		dbg.SetSymbols(table)
+		dbg.SetPPU(ppu)
+
+		// Keep real time pacing when the window is open so the game stays watchable
+		if !*headless {
+			dbg.SetFrameLimit(CyclesPerFrame, TimePerFrame)
+		}
 
-	// Uncomment this if you aren't patient ;)
-	// cpu.SkipBootROM()
+		if *gdbAddr != "" {
+			return runGDBServer(dbg, *gdbAddr)
+		}
+		return runDebugger(dbg)
+	}
 
-	go display.RunDisplay(lcd)
+	// Ctrl-C exits between frames so the deferred trace and recording flushes and the save run
+	var interrupt chan os.Signal
+	if logger != nil || recorder != nil || audioRecorder != nil || cartridge.HasBattery() {
+		interrupt = make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+	}
 
 	for {
 		frameStartTime := time.Now()
@@ -47,5 +207,107 @@ func main() {
 		if elapsedTime < TimePerFrame {
 			time.Sleep(TimePerFrame - elapsedTime)
 		}
+
+		select {
+		case <-interrupt:
+			return nil
+		default:
+		}
+	}
+}
+
+func startTrace(path string, compress bool, start, stop string, cpu *cpu.CPU, ppu *display.PPU) (*trace.Logger, error) {
+	startTrigger, err := trace.ParseTrigger(start)
+	if err != nil {
+		return nil, err
+	}
+	stopTrigger, err := trace.ParseTrigger(stop)
+	if err != nil {
+		return nil, err
+	}
+	return trace.NewLogger(path, compress, cpu, ppu, startTrigger, stopTrigger)
+}
+
+func filterOptions(blend float64, overlay string, smooth bool) (display.FilterOptions, error) {
+	opts := display.FilterOptions{Blend: blend, Smooth: smooth}
+	switch overlay {
+	case "none":
+	case "grid":
+		opts.Grid = true
+	case "scanlines":
+		opts.Scanlines = true
+	default:
+		return opts, fmt.Errorf("unknown filter %q, expected none, grid or scanlines", overlay)
+	}
+	return opts, nil
+}
+
+// A preset name, or else a palette file
+func loadPalette(name string) (display.Palette, error) {
+	if palette, ok := display.PresetPalette(name); ok {
+		return palette, nil
+	}
+	return display.LoadPalette(name)
+}
+
+// Only cartridges with a camera need the pictures. A bad -camera falls back to the pattern
+func setCameraSource(cart *cartridge.Cartridge, spec string) {
+	if !cart.HasCamera() {
+		return
+	}
+	source, err := cartridge.NewCameraSource(spec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "camera:", err)
+		source = cartridge.NewPatternSource()
+	}
+	cart.SetCameraSource(source)
+}
+
+func loadSymbols(symPath, romPath string) (*symbols.Table, error) {
+	if symPath != "" {
+		return symbols.Load(symPath)
+	}
+	return symbols.LoadForROM(romPath)
+}
+
+func runGDBServer(dbg *debugger.Debugger, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Waiting for GDB on %s\n", listener.Addr())
+	return dbg.ServeGDB(listener)
+}
+
+func runDebugger(dbg *debugger.Debugger) error {
+	// Ctrl-C pauses execution instead of exiting
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		for range interrupt {
+			dbg.Interrupt()
+		}
+	}()
+
+	return dbg.RunREPL(os.Stdin, os.Stdout)
+}
+
+func printMatch(match *romdb.Match) {
+	if match == nil {
+		fmt.Fprintln(os.Stderr, "warning: ROM not in the database")
+		return
+	}
+	fmt.Println("database:", match.Name)
+	if match.Region != "" {
+		fmt.Println("region:", match.Region)
+	}
+	if match.Revision != "" {
+		fmt.Println("revision:", match.Revision)
+	}
+	if match.BadDump {
+		fmt.Fprintln(os.Stderr, "warning: the database lists this ROM as a bad dump")
+	}
+	if match.Overdump {
+		fmt.Fprintf(os.Stderr, "warning: overdump, the ROM should be %d bytes\n", match.Size)
 	}
 }
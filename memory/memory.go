@@ -37,9 +37,8 @@ func (r *ROM) Read(offset uint16) byte {
 	return r.data[offset]
 }
 
-func (r *ROM) Write(offset uint16, val byte) {
-	panic("Should not be writing to ROM")
-}
+// Writes are ignored, like on the bus
+func (r *ROM) Write(offset uint16, val byte) {}
 
 type IORegisters struct {
 	data [0x80]byte // FF00-FF7F
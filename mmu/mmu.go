@@ -36,6 +36,13 @@ type MmuInterface interface {
 	ReadWord(address uint16) uint16
 	WriteWord(address uint16, val uint16)
 	SetBootRomEnabled(val bool)
+	IncDec16(address uint16)
+}
+
+// Observes every CPU visible bus access. Used by the debugger for watchpoints
+type AccessHook interface {
+	OnRead(address uint16, val uint8)
+	OnWrite(address uint16, val uint8)
 }
 
 type MMU struct {
@@ -51,8 +58,28 @@ type MMU struct {
 
 	bootROM        memory.Memory
 	bootROMEnabled bool
+
+	dma dmaTransfer
+
+	hook AccessHook
 }
 
+// OAM DMA copies one byte per M-cycle after a one M-cycle startup delay
+type dmaTransfer struct {
+	active bool
+	source uint16
+	index  uint8
+	cycles uint16 // T-cycles left over from the last Step
+	delay  uint16
+}
+
+const (
+	dmaLength      = 0xA0
+	dmaStartDelay  = 4
+	dmaByteCycles  = 4
+	dmaEchoRamBase = 0xE000
+)
+
 func NewMMU(cart *cartridge.Cartridge, ppu *display.PPU, timer *timer.Timer, joypad *display.Joypad, interrupts *interrupts.Interrupts) *MMU {
 	return &MMU{
 		cartridge:      cart,
@@ -69,6 +96,14 @@ func NewMMU(cart *cartridge.Cartridge, ppu *display.PPU, timer *timer.Timer, joy
 }
 
 func (m *MMU) Read(address uint16) byte {
+	val := m.read(address)
+	if m.hook != nil {
+		m.hook.OnRead(address, val)
+	}
+	return val
+}
+
+func (m *MMU) read(address uint16) byte {
 	switch {
 	case address <= 0xFF && m.bootROMEnabled:
 		return m.bootROM.Read(address)
@@ -81,6 +116,9 @@ func (m *MMU) Read(address uint16) byte {
 	case address >= addresses.Wram && address < addresses.Oam:
 		return m.wram.Read(address & 0x1FFF)
 	case address < addresses.NotUsable:
+		if m.isDmaBlockingOam() {
+			return 0xFF
+		}
 		return m.ppu.Read(address)
 	case address < addresses.IoRegisters:
 		return 0xFF // Not usable
@@ -96,14 +134,19 @@ func (m *MMU) Read(address uint16) byte {
 		return m.io.Read(address - addresses.IoRegisters)
 	case address < addresses.InterruptEnable:
 		return m.hram.Read(address - addresses.Hram)
-	case address == addresses.InterruptEnable:
+	default: // InterruptEnable
 		return m.interrupts.IE()
-	default:
-		panic("Should not be reading past 0xFFFF")
 	}
 }
 
 func (m *MMU) Write(address uint16, val byte) {
+	if m.hook != nil {
+		m.hook.OnWrite(address, val)
+	}
+	m.write(address, val)
+}
+
+func (m *MMU) write(address uint16, val byte) {
 	switch {
 	case address < addresses.Vram:
 		m.cartridge.Write(address, val)
@@ -114,6 +157,9 @@ func (m *MMU) Write(address uint16, val byte) {
 	case address >= addresses.Wram && address < addresses.Oam:
 		m.wram.Write(address&0x1FFF, val)
 	case address < addresses.NotUsable:
+		if m.isDmaBlockingOam() {
+			return
+		}
 		m.ppu.Write(address, val)
 	case address < addresses.IoRegisters:
 		return // Not usable
@@ -136,10 +182,8 @@ func (m *MMU) Write(address uint16, val byte) {
 		m.io.Write(address-addresses.IoRegisters, val)
 	case address < addresses.InterruptEnable:
 		m.hram.Write(address-addresses.Hram, val)
-	case address == addresses.InterruptEnable:
+	default: // InterruptEnable
 		m.interrupts.Write(address, val)
-	default:
-		panic("Should not be reading past 0xFFFF")
 	}
 }
 
@@ -158,17 +202,76 @@ func (m *MMU) WriteWord(address uint16, val uint16) {
 	m.Write(address+1, hi)
 }
 
+func (m *MMU) RomBank() int {
+	return m.cartridge.RomBank()
+}
+
+func (m *MMU) SetAccessHook(hook AccessHook) {
+	m.hook = hook
+}
+
 func (m *MMU) SetBootRomEnabled(val bool) {
 	m.bootROMEnabled = val
 }
 
+// Starts an OAM DMA transfer from val * 0x100. Writing again restarts it
 func (m *MMU) DmaTransfer(val uint8) {
-	src := uint16(val) * 0x100
-	dst := uint16(0xFE00)
+	source := uint16(val) << 8
+	if source >= dmaEchoRamBase {
+		source -= 0x2000 // E000-FFFF reads from WRAM
+	}
+
+	m.dma = dmaTransfer{
+		active: true,
+		source: source,
+		delay:  dmaStartDelay,
+	}
+}
+
+// Advances OAM DMA
+func (m *MMU) Step(cycles uint16) {
+	if !m.dma.active {
+		return
+	}
+
+	m.dma.cycles += cycles
+	if m.dma.delay > 0 {
+		used := min(m.dma.delay, m.dma.cycles)
+		m.dma.delay -= used
+		m.dma.cycles -= used
+	}
+
+	for m.dma.cycles >= dmaByteCycles && m.dma.active {
+		m.dma.cycles -= dmaByteCycles
+		m.ppu.DmaWrite(m.dma.index, m.dmaRead(m.dma.source+uint16(m.dma.index)))
 
-	segmentSize := uint16(0xA0)
-	for i := uint16(0); i < segmentSize; i++ {
-		transfer := m.Read(src + i)
-		m.Write(dst+i, transfer)
+		m.dma.index++
+		if m.dma.index == dmaLength {
+			m.dma.active = false
+		}
 	}
+	m.ppu.SetOamDma(m.dma.active && m.dma.delay == 0)
+}
+
+// The source as DMA sees it, without the PPU mode blocking that only applies to the CPU
+func (m *MMU) dmaRead(address uint16) byte {
+	if address >= addresses.Vram && address < addresses.ExternalRam {
+		return m.ppu.DmaRead(address)
+	}
+	return m.read(address)
+}
+
+// The CPU can't reach OAM while DMA is copying into it
+func (m *MMU) isDmaBlockingOam() bool {
+	return m.dma.active && m.dma.delay == 0 && m.ppu.AccessRestricted()
+}
+
+// Relaxes VRAM/OAM blocking by PPU mode and DMA for debugging
+func (m *MMU) SetAccessRestrictions(enabled bool) {
+	m.ppu.SetAccessRestrictions(enabled)
+}
+
+// Called by 16-bit INC/DEC, which puts the register on the address bus
+func (m *MMU) IncDec16(address uint16) {
+	m.ppu.TriggerOamBug(address)
 }
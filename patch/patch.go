@@ -0,0 +1,328 @@
+// Package patch applies IPS, UPS and BPS ROM patches in memory
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+type Format int
+
+const (
+	IPS Format = iota
+	UPS
+	BPS
+)
+
+func (f Format) String() string {
+	return [...]string{"IPS", "UPS", "BPS"}[f]
+}
+
+var (
+	ipsMagic = []byte("PATCH")
+	ipsEOF   = []byte("EOF")
+	upsMagic = []byte("UPS1")
+	bpsMagic = []byte("BPS1")
+
+	ErrUnknownFormat = errors.New("not an IPS, UPS or BPS patch")
+	ErrTruncated     = errors.New("patch ends early")
+)
+
+const (
+	// Source, target and patch CRC32s end UPS and BPS files
+	footerSize = 12
+	// Patched ROMs are no bigger than the largest cartridges
+	maxTargetSize = 16 * 1024 * 1024
+)
+
+// A UPS or BPS patch was made for a different ROM, or the patch itself is damaged
+type ChecksumError struct {
+	What      string // "source", "target" or "patch"
+	Want, Got uint32
+}
+
+func (e ChecksumError) Error() string {
+	return fmt.Sprintf("%s CRC32 is %08X, the patch expects %08X", e.What, e.Got, e.Want)
+}
+
+// Picks the format from the magic bytes
+func Detect(patch []byte) (Format, error) {
+	switch {
+	case bytes.HasPrefix(patch, ipsMagic):
+		return IPS, nil
+	case bytes.HasPrefix(patch, upsMagic):
+		return UPS, nil
+	case bytes.HasPrefix(patch, bpsMagic):
+		return BPS, nil
+	default:
+		return 0, ErrUnknownFormat
+	}
+}
+
+// Returns the patched ROM. rom is never modified
+func Apply(rom []byte, patch []byte) ([]byte, error) {
+	format, err := Detect(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case IPS:
+		return applyIPS(rom, patch)
+	case UPS:
+		return applyUPS(rom, patch)
+	default:
+		return applyBPS(rom, patch)
+	}
+}
+
+func ApplyFile(rom []byte, path string) ([]byte, error) {
+	patch, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := Apply(rom, patch)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return patched, nil
+}
+
+type reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.pos+n > len(r.data) {
+		r.err = ErrTruncated
+		return make([]byte, max(n, 0))
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *reader) byte() byte {
+	return r.bytes(1)[0]
+}
+
+// Big endian, as IPS uses
+func (r *reader) uint(n int) int {
+	val := 0
+	for _, b := range r.bytes(n) {
+		val = val<<8 | int(b)
+	}
+	return val
+}
+
+// The variable length numbers of UPS and BPS, where each continuation byte also adds one to
+// avoid having two encodings of the same number
+func (r *reader) varint() int {
+	val, shift := 0, 1
+	for r.err == nil {
+		b := r.byte()
+		val += int(b&0x7F) * shift
+		if b&0x80 != 0 {
+			break
+		}
+		shift <<= 7
+		val += shift
+		if shift > 1<<42 {
+			r.err = fmt.Errorf("number too large")
+		}
+	}
+	return val
+}
+
+// Records of a 24 bit offset and 16 bit length, where a length of 0 repeats one byte. A 24 bit
+// size can follow EOF to truncate the ROM
+func applyIPS(rom []byte, patch []byte) ([]byte, error) {
+	out := append([]byte(nil), rom...)
+	r := &reader{data: patch, pos: len(ipsMagic)}
+
+	write := func(offset int, data []byte) {
+		if end := offset + len(data); end > len(out) {
+			out = append(out, make([]byte, end-len(out))...)
+		}
+		copy(out[offset:], data)
+	}
+
+	for {
+		if bytes.Equal(r.data[r.pos:min(r.pos+len(ipsEOF), len(r.data))], ipsEOF) {
+			r.pos += len(ipsEOF)
+			break
+		}
+
+		offset := r.uint(3)
+		size := r.uint(2)
+		if size > 0 {
+			write(offset, r.bytes(size))
+		} else {
+			count := r.uint(2)
+			write(offset, bytes.Repeat([]byte{r.byte()}, count))
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+
+	if len(patch)-r.pos == 3 {
+		if size := r.uint(3); size < len(out) {
+			out = out[:size]
+		}
+	}
+	return out, nil
+}
+
+// Sizes, then runs of bytes XORed with the source, each after a number of unchanged bytes and
+// ended by a zero
+func applyUPS(rom []byte, patch []byte) ([]byte, error) {
+	if err := checkFooter(rom, patch); err != nil {
+		return nil, err
+	}
+	r := &reader{data: patch[:len(patch)-footerSize], pos: len(upsMagic)}
+
+	sourceSize := r.varint()
+	targetSize := r.varint()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if sourceSize != len(rom) {
+		return nil, fmt.Errorf("patch is for a %d byte ROM, this one is %d bytes", sourceSize, len(rom))
+	}
+	if targetSize > maxTargetSize {
+		return nil, fmt.Errorf("patched ROM would be %d bytes", targetSize)
+	}
+
+	out := make([]byte, targetSize)
+	copy(out, rom)
+	pos := 0
+	for r.pos < len(r.data) && r.err == nil {
+		pos += r.varint()
+		for r.err == nil {
+			b := r.byte()
+			if pos < len(out) {
+				out[pos] ^= b
+			}
+			pos++
+			if b == 0 {
+				break
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if err := checkTarget(out, patch); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Sizes, metadata, then actions that read from the source, the patch, or copy from elsewhere
+// in the source or the output so far
+func applyBPS(rom []byte, patch []byte) ([]byte, error) {
+	if err := checkFooter(rom, patch); err != nil {
+		return nil, err
+	}
+	r := &reader{data: patch[:len(patch)-footerSize], pos: len(bpsMagic)}
+
+	sourceSize := r.varint()
+	targetSize := r.varint()
+	r.bytes(r.varint()) // Metadata
+	if r.err != nil {
+		return nil, r.err
+	}
+	if sourceSize != len(rom) {
+		return nil, fmt.Errorf("patch is for a %d byte ROM, this one is %d bytes", sourceSize, len(rom))
+	}
+	if targetSize > maxTargetSize {
+		return nil, fmt.Errorf("patched ROM would be %d bytes", targetSize)
+	}
+
+	out := make([]byte, targetSize)
+	outPos, sourceRel, targetRel := 0, 0, 0
+	errOutOfRange := errors.New("patch copies from outside the ROM")
+	for r.pos < len(r.data) && r.err == nil {
+		data := r.varint()
+		command, length := data&3, data>>2+1
+		if outPos+length > len(out) {
+			return nil, errors.New("patch writes past the end of the ROM")
+		}
+
+		switch command {
+		case 0: // Source read
+			if outPos+length > len(rom) {
+				return nil, errOutOfRange
+			}
+			copy(out[outPos:], rom[outPos:outPos+length])
+		case 1: // Target read
+			copy(out[outPos:], r.bytes(length))
+		case 2: // Source copy
+			sourceRel += signedOffset(r.varint())
+			if sourceRel < 0 || sourceRel+length > len(rom) {
+				return nil, errOutOfRange
+			}
+			copy(out[outPos:], rom[sourceRel:sourceRel+length])
+			sourceRel += length
+		case 3: // Target copy, byte by byte since it can overlap what it writes
+			targetRel += signedOffset(r.varint())
+			if targetRel < 0 || targetRel >= outPos {
+				return nil, errOutOfRange
+			}
+			for i := 0; i < length; i++ {
+				out[outPos+i] = out[targetRel+i]
+			}
+			targetRel += length
+		}
+		outPos += length
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if err := checkTarget(out, patch); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// The low bit is the sign
+func signedOffset(data int) int {
+	if data&1 != 0 {
+		return -(data >> 1)
+	}
+	return data >> 1
+}
+
+func footer(patch []byte, i int) uint32 {
+	return binary.LittleEndian.Uint32(patch[len(patch)-footerSize+4*i:])
+}
+
+// The patch and source CRC32s are checked before patching
+func checkFooter(rom []byte, patch []byte) error {
+	if len(patch) < len(upsMagic)+footerSize {
+		return ErrTruncated
+	}
+	if want, got := footer(patch, 2), crc32.ChecksumIEEE(patch[:len(patch)-4]); want != got {
+		return ChecksumError{"patch", want, got}
+	}
+	if want, got := footer(patch, 0), crc32.ChecksumIEEE(rom); want != got {
+		return ChecksumError{"source", want, got}
+	}
+	return nil
+}
+
+func checkTarget(out []byte, patch []byte) error {
+	if want, got := footer(patch, 1), crc32.ChecksumIEEE(out); want != got {
+		return ChecksumError{"target", want, got}
+	}
+	return nil
+}
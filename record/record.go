@@ -0,0 +1,149 @@
+package record
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"garboy/display"
+)
+
+type Format int
+
+const (
+	// Headerless RGB24 frames back to back
+	FormatRaw Format = iota
+	// YUV4MPEG2 with full range 4:4:4 chroma, which ffmpeg and most players read directly
+	FormatY4M
+)
+
+// The LCD refreshes every 70224 dots of the 4194304Hz clock
+const clockRate = 4194304
+
+// .y4m picks Y4M, anything else is raw RGB24
+func FormatFor(path string) Format {
+	if strings.EqualFold(filepath.Ext(path), ".y4m") {
+		return FormatY4M
+	}
+	return FormatRaw
+}
+
+// Writes every emulated frame to a file, including blank ones while the LCD is off, so the video
+// stays in step with emulated time. Frames go through the upscaler that was current at the start
+type VideoRecorder struct {
+	ppu      *display.PPU
+	format   Format
+	upscaler display.Upscaler
+	upscaled display.UpscaledFrame
+	width    int
+	height   int
+
+	file *os.File
+	w    *bufio.Writer
+	buf  []byte
+
+	frames uint64
+	done   bool
+	err    error
+}
+
+// Creates the video at path and hooks it into the PPU
+func NewVideoRecorder(path string, ppu *display.PPU) (*VideoRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	upscaler := display.CurrentUpscaler()
+	r := &VideoRecorder{
+		ppu:      ppu,
+		format:   FormatFor(path),
+		upscaler: upscaler,
+		width:    display.ScreenWidth * upscaler.Factor(),
+		height:   display.ScreenHeight * upscaler.Factor(),
+		file:     file,
+		w:        bufio.NewWriterSize(file, 1<<16),
+	}
+	r.buf = make([]byte, r.width*r.height*3)
+
+	if r.format == FormatY4M {
+		_, err := fmt.Fprintf(r.w, "YUV4MPEG2 W%d H%d F%d:%d Ip A1:1 C444 XCOLORRANGE=FULL\n",
+			r.width, r.height, clockRate, display.FrameCycles)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	ppu.SetFrameHook(r.writeFrame)
+	return r, nil
+}
+
+func (r *VideoRecorder) writeFrame(frame *[display.ScreenHeight][display.ScreenWidth]display.Color) {
+	if r.done {
+		return
+	}
+
+	r.upscaler.Apply(frame, &r.upscaled)
+	if r.format == FormatY4M {
+		r.fillYCbCr()
+		if _, err := r.w.WriteString("FRAME\n"); err != nil {
+			r.fail(err)
+			return
+		}
+	} else {
+		r.fillRGB()
+	}
+
+	if _, err := r.w.Write(r.buf); err != nil {
+		r.fail(err)
+		return
+	}
+	r.frames++
+}
+
+func (r *VideoRecorder) fillRGB() {
+	for i, pixel := range r.upscaled.Pix {
+		c := display.ScreenColor(pixel)
+		r.buf[3*i], r.buf[3*i+1], r.buf[3*i+2] = c.R, c.G, c.B
+	}
+}
+
+// Y4M frames are planar: all of Y, then Cb, then Cr
+func (r *VideoRecorder) fillYCbCr() {
+	plane := r.width * r.height
+	for i, pixel := range r.upscaled.Pix {
+		c := display.ScreenColor(pixel)
+		r.buf[i], r.buf[plane+i], r.buf[2*plane+i] = color.RGBToYCbCr(c.R, c.G, c.B)
+	}
+}
+
+func (r *VideoRecorder) fail(err error) {
+	r.err = err
+	r.Close()
+}
+
+// Number of frames written so far
+func (r *VideoRecorder) Frames() uint64 {
+	return r.frames
+}
+
+// Flushes and closes the video. Safe to call more than once
+func (r *VideoRecorder) Close() error {
+	if r.done {
+		return r.err
+	}
+	r.done = true
+	r.ppu.SetFrameHook(nil)
+
+	if err := r.w.Flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+	if err := r.file.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
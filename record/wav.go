@@ -0,0 +1,137 @@
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"garboy/scheduler"
+)
+
+// 16 bit stereo PCM
+const (
+	SampleRate    = 48000
+	wavChannels   = 2
+	wavBits       = 16
+	wavHeaderSize = 44
+)
+
+// What the sound hardware outputs at this point in emulated time
+type SampleSource func() (left, right int16)
+
+var ErrNoSound = errors.New("no sound emulation yet, so there is no audio to record")
+
+// Writes a WAV track clocked off emulated cycles, so sample n is always taken at cycle
+// n*4194304/48000 and the track lines up with a video recorded alongside it
+type AudioRecorder struct {
+	unhook func()
+	source SampleSource
+
+	file *os.File
+	w    *bufio.Writer
+
+	phase   uint64 // Cycles times the sample rate since the last sample
+	samples uint64
+	done    bool
+	err     error
+}
+
+// Creates the WAV file at path and hooks it into the scheduler. source is the sound output,
+// and without one there is nothing to record
+func NewAudioRecorder(path string, scheduler *scheduler.Scheduler, source SampleSource) (*AudioRecorder, error) {
+	if source == nil {
+		return nil, ErrNoSound
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &AudioRecorder{
+		source: source,
+		file:   file,
+		w:      bufio.NewWriterSize(file, 1<<16),
+	}
+	// The sizes are filled in on Close
+	if _, err := r.w.Write(wavHeader(0)); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	r.unhook = scheduler.AddCycleHook(r.step)
+	return r, nil
+}
+
+func (r *AudioRecorder) step(cycles uint16) {
+	if r.done {
+		return
+	}
+
+	r.phase += uint64(cycles) * SampleRate
+	for r.phase >= clockRate {
+		r.phase -= clockRate
+		left, right := r.source()
+		var sample [4]byte
+		binary.LittleEndian.PutUint16(sample[0:], uint16(left))
+		binary.LittleEndian.PutUint16(sample[2:], uint16(right))
+		if _, err := r.w.Write(sample[:]); err != nil {
+			r.fail(err)
+			return
+		}
+		r.samples++
+	}
+}
+
+func (r *AudioRecorder) fail(err error) {
+	r.err = err
+	r.Close()
+}
+
+// Number of stereo samples written so far
+func (r *AudioRecorder) Samples() uint64 {
+	return r.samples
+}
+
+// Fills in the header sizes, flushes and closes the track. Safe to call more than once
+func (r *AudioRecorder) Close() error {
+	if r.done {
+		return r.err
+	}
+	r.done = true
+	r.unhook()
+
+	if err := r.w.Flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+	if r.err == nil {
+		if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+			r.err = err
+		} else if _, err := r.file.Write(wavHeader(uint32(r.samples * wavChannels * wavBits / 8))); err != nil {
+			r.err = err
+		}
+	}
+	if err := r.file.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+func wavHeader(dataSize uint32) []byte {
+	const blockAlign = wavChannels * wavBits / 8
+	header := make([]byte, 0, wavHeaderSize)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, wavHeaderSize-8+dataSize)
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16)
+	header = binary.LittleEndian.AppendUint16(header, 1) // PCM
+	header = binary.LittleEndian.AppendUint16(header, wavChannels)
+	header = binary.LittleEndian.AppendUint32(header, SampleRate)
+	header = binary.LittleEndian.AppendUint32(header, SampleRate*blockAlign)
+	header = binary.LittleEndian.AppendUint16(header, blockAlign)
+	header = binary.LittleEndian.AppendUint16(header, wavBits)
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, dataSize)
+	return header
+}
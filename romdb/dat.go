@@ -0,0 +1,183 @@
+package romdb
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoRoms   = errors.New("no ROMs in the DAT file")
+	ErrBadBlock = errors.New("unbalanced brackets in the DAT file")
+)
+
+type xmlDatafile struct {
+	Games []xmlGame `xml:"game"`
+}
+
+type xmlGame struct {
+	Name string   `xml:"name,attr"`
+	Roms []xmlRom `xml:"rom"`
+}
+
+type xmlRom struct {
+	Size   string `xml:"size,attr"`
+	CRC    string `xml:"crc,attr"`
+	SHA1   string `xml:"sha1,attr"`
+	Status string `xml:"status,attr"`
+}
+
+// Logiqx XML, which No-Intro exports by default:
+//
+//	<game name="Tetris (World) (Rev 1)"><rom name="..." size="32768" crc="..." sha1="..."/></game>
+func parseXML(data []byte) ([]Entry, error) {
+	var dat xmlDatafile
+	if err := xml.Unmarshal(data, &dat); err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, game := range dat.Games {
+		for _, rom := range game.Roms {
+			entry, err := romEntry(game.Name, rom.Size, rom.CRC, rom.SHA1, rom.Status == "baddump")
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoRoms
+	}
+	return entries, nil
+}
+
+func romEntry(name, size, crc, sha1 string, badDump bool) (Entry, error) {
+	bytes, err := strconv.Atoi(size)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%s: size %q", name, size)
+	}
+	sum, err := strconv.ParseUint(crc, 16, 32)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%s: CRC %q", name, crc)
+	}
+	return newEntry(name, bytes, uint32(sum), sha1, badDump), nil
+}
+
+// A ClrMamePro value is either a string or a bracketed list of more key value pairs
+type cmpPair struct {
+	key   string
+	value string
+	block []cmpPair
+}
+
+func (p cmpPair) get(key string) string {
+	for _, child := range p.block {
+		if child.key == key {
+			return child.value
+		}
+	}
+	return ""
+}
+
+type cmpToken struct {
+	text   string
+	quoted bool
+}
+
+func (t cmpToken) is(bracket string) bool {
+	return !t.quoted && t.text == bracket
+}
+
+// ClrMamePro DATs, the other format No-Intro offers:
+//
+//	game ( name "Tetris (World) (Rev 1)" rom ( name "..." size 32768 crc ... sha1 ... ) )
+func parseClrMamePro(data string) ([]Entry, error) {
+	tokens := tokenize(data)
+	pairs, rest, err := parseBlock(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, ErrBadBlock
+	}
+
+	var entries []Entry
+	for _, game := range pairs {
+		if game.key != "game" {
+			continue
+		}
+		for _, rom := range game.block {
+			if rom.key != "rom" {
+				continue
+			}
+			badDump := rom.get("flags") == "baddump" || rom.get("status") == "baddump"
+			entry, err := romEntry(game.get("name"), rom.get("size"), rom.get("crc"), rom.get("sha1"), badDump)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoRoms
+	}
+	return entries, nil
+}
+
+// Key value pairs up to a closing bracket, or the end
+func parseBlock(tokens []cmpToken) ([]cmpPair, []cmpToken, error) {
+	var pairs []cmpPair
+	for len(tokens) > 0 && !tokens[0].is(")") {
+		if len(tokens) < 2 || tokens[0].is("(") {
+			return nil, nil, ErrBadBlock
+		}
+		pair := cmpPair{key: tokens[0].text}
+		if tokens[1].is("(") {
+			block, rest, err := parseBlock(tokens[2:])
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(rest) == 0 {
+				return nil, nil, ErrBadBlock
+			}
+			pair.block, tokens = block, rest[1:]
+		} else if tokens[1].is(")") {
+			return nil, nil, ErrBadBlock
+		} else {
+			pair.value, tokens = tokens[1].text, tokens[2:]
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, tokens, nil
+}
+
+func tokenize(data string) []cmpToken {
+	var tokens []cmpToken
+	for {
+		data = strings.TrimLeft(data, " \t\r\n")
+		if data == "" {
+			return tokens
+		}
+		switch data[0] {
+		case '(', ')':
+			tokens = append(tokens, cmpToken{text: data[:1]})
+			data = data[1:]
+		case '"':
+			end := strings.IndexByte(data[1:], '"')
+			if end < 0 {
+				end = len(data) - 1
+			}
+			tokens = append(tokens, cmpToken{text: data[1 : end+1], quoted: true})
+			data = data[min(end+2, len(data)):]
+		default:
+			end := strings.IndexAny(data, " \t\r\n()\"")
+			if end < 0 {
+				end = len(data)
+			}
+			tokens = append(tokens, cmpToken{text: data[:end]})
+			data = data[end:]
+		}
+	}
+}
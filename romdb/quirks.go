@@ -0,0 +1,102 @@
+package romdb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrBadQuirk = errors.New("quirk lines look like \"Title: ram=32768 multicart\"")
+
+// Fixes for games the header alone doesn't describe
+type Quirks struct {
+	Multicart bool // MBC1M wiring, for multicarts whose second game has no header to find
+	RamSize   int  // Bytes of cartridge RAM when the header gets it wrong, 0 to trust the header
+}
+
+// By title, so every region and revision of a game gets them
+var knownQuirks = map[string]Quirks{
+	"Bomberman Collection": {Multicart: true},
+	"Genjin Collection":    {Multicart: true},
+	"Momotarou Collection": {Multicart: true},
+	"Mortal Kombat I & II": {Multicart: true},
+}
+
+// Adds or replaces the fixes for a title in this database only, for games knownQuirks doesn't
+// cover yet
+func (db *Database) SetQuirks(title string, quirks Quirks) {
+	db.quirks[title] = quirks
+}
+
+func (db *Database) LoadQuirks(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return db.ParseQuirks(data)
+}
+
+// Sets the fixes from a quirks file, one title per line with what it needs after a colon. The
+// RAM size is in bytes, or KB with a K suffix. Blank lines and lines starting with # are skipped:
+//
+//	# Header says 8KB
+//	Wrong RAM: ram=32K
+//	Bomberman Collection: multicart
+func (db *Database) ParseQuirks(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		title, fixes, ok := strings.Cut(text, ":")
+		if !ok || strings.TrimSpace(title) == "" {
+			return fmt.Errorf("line %d: %w", line, ErrBadQuirk)
+		}
+		var quirks Quirks
+		for _, fix := range strings.Fields(fixes) {
+			key, value, _ := strings.Cut(fix, "=")
+			switch key {
+			case "multicart":
+				quirks.Multicart = true
+			case "ram":
+				size, err := parseRamSize(value)
+				if err != nil {
+					return fmt.Errorf("line %d: RAM size %q", line, value)
+				}
+				quirks.RamSize = size
+			default:
+				return fmt.Errorf("line %d: unknown quirk %q", line, key)
+			}
+		}
+		db.SetQuirks(strings.TrimSpace(title), quirks)
+	}
+	return scanner.Err()
+}
+
+func parseRamSize(value string) (int, error) {
+	unit := 1
+	if kb, ok := strings.CutSuffix(strings.ToUpper(value), "K"); ok {
+		value, unit = kb, 1024
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return size * unit, nil
+}
+
+func (db *Database) quirksFor(entry Entry) Quirks {
+	if quirks, ok := db.quirks[entry.Title()]; ok {
+		return quirks
+	}
+	return knownQuirks[entry.Title()]
+}
+
+func (m Match) Quirks() Quirks {
+	return m.quirks
+}
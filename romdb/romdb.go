@@ -0,0 +1,159 @@
+// Package romdb looks ROMs up by hash in No-Intro style DAT files
+package romdb
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"hash/crc32"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A ROM from the DAT file
+type Entry struct {
+	Name     string // Canonical name, e.g. "Tetris (World) (Rev 1)"
+	Region   string // e.g. "USA, Europe", empty if the name doesn't say
+	Revision string // e.g. "Rev 1" or "v1.1", empty for the first release
+	Size     int
+	CRC32    uint32
+	SHA1     string // Lower case hex, empty if the DAT has none
+	BadDump  bool
+}
+
+// A ROM found in the database
+type Match struct {
+	Entry
+	Overdump bool // The file has more data after the ROM in the DAT
+	quirks   Quirks
+}
+
+type Database struct {
+	entries []Entry
+	byCRC   map[uint32][]int
+	sizes   []int // Distinct ROM sizes, for finding overdumps
+	quirks  map[string]Quirks
+}
+
+func Load(path string) (*Database, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Reads Logiqx XML or ClrMamePro DATs, told apart by the first character
+func Parse(data []byte) (*Database, error) {
+	var entries []Entry
+	var err error
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "<") {
+		entries, err = parseXML(data)
+	} else {
+		entries, err = parseClrMamePro(string(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newDatabase(entries), nil
+}
+
+func newDatabase(entries []Entry) *Database {
+	db := &Database{entries: entries, byCRC: map[uint32][]int{}, quirks: map[string]Quirks{}}
+	sizes := map[int]bool{}
+	for i, entry := range entries {
+		db.byCRC[entry.CRC32] = append(db.byCRC[entry.CRC32], i)
+		sizes[entry.Size] = true
+	}
+	for size := range sizes {
+		db.sizes = append(db.sizes, size)
+	}
+	sort.Ints(db.sizes)
+	return db
+}
+
+func (db *Database) Len() int {
+	return len(db.entries)
+}
+
+// Matches on CRC32, size and SHA-1 where the DAT has one. A file that only matches once cut
+// down to the size of an entry is an overdump
+func (db *Database) Lookup(rom []byte) (Match, bool) {
+	if entry, ok := db.find(rom); ok {
+		return Match{Entry: entry, quirks: db.quirksFor(entry)}, true
+	}
+	for _, size := range db.sizes {
+		if size > 0 && size < len(rom) {
+			if entry, ok := db.find(rom[:size]); ok {
+				return Match{Entry: entry, Overdump: true, quirks: db.quirksFor(entry)}, true
+			}
+		}
+	}
+	return Match{}, false
+}
+
+func (db *Database) find(rom []byte) (Entry, bool) {
+	candidates := db.byCRC[crc32.ChecksumIEEE(rom)]
+	if len(candidates) == 0 {
+		return Entry{}, false
+	}
+	sum := sha1.Sum(rom)
+	hash := hex.EncodeToString(sum[:])
+	for _, i := range candidates {
+		entry := db.entries[i]
+		if entry.Size == len(rom) && (entry.SHA1 == "" || entry.SHA1 == hash) {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+var (
+	nameGroups = regexp.MustCompile(`\(([^)]*)\)`)
+	revision   = regexp.MustCompile(`^(Rev [0-9A-Z.]+|v[0-9][0-9.]*)$`)
+	regions    = map[string]bool{
+		"World": true, "USA": true, "Europe": true, "Japan": true, "Asia": true, "Australia": true,
+		"Brazil": true, "Canada": true, "China": true, "France": true, "Germany": true, "Hong Kong": true,
+		"Italy": true, "Korea": true, "Netherlands": true, "Spain": true, "Sweden": true, "Taiwan": true,
+		"UK": true, "Unknown": true,
+	}
+)
+
+// No-Intro names put the region in the first bracketed group and the revision in a later
+// one, e.g. "Tetris (World) (Rev 1)". GoodTools style names mark bad dumps with [b]
+func newEntry(name string, size int, crc uint32, sha1 string, badDump bool) Entry {
+	entry := Entry{
+		Name:    name,
+		Size:    size,
+		CRC32:   crc,
+		SHA1:    strings.ToLower(sha1),
+		BadDump: badDump || strings.Contains(name, "[b]"),
+	}
+	for _, group := range nameGroups.FindAllStringSubmatch(name, -1) {
+		switch {
+		case entry.Region == "" && isRegion(group[1]):
+			entry.Region = group[1]
+		case entry.Revision == "" && revision.MatchString(group[1]):
+			entry.Revision = group[1]
+		}
+	}
+	return entry
+}
+
+func isRegion(group string) bool {
+	for _, region := range strings.Split(group, ", ") {
+		if !regions[region] {
+			return false
+		}
+	}
+	return true
+}
+
+// The name without its bracketed groups
+func (e Entry) Title() string {
+	if i := strings.Index(e.Name, " ("); i >= 0 {
+		return e.Name[:i]
+	}
+	return e.Name
+}
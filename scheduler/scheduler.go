@@ -3,18 +3,27 @@ package scheduler
 import (
 	"garboy/cpu"
 	"garboy/display"
+	"garboy/mmu"
 	"garboy/timer"
 )
 
 type Scheduler struct {
 	cpu   *cpu.CPU
+	mmu   *mmu.MMU
 	ppu   *display.PPU
 	timer *timer.Timer
+
+	cycleHooks []*cycleHook
+}
+
+type cycleHook struct {
+	call func(cycles uint16)
 }
 
-func NewScheduler(cpu *cpu.CPU, ppu *display.PPU, timer *timer.Timer) *Scheduler {
+func NewScheduler(cpu *cpu.CPU, mmu *mmu.MMU, ppu *display.PPU, timer *timer.Timer) *Scheduler {
 	return &Scheduler{
 		cpu:   cpu,
+		mmu:   mmu,
 		ppu:   ppu,
 		timer: timer,
 	}
@@ -22,7 +31,26 @@ func NewScheduler(cpu *cpu.CPU, ppu *display.PPU, timer *timer.Timer) *Scheduler
 
 func (s *Scheduler) Step() uint16 {
 	cycles := s.cpu.Step()
+	s.mmu.Step(cycles)
 	s.timer.Step(cycles)
 	s.ppu.Step(cycles)
+	for _, hook := range s.cycleHooks {
+		hook.call(cycles)
+	}
 	return cycles
 }
+
+// Calls hook after every step with the cycles it took, for anything clocked off emulated time.
+// The returned func removes it again, leaving any other hooks in place
+func (s *Scheduler) AddCycleHook(hook func(cycles uint16)) (remove func()) {
+	h := &cycleHook{call: hook}
+	s.cycleHooks = append(s.cycleHooks, h)
+	return func() {
+		for i, other := range s.cycleHooks {
+			if other == h {
+				s.cycleHooks = append(s.cycleHooks[:i:i], s.cycleHooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	romxStart = 0x4000
+	romxEnd   = 0x7FFF
+
+	// Passed as the bank when the ROM bank mapped at 4000-7FFF isn't known
+	AnyBank = -1
+)
+
+type Symbol struct {
+	Bank    int
+	Address uint16
+	Name    string
+}
+
+// Labels from an RGBDS .sym or .map file. Only 4000-7FFF is treated as banked. A nil *Table
+// is empty, so callers don't need to check whether a symbol file was loaded
+type Table struct {
+	symbols []Symbol // Sorted by address, then bank
+	byName  map[string]Symbol
+}
+
+var (
+	symLine   = regexp.MustCompile(`^([0-9A-Fa-f]+):([0-9A-Fa-f]{1,4})\s+(\S+)`)
+	mapBank   = regexp.MustCompile(`^(\w+) bank #(\d+):`)
+	mapSymbol = regexp.MustCompile(`^\s+\$([0-9A-Fa-f]{1,4}) = (\S+)`)
+)
+
+func Load(path string) (*Table, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	table, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return table, nil
+}
+
+// Looks for foo.sym, then foo.map, next to foo.gb. Returns nil without an error when neither exists
+func LoadForROM(romPath string) (*Table, error) {
+	base := strings.TrimSuffix(romPath, filepath.Ext(romPath))
+	for _, ext := range []string{".sym", ".map"} {
+		path := base + ext
+		if _, err := os.Stat(path); err == nil {
+			return Load(path)
+		}
+	}
+	return nil, nil
+}
+
+// Accepts both "BB:AAAA Label" sym files and rgblink map files
+func Parse(r io.Reader) (*Table, error) {
+	t := &Table{byName: make(map[string]Symbol)}
+	scanner := bufio.NewScanner(r)
+
+	mapFileBank := -1
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if m := symLine.FindStringSubmatch(line); m != nil {
+			bank, _ := strconv.ParseUint(m[1], 16, 16)
+			address, _ := strconv.ParseUint(m[2], 16, 16)
+			t.add(int(bank), uint16(address), m[3])
+			continue
+		}
+
+		if m := mapBank.FindStringSubmatch(line); m != nil {
+			bank, _ := strconv.Atoi(m[2])
+			mapFileBank = bank
+			continue
+		}
+
+		// Section headers, summaries and other map file lines are skipped
+		if m := mapSymbol.FindStringSubmatch(line); m != nil && mapFileBank >= 0 {
+			address, _ := strconv.ParseUint(m[1], 16, 16)
+			t.add(mapFileBank, uint16(address), m[2])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(t.symbols) == 0 {
+		return nil, fmt.Errorf("no symbols found")
+	}
+
+	sort.SliceStable(t.symbols, func(i, j int) bool {
+		if t.symbols[i].Address != t.symbols[j].Address {
+			return t.symbols[i].Address < t.symbols[j].Address
+		}
+		return t.symbols[i].Bank < t.symbols[j].Bank
+	})
+	return t, nil
+}
+
+func (t *Table) add(bank int, address uint16, name string) {
+	if !isBanked(address) {
+		bank = 0
+	}
+
+	sym := Symbol{Bank: bank, Address: address, Name: name}
+	t.symbols = append(t.symbols, sym)
+	if _, exists := t.byName[name]; !exists {
+		t.byName[name] = sym
+	}
+}
+
+func (t *Table) Len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.symbols)
+}
+
+func (t *Table) Resolve(name string) (Symbol, bool) {
+	if t == nil {
+		return Symbol{}, false
+	}
+	sym, ok := t.byName[name]
+	return sym, ok
+}
+
+// Exact label at address, with romBank mapped at 4000-7FFF
+func (t *Table) Lookup(address uint16, romBank int) (Symbol, bool) {
+	if t == nil {
+		return Symbol{}, false
+	}
+	i := sort.Search(len(t.symbols), func(i int) bool { return t.symbols[i].Address >= address })
+
+	var match Symbol
+	matches := 0
+	for ; i < len(t.symbols) && t.symbols[i].Address == address; i++ {
+		sym := t.symbols[i]
+		if !isBanked(address) || romBank == AnyBank || sym.Bank == romBank {
+			if matches == 0 {
+				match = sym
+			}
+			matches++
+		}
+	}
+
+	// Several banks share this address and we don't know which one is mapped
+	if isBanked(address) && romBank == AnyBank && matches > 1 {
+		return Symbol{}, false
+	}
+	return match, matches > 0
+}
+
+// Names an address as "Label" or "Label+offset" using the closest label before it in the same
+// memory region. Returns "" when there is none
+func (t *Table) Label(address uint16, romBank int) string {
+	if t == nil {
+		return ""
+	}
+
+	i := sort.Search(len(t.symbols), func(i int) bool { return t.symbols[i].Address > address })
+	for i--; i >= 0; i-- {
+		sym := t.symbols[i]
+		if region(sym.Address) != region(address) {
+			return ""
+		}
+		if isBanked(address) && romBank != AnyBank && sym.Bank != romBank {
+			continue
+		}
+
+		exact, ok := t.Lookup(sym.Address, romBank)
+		if !ok {
+			return ""
+		}
+		if exact.Address == address {
+			return exact.Name
+		}
+		return fmt.Sprintf("%s+$%X", exact.Name, address-exact.Address)
+	}
+	return ""
+}
+
+func isBanked(address uint16) bool {
+	return address >= romxStart && address <= romxEnd
+}
+
+// ROM0, ROMX, VRAM, SRAM, WRAM, echo/OAM/IO and HRAM
+func region(address uint16) int {
+	switch {
+	case address < 0x4000:
+		return 0
+	case address < 0x8000:
+		return 1
+	case address < 0xA000:
+		return 2
+	case address < 0xC000:
+		return 3
+	case address < 0xE000:
+		return 4
+	case address < 0xFF80:
+		return 5
+	default:
+		return 6
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"garboy/addresses"
+	"garboy/display"
+	"garboy/interrupts"
+)
+
+func TestScreenshotAndGif(t *testing.T) {
+	ppu := display.NewPPU(interrupts.NewInterrupts())
+	ppu.Write(addresses.BgPalette, 0xFF)
+	runFrames := func(n uint64) {
+		for target := ppu.Frames() + n; ppu.Frames() < target; {
+			ppu.Step(4)
+		}
+	}
+	runFrames(2)
+
+	shot := ppu.Screenshot(2)
+	if shot.Bounds().Dx() != 320 || shot.Bounds().Dy() != 288 {
+		t.Errorf("screenshot is %v, want 320x288", shot.Bounds())
+	}
+
+	path := filepath.Join(t.TempDir(), "out.gif")
+	recorder := display.NewGifRecorder(ppu, path, 1, 3)
+	for i := 0; i < 9; i++ {
+		recorder.Capture()
+		runFrames(1)
+	}
+	if recorder.Frames() != 3 {
+		t.Errorf("recorded %d frames, want 3 (every 3rd of 9)", recorder.Frames())
+	}
+	if err := recorder.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	anim, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 3 || anim.Image[0].ColorIndexAt(0, 0) != 3 {
+		t.Errorf("decoded %d frames, first pixel index %d", len(anim.Image), anim.Image[0].ColorIndexAt(0, 0))
+	}
+	// 3 frames at 59.73Hz is 5.02 hundredths of a second
+	for i, delay := range anim.Delay {
+		if delay != 5 {
+			t.Errorf("frame %d delay = %d, want 5", i, delay)
+		}
+	}
+}
+
+// The display goroutine captures while the emulation goroutine runs the PPU. Run with -race. A
+// long skip means most calls only check the frame count
+func TestGifCaptureWhileRunning(t *testing.T) {
+	ppu := display.NewPPU(interrupts.NewInterrupts())
+	recorder := display.NewGifRecorder(ppu, filepath.Join(t.TempDir(), "out.gif"), 1, 10)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ppu.Frames() < 20 {
+			ppu.Step(4)
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			recorder.Capture()
+		}
+	}
+	if recorder.Frames() == 0 {
+		t.Error("captured nothing")
+	}
+}
@@ -0,0 +1,761 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"garboy/cartridge"
+)
+
+func TestCartridgeHeader(t *testing.T) {
+	data, err := os.ReadFile("./test_roms/blargg/instr_timing.gb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := cartridge.ParseHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Title != "INSTR_TIMING" || header.ManufacturerCode != "" || !header.SupportsCGB() || header.CGBOnly() {
+		t.Errorf("parsed %+v", header)
+	}
+	if warnings := header.Warnings(); len(warnings) != 0 {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+
+	// A byte changed after the header only breaks the global checksum
+	data[0x200]++
+	header, _ = cartridge.ParseHeader(data)
+	if warnings := header.Warnings(); len(warnings) != 1 {
+		t.Errorf("warnings = %v, want a global checksum mismatch", warnings)
+	}
+
+	if _, err := cartridge.ParseHeader(data[:0x140]); !errors.Is(err, cartridge.ErrTruncatedRom) {
+		t.Errorf("truncated ROM: got %v", err)
+	}
+	data[0x104] = 0
+	if _, err := cartridge.ParseHeader(data); !errors.Is(err, cartridge.ErrNotGameBoy) {
+		t.Errorf("missing logo: got %v", err)
+	}
+
+	if _, err := cartridge.NewCartridge("./test_roms/missing.gb"); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestCartridgeErrors(t *testing.T) {
+	path := writeTestRom(t, 0, func(rom []byte) { rom[0x147] = 0x20 }) // MBC6
+	var unsupported cartridge.ErrUnsupportedMBC
+	if _, err := cartridge.NewCartridge(path); !errors.As(err, &unsupported) || unsupported.Type != 0x20 {
+		t.Errorf("got %v, want ErrUnsupportedMBC{20}", err)
+	}
+
+	// A 2KB RAM chip leaves the rest of A000-BFFF open, and a ROM cut short reads as FF
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[0x147], data[0x149] = 0x00, 0x01
+	if err := os.WriteFile(path, data[:0x4100], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cart := loadCart(t, path)
+	cart.Write(0xA000, 0x12)
+	cart.Write(0xA900, 0x34)
+	if cart.Read(0xA000) != 0x12 || cart.Read(0xA900) != 0xFF || cart.Read(0x7000) != 0xFF {
+		t.Errorf("read %02X %02X %02X", cart.Read(0xA000), cart.Read(0xA900), cart.Read(0x7000))
+	}
+}
+
+func TestArchivedRoms(t *testing.T) {
+	data, err := os.ReadFile("./test_roms/blargg/instr_timing.gb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := os.ReadFile("./test_roms/blargg/01-special.gb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	// The extension is wrong on purpose, the format comes from the contents
+	zipPath := filepath.Join(dir, "collection.bin")
+	var zipped bytes.Buffer
+	archive := zip.NewWriter(&zipped)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{{"readme.txt", []byte("hi")}, {"roms/timing.gb", data}, {"roms/special.GBC", other}} {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(file.data)
+	}
+	archive.Close()
+	if err := os.WriteFile(zipPath, zipped.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	gzPath := filepath.Join(dir, "timing.gb.gz")
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(data)
+	gz.Close()
+	if err := os.WriteFile(gzPath, gzipped.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path, entry, title, save string
+	}{
+		{zipPath, "", "INSTR_TIMING", filepath.Join(dir, "collection.bin.sav")},
+		{zipPath, "special.GBC", "", filepath.Join(dir, "collection.bin.sav")},
+		{gzPath, "", "INSTR_TIMING", filepath.Join(dir, "timing.sav")},
+	} {
+		cart, err := cartridge.NewCartridgeWith(test.path, cartridge.LoadOptions{Entry: test.entry})
+		if err != nil {
+			t.Errorf("%s %s: %v", test.path, test.entry, err)
+			continue
+		}
+		if cart.Header().Title != test.title || cart.SavePath() != test.save {
+			t.Errorf("%s %s: title %q, save %s", test.path, test.entry, cart.Header().Title, cart.SavePath())
+		}
+	}
+
+	if _, err := cartridge.NewCartridgeWith(zipPath, cartridge.LoadOptions{Entry: "missing.gb"}); err == nil {
+		t.Error("expected an error for a missing zip entry")
+	}
+}
+
+// Every bank starts with its own number
+func bankedRom(size int) []byte {
+	rom := make([]byte, size)
+	for bank := 0; bank < size/cartridge.RomBankSize; bank++ {
+		rom[bank*cartridge.RomBankSize] = byte(bank)
+	}
+	return rom
+}
+
+// instr_timing.gb changed by edit, followed by extraBanks banks that start with their own number
+func writeTestRom(t *testing.T, extraBanks int, edit func(rom []byte)) string {
+	t.Helper()
+	rom, err := os.ReadFile("./test_roms/blargg/instr_timing.gb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom = append(rom, bankedRom((2 + extraBanks) * cartridge.RomBankSize)[2*cartridge.RomBankSize:]...)
+	edit(rom)
+	path := filepath.Join(t.TempDir(), "rom.gb")
+	if err := os.WriteFile(path, rom, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadCart(t *testing.T, path string) *cartridge.Cartridge {
+	t.Helper()
+	cart, err := cartridge.NewCartridge(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cart
+}
+
+// A cartridge of the given type, and the path to load it again from
+func cartWithType(t *testing.T, cartType byte, extraBanks int) (*cartridge.Cartridge, string) {
+	t.Helper()
+	path := writeTestRom(t, extraBanks, func(rom []byte) { rom[0x147] = cartType })
+	return loadCart(t, path), path
+}
+
+// Saves the cartridge and loads it again with its save file
+func reloadCart(t *testing.T, cart *cartridge.Cartridge, path string) *cartridge.Cartridge {
+	t.Helper()
+	if err := cart.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return loadCart(t, path)
+}
+
+func TestMBC1Banking(t *testing.T) {
+	mbc := cartridge.NewMBC1(bankedRom(2*1024*1024), cartridge.CartridgeHeader{CartType: 0x03, RomSize: 0x06, RamSize: 0x03})
+	mbc.Write(0x2000, 0x00)
+	mbc.Write(0x4000, 0x01)
+	if mbc.Read(0x4000) != 0x21 || mbc.Read(0x0000) != 0x00 {
+		t.Errorf("mode 0: banks %02X and %02X, want 00 and 21", mbc.Read(0x0000), mbc.Read(0x4000))
+	}
+	mbc.Write(0x6000, 0x01)
+	if mbc.Read(0x0000) != 0x20 {
+		t.Errorf("mode 1: bank %02X at 0000, want 20", mbc.Read(0x0000))
+	}
+
+	// Mode 1 banks RAM, mode 0 always uses the first bank
+	mbc.Write(0x0000, 0x0A)
+	mbc.Write(0x4000, 0x02)
+	mbc.Write(0xA000, 0x42)
+	mbc.Write(0x6000, 0x00)
+	if mbc.Read(0xA000) == 0x42 {
+		t.Error("mode 0 read RAM bank 2")
+	}
+	mbc.Write(0x6000, 0x01)
+	if mbc.Read(0xA000) != 0x42 {
+		t.Error("mode 1 lost RAM bank 2")
+	}
+
+	// Bank bits past the ROM size aren't connected
+	small := cartridge.NewMBC1(bankedRom(512*1024), cartridge.CartridgeHeader{CartType: 0x01, RomSize: 0x04})
+	small.Write(0x2000, 0x05)
+	small.Write(0x4000, 0x01)
+	if small.Read(0x4000) != 0x05 || small.RomBank() != 0x05 {
+		t.Errorf("512KB ROM: bank %02X, want 05", small.Read(0x4000))
+	}
+}
+
+func TestMBC1Multicart(t *testing.T) {
+	rom := bankedRom(1024 * 1024)
+	logo, err := os.ReadFile("./test_roms/blargg/instr_timing.gb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for game := 0; game < 4; game++ {
+		copy(rom[game*256*1024+0x104:], logo[0x104:0x134])
+	}
+
+	mbc := cartridge.NewMBC1(rom, cartridge.CartridgeHeader{CartType: 0x01, RomSize: 0x05})
+	mbc.Write(0x4000, 0x01)
+	mbc.Write(0x2000, 0x02)
+	if mbc.Read(0x4000) != 0x12 {
+		t.Errorf("bank %02X, want 12 from 4 bit wiring", mbc.Read(0x4000))
+	}
+	mbc.Write(0x2000, 0x10)
+	if mbc.Read(0x4000) != 0x10 {
+		t.Errorf("bank %02X, want the second game's first bank", mbc.Read(0x4000))
+	}
+	mbc.Write(0x6000, 0x01)
+	mbc.Write(0x4000, 0x03)
+	if mbc.Read(0x0000) != 0x30 {
+		t.Errorf("mode 1: bank %02X at 0000, want 30", mbc.Read(0x0000))
+	}
+}
+
+func TestBatterySaves(t *testing.T) {
+	for _, tc := range []struct {
+		cartType byte
+		battery  bool
+	}{{0x02, false}, {0x03, true}, {0x12, false}, {0x10, true}, {0x13, true}} {
+		path := writeTestRom(t, 0, func(rom []byte) { rom[0x147], rom[0x149] = tc.cartType, 0x03 })
+		cart := loadCart(t, path)
+		if cart.HasBattery() != tc.battery {
+			t.Errorf("%02X: battery %v, want %v", tc.cartType, cart.HasBattery(), tc.battery)
+			continue
+		}
+		if !tc.battery {
+			continue
+		}
+		cart.Write(0x0000, 0x0A)
+		cart.Write(0xA000, 0x42)
+		cart = reloadCart(t, cart, path)
+		cart.Write(0x0000, 0x0A)
+		if cart.Read(0xA000) != 0x42 {
+			t.Errorf("%02X: after reloading RAM read %02X", tc.cartType, cart.Read(0xA000))
+		}
+	}
+
+	// A halted MBC3 clock comes back with the registers it was set to
+	cart, path := cartWithType(t, 0x0F, 0)
+	cart.Write(0x0000, 0x0A)
+	cart.Write(0x4000, 0x08)
+	cart.Write(0xA000, 0x2A)
+	cart.Write(0x4000, 0x0C)
+	cart.Write(0xA000, 0x40)
+	cart = reloadCart(t, cart, path)
+	cart.Write(0x0000, 0x0A)
+	cart.Write(0x6000, 0x00)
+	cart.Write(0x6000, 0x01)
+	cart.Write(0x4000, 0x08)
+	if cart.Read(0xA000) != 0x2A {
+		t.Errorf("after reloading the RTC seconds read %02X, want 2A", cart.Read(0xA000))
+	}
+}
+
+func TestHudsonCarts(t *testing.T) {
+	huc1 := cartridge.NewHuC1(bankedRom(512*1024), cartridge.CartridgeHeader{CartType: 0xFF, RomSize: 0x04, RamSize: 0x03})
+	huc3 := cartridge.NewHuC3(bankedRom(512*1024), cartridge.CartridgeHeader{CartType: 0xFE, RomSize: 0x04, RamSize: 0x03})
+	huc1.Write(0x2000, 0x05)
+	huc3.Write(0x2000, 0x1F)
+	if huc1.Read(0x4000) != 0x05 || huc3.Read(0x4000) != 0x1F {
+		t.Errorf("banks %02X and %02X, want 05 and 1F", huc1.Read(0x4000), huc3.Read(0x4000))
+	}
+
+	// Both carts in IR mode see each other's LED
+	a, b := cartridge.NewIRLink()
+	huc1.SetIR(a)
+	huc3.SetIR(b)
+	huc1.Write(0x0000, 0x0E)
+	huc3.Write(0x0000, 0x0E)
+	huc1.Write(0xA000, 0x01)
+	if huc3.Read(0xA000) != 0xC1 || huc1.Read(0xA000) != 0xC0 {
+		t.Errorf("IR reads %02X and %02X, want C1 and C0", huc3.Read(0xA000), huc1.Read(0xA000))
+	}
+
+	// HuC3 RTC commands go through mode B and run when the semaphore is written
+	command := func(cmd, arg byte) {
+		huc3.Write(0x0000, 0x0B)
+		huc3.Write(0xA000, cmd<<4|arg)
+		huc3.Write(0x0000, 0x0D)
+		huc3.Write(0xA000, 0x00)
+	}
+	response := func() byte {
+		huc3.Write(0x0000, 0x0C)
+		return huc3.Read(0xA000) & 0x0F
+	}
+
+	// Day 0x123 at 10:00, which is minute 0x258 of the day
+	command(0x4, 0x0)
+	command(0x5, 0x0)
+	for _, nibble := range []byte{0x8, 0x5, 0x2, 0x3, 0x2, 0x1} {
+		command(0x3, nibble)
+	}
+	command(0x6, 0x1)
+	for i := 0; i < 6; i++ {
+		command(0x3, 0)
+	}
+	command(0x6, 0x0)
+
+	command(0x4, 0x0)
+	var got []byte
+	for i := 0; i < 6; i++ {
+		command(0x1, 0)
+		got = append(got, response())
+	}
+	if !bytes.Equal(got, []byte{0x8, 0x5, 0x2, 0x3, 0x2, 0x1}) {
+		t.Errorf("RTC read back %X", got)
+	}
+
+	huc3.Write(0x0000, 0x0A)
+	huc3.Write(0x4000, 0x01)
+	huc3.Write(0xA000, 0x42)
+	huc3.Write(0x0000, 0x00)
+	if huc3.Read(0xA000) != 0x42 {
+		t.Error("HuC3 RAM write lost")
+	}
+}
+
+func TestHudsonSaves(t *testing.T) {
+	for _, cartType := range []byte{0xFF, 0xFE} {
+		path := writeTestRom(t, 0, func(rom []byte) { rom[0x147], rom[0x149] = cartType, 0x03 })
+		cart := loadCart(t, path)
+		if !cart.HasBattery() {
+			t.Fatalf("%02X: no battery", cartType)
+		}
+		cart.Write(0x0000, 0x0A)
+		cart.Write(0x4000, 0x02)
+		cart.Write(0xA000, 0x42)
+		cart = reloadCart(t, cart, path)
+		cart.Write(0x0000, 0x0A)
+		cart.Write(0x4000, 0x02)
+		if cart.Read(0xA000) != 0x42 {
+			t.Errorf("%02X: after reloading RAM bank 2 read %02X", cartType, cart.Read(0xA000))
+		}
+	}
+
+	// The HuC3 clock keeps counting from where it was set, and its memory is kept
+	path := writeTestRom(t, 0, func(rom []byte) { rom[0x147], rom[0x149] = 0xFE, 0x03 })
+	cart := loadCart(t, path)
+	command := func(cmd, arg byte) {
+		cart.Write(0x0000, 0x0B)
+		cart.Write(0xA000, cmd<<4|arg)
+		cart.Write(0x0000, 0x0D)
+		cart.Write(0xA000, 0x00)
+	}
+	readNibbles := func(address byte, n int) []byte {
+		command(0x4, address&0x0F)
+		command(0x5, address>>4)
+		var got []byte
+		for i := 0; i < n; i++ {
+			command(0x1, 0)
+			cart.Write(0x0000, 0x0C)
+			got = append(got, cart.Read(0xA000)&0x0F)
+		}
+		return got
+	}
+
+	// Day 0x123 at 10:00, and a nibble the game keeps at 0x40
+	command(0x4, 0x0)
+	command(0x5, 0x0)
+	for _, nibble := range []byte{0x8, 0x5, 0x2, 0x3, 0x2, 0x1} {
+		command(0x3, nibble)
+	}
+	command(0x6, 0x1)
+	command(0x4, 0x0)
+	command(0x5, 0x4)
+	command(0x3, 0x7)
+
+	cart = reloadCart(t, cart, path)
+	command(0x6, 0x0)
+	if got := readNibbles(0x00, 6); !bytes.Equal(got, []byte{0x8, 0x5, 0x2, 0x3, 0x2, 0x1}) {
+		t.Errorf("after reloading the clock read %X", got)
+	}
+	if got := readNibbles(0x40, 1); got[0] != 0x7 {
+		t.Errorf("after reloading RTC memory 40 read %X", got[0])
+	}
+}
+
+func TestMBC7(t *testing.T) {
+	cart, path := cartWithType(t, 0x22, 0)
+	var tilt cartridge.TiltSensor
+	if !cart.SetAccelerometer(&tilt) || !cart.HasBattery() {
+		t.Fatal("MBC7 should have an accelerometer and a battery")
+	}
+	cart.Write(0x0000, 0x0A)
+	cart.Write(0x4000, 0x40)
+
+	// Latching only works after erasing
+	tilt.Set(1, -0.5)
+	cart.Write(0xA010, 0xAA)
+	if cart.Read(0xA020) != 0x00 || cart.Read(0xA030) != 0x80 {
+		t.Errorf("latched without erasing: %02X%02X", cart.Read(0xA030), cart.Read(0xA020))
+	}
+	cart.Write(0xA000, 0x55)
+	cart.Write(0xA010, 0xAA)
+	x := uint16(cart.Read(0xA030))<<8 | uint16(cart.Read(0xA020))
+	y := uint16(cart.Read(0xA050))<<8 | uint16(cart.Read(0xA040))
+	if x != 0x81D0+0x70 || y != 0x81D0-0x38 {
+		t.Errorf("latched %04X, %04X", x, y)
+	}
+
+	// EEPROM commands are shifted in on rising clock edges with CS high
+	send := func(val uint16, bits int) {
+		for i := bits - 1; i >= 0; i-- {
+			di := byte(val>>i&1) << 1
+			cart.Write(0xA080, 0x80|di)
+			cart.Write(0xA080, 0xC0|di)
+		}
+	}
+	deselect := func() { cart.Write(0xA080, 0x00) }
+	send(0b100_1100_0000, 11) // Enable writes
+	deselect()
+	send(0b101_0000_0101, 11) // Write word 5
+	send(0xBEEF, 16)
+	deselect()
+	readWord := func(address uint16) uint16 {
+		send(0b110_0000_0000|address, 11)
+		if cart.Read(0xA080)&1 != 0 {
+			t.Error("missing the dummy 0 before read data")
+		}
+		word := uint16(0)
+		for i := 0; i < 16; i++ {
+			cart.Write(0xA080, 0x80)
+			cart.Write(0xA080, 0xC0)
+			word = word<<1 | uint16(cart.Read(0xA080)&1)
+		}
+		deselect()
+		return word
+	}
+	if got := readWord(5); got != 0xBEEF {
+		t.Errorf("read back %04X, want BEEF", got)
+	}
+
+	// The EEPROM survives in the save file
+	cart = reloadCart(t, cart, path)
+	cart.Write(0x0000, 0x0A)
+	cart.Write(0x4000, 0x40)
+	if got := readWord(5); got != 0xBEEF {
+		t.Errorf("after reloading read %04X, want BEEF", got)
+	}
+}
+
+type grayFrame uint8
+
+func (g grayFrame) Frame() *image.Gray {
+	frame := image.NewGray(image.Rect(0, 0, cartridge.CameraWidth, cartridge.CameraHeight))
+	for i := range frame.Pix {
+		frame.Pix[i] = uint8(g)
+	}
+	return frame
+}
+
+func TestCamera(t *testing.T) {
+	cart, path := cartWithType(t, 0xFC, 0)
+	if !cart.HasCamera() || !cart.SetCameraSource(grayFrame(0x80)) || !cart.HasBattery() {
+		t.Fatal("the camera should take a source and have a battery")
+	}
+
+	// RAM banks 0-15 hold 128KB
+	cart.Write(0x0000, 0x0A)
+	cart.Write(0x4000, 0x0F)
+	cart.Write(0xA000, 0x42)
+	cart.Write(0x4000, 0x00)
+	cart.Write(0xA000, 0x24)
+	cart.Write(0x4000, 0x0F)
+	if cart.Read(0xA000) != 0x42 {
+		t.Errorf("RAM bank 15 read %02X", cart.Read(0xA000))
+	}
+
+	// Thresholds of 0x40, 0x80 and 0xC0 everywhere make 0x80 shade 1, and 0x40 shade 2
+	capture := func(exposure uint16, invert bool) (lo, hi byte) {
+		cart.Write(0x4000, 0x10)
+		cart.Write(0xA002, byte(exposure>>8))
+		cart.Write(0xA003, byte(exposure))
+		ratio := byte(0)
+		if invert {
+			ratio = 0x08
+		}
+		cart.Write(0xA004, ratio)
+		for i := 0; i < 16; i++ {
+			cart.Write(0xA006+uint16(i)*3, 0x40)
+			cart.Write(0xA007+uint16(i)*3, 0x80)
+			cart.Write(0xA008+uint16(i)*3, 0xC0)
+		}
+		cart.Write(0xA000, 0x01)
+		if cart.Read(0xA000)&0x01 != 0 {
+			t.Error("capture still busy")
+		}
+		cart.Write(0x4000, 0x00)
+		// Last row of the last tile
+		return cart.Read(0xA100 + 16*14*16 - 2), cart.Read(0xA100 + 16*14*16 - 1)
+	}
+	if lo, hi := capture(0x0800, false); lo != 0xFF || hi != 0x00 {
+		t.Errorf("gray captured as %02X %02X, want shade 1", lo, hi)
+	}
+	if lo, hi := capture(0x0400, false); lo != 0x00 || hi != 0xFF {
+		t.Errorf("underexposed gray captured as %02X %02X, want shade 2", lo, hi)
+	}
+	if lo, hi := capture(0x0400, true); lo != 0xFF || hi != 0x00 {
+		t.Errorf("inverted capture %02X %02X, want shade 1", lo, hi)
+	}
+
+	// The pictures are saved
+	cart = reloadCart(t, cart, path)
+	cart.Write(0x4000, 0x0F)
+	if cart.Read(0xA000) != 0x42 {
+		t.Errorf("after reloading RAM bank 15 read %02X", cart.Read(0xA000))
+	}
+}
+
+func TestCameraSources(t *testing.T) {
+	dir := t.TempDir()
+	for i, shade := range []uint8{0x00, 0xFF} {
+		img := image.NewGray(image.Rect(0, 0, 256, 224))
+		for p := range img.Pix {
+			img.Pix[p] = shade
+		}
+		f, err := os.Create(filepath.Join(dir, string(rune('a'+i))+".png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	source, err := cartridge.NewCameraSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []uint8{0x00, 0xFF, 0x00} {
+		frame := source.Frame()
+		if frame.Bounds().Dx() != cartridge.CameraWidth || frame.Bounds().Dy() != cartridge.CameraHeight {
+			t.Fatalf("frame is %v", frame.Bounds())
+		}
+		if got := frame.GrayAt(64, 56); got != (color.Gray{Y: want}) {
+			t.Errorf("got %v, want %02X", got, want)
+		}
+	}
+
+	if _, err := cartridge.NewCameraSource(filepath.Join(dir, "a.png")); err != nil {
+		t.Error(err)
+	}
+	if _, err := cartridge.NewCameraSource(t.TempDir()); !errors.Is(err, cartridge.ErrNoImages) {
+		t.Errorf("empty directory gave %v", err)
+	}
+	pattern, _ := cartridge.NewCameraSource("pattern")
+	if bytes.Equal(pattern.Frame().Pix, pattern.Frame().Pix) {
+		t.Error("the pattern should move between captures")
+	}
+}
+
+func TestMMM01(t *testing.T) {
+	logo, err := os.ReadFile("./test_roms/blargg/instr_timing.gb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom := bankedRom(16 * cartridge.RomBankSize)
+	menu := rom[len(rom)-2*cartridge.RomBankSize:]
+	copy(menu[0x104:0x134], logo[0x104:0x134])
+	menu[0x147] = 0x0D
+	menu[0x149] = 0x03
+	mbc, err := cartridge.NewMBC(rom, cartridge.CartridgeHeader{CartType: 0x01})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The menu in the last 32KB runs first
+	if mbc.Read(0x0000) != 14 || mbc.Read(0x4000) != 15 {
+		t.Errorf("unmapped banks %02X and %02X, want 0E and 0F", mbc.Read(0x0000), mbc.Read(0x4000))
+	}
+
+	// A game at bank 4 that can only change RB0 and RB1
+	mbc.Write(0x2000, 0x04)
+	mbc.Write(0x6000, 0x0E<<2)
+	mbc.Write(0x0000, 0x40|0x0A)
+	if mbc.Read(0x0000) != 4 || mbc.Read(0x4000) != 5 {
+		t.Errorf("mapped banks %02X and %02X, want 04 and 05", mbc.Read(0x0000), mbc.Read(0x4000))
+	}
+	mbc.Write(0x2000, 0x1F)
+	if mbc.Read(0x4000) != 7 {
+		t.Errorf("game bank 1F mapped %02X, want 07", mbc.Read(0x4000))
+	}
+
+	// The mapping is locked once set
+	mbc.Write(0x0000, 0x00)
+	mbc.Write(0x2000, 0x62)
+	if mbc.Read(0x0000) != 4 || mbc.Read(0x4000) != 6 {
+		t.Errorf("after locking banks %02X and %02X, want 04 and 06", mbc.Read(0x0000), mbc.Read(0x4000))
+	}
+
+	// RAM size comes from the menu's header
+	mbc.Write(0x0000, 0x0A)
+	mbc.Write(0x6000, 0x01)
+	mbc.Write(0x4000, 0x03)
+	mbc.Write(0xA000, 0x42)
+	mbc.Write(0x4000, 0x00)
+	if mbc.Read(0xA000) == 0x42 {
+		t.Error("RAM bank 3 is the same as bank 0")
+	}
+	mbc.Write(0x4000, 0x03)
+	if mbc.Read(0xA000) != 0x42 {
+		t.Errorf("RAM bank 3 read %02X", mbc.Read(0xA000))
+	}
+}
+
+func TestMMM01Battery(t *testing.T) {
+	if cart, _ := cartWithType(t, 0x0C, 2); cart.HasBattery() {
+		t.Error("MMM01+RAM shouldn't have a battery")
+	}
+	path := writeTestRom(t, 2, func(rom []byte) {
+		rom[0x147] = 0x0D
+		rom[0x149] = 0x02
+	})
+	cart := loadCart(t, path)
+	if !cart.HasBattery() {
+		t.Fatal("MMM01+RAM+BATTERY should have a battery")
+	}
+	cart.Write(0x0000, 0x0A)
+	cart.Write(0xA123, 0x5A)
+	cart = reloadCart(t, cart, path)
+	cart.Write(0x0000, 0x0A)
+	if cart.Read(0xA123) != 0x5A {
+		t.Errorf("saved RAM read %02X, want 5A", cart.Read(0xA123))
+	}
+}
+
+func TestTAMA5(t *testing.T) {
+	cart, path := cartWithType(t, 0xFD, 2)
+	if !cart.HasBattery() {
+		t.Fatal("TAMA5 should have a battery")
+	}
+	set := func(reg, val byte) {
+		cart.Write(0xA001, reg)
+		cart.Write(0xA000, val)
+	}
+	get := func(reg byte) byte {
+		cart.Write(0xA001, reg)
+		return cart.Read(0xA000)
+	}
+	setRtc := func(reg, digit byte) {
+		set(0x04, digit)
+		set(0x06, 0x04<<1)
+		set(0x07, reg)
+	}
+	getRtc := func(reg byte) byte {
+		set(0x06, 0x02<<1)
+		set(0x07, reg)
+		return get(0x0C) & 0x0F
+	}
+
+	if get(0x0A)&0x01 == 0 {
+		t.Error("not ready for commands")
+	}
+	set(0x00, 0x03)
+	set(0x01, 0x00)
+	if cart.Read(0x4000) != 3 {
+		t.Errorf("ROM bank 3 read %02X", cart.Read(0x4000))
+	}
+
+	// Write 0xA5 to byte 0x13, then read it back
+	set(0x04, 0x05)
+	set(0x05, 0x0A)
+	set(0x06, 0x00<<1|0x01)
+	set(0x07, 0x03)
+	set(0x06, 0x01<<1|0x01)
+	set(0x07, 0x03)
+	if lo, hi := get(0x0C)&0x0F, get(0x0D)&0x0F; hi<<4|lo != 0xA5 {
+		t.Errorf("read back %X%X, want A5", hi, lo)
+	}
+
+	// 23:59:00 on Saturday 31/12/99, set from the seconds up so nothing carries in between
+	rtc := []struct{ reg, digit byte }{
+		{0x0, 0}, {0x1, 0}, {0x2, 9}, {0x3, 5}, {0x4, 3}, {0x5, 2},
+		{0x6, 6}, {0x7, 1}, {0x8, 3}, {0x9, 2}, {0xA, 1}, {0xB, 9}, {0xC, 9},
+	}
+	for _, r := range rtc {
+		setRtc(r.reg, r.digit)
+	}
+	for _, r := range rtc {
+		if got := getRtc(r.reg); got != r.digit {
+			t.Errorf("RTC register %X read %X, want %X", r.reg, got, r.digit)
+		}
+	}
+
+	// Digits out of range are kept as written
+	setRtc(0x5, 0x9)
+	if got := getRtc(0x5); got != 0x9 || getRtc(0x4) != 3 || getRtc(0x7) != 1 {
+		t.Errorf("hours tens read %X, want 9 without touching the other digits", got)
+	}
+	setRtc(0x5, 0x2)
+
+	// The clock and RAM are saved, and the clock catches up with the time spent switched off
+	if err := cart.Save(); err != nil {
+		t.Fatal(err)
+	}
+	save, err := os.ReadFile(cart.SavePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(save) != 32+13+8 {
+		t.Fatalf("save is %d bytes, want RAM, RTC digits and the time", len(save))
+	}
+	saved := binary.LittleEndian.Uint64(save[45:])
+	binary.LittleEndian.PutUint64(save[45:], saved-120)
+	if err := os.WriteFile(cart.SavePath(), save, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cart = loadCart(t, path)
+	set(0x06, 0x01<<1|0x01)
+	set(0x07, 0x03)
+	if lo, hi := get(0x0C)&0x0F, get(0x0D)&0x0F; hi<<4|lo != 0xA5 {
+		t.Errorf("after reloading read %X%X, want A5", hi, lo)
+	}
+	var got []byte
+	for reg := byte(0x2); reg < 0xD; reg++ {
+		got = append(got, getRtc(reg))
+	}
+	// 00:01 on Sunday 01/01/00
+	if !bytes.Equal(got, []byte{1, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0}) {
+		t.Errorf("two minutes later the RTC read %X", got)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"garboy/cartridge"
+	"garboy/cpu"
+	"garboy/debugger"
+	"garboy/display"
+	"garboy/interrupts"
+	"garboy/mmu"
+	"garboy/scheduler"
+	"garboy/symbols"
+	"garboy/timer"
+)
+
+// A loop that calls two levels of subroutines and stores A to C000 each time round
+var debuggerProgram = map[uint16][]byte{
+	0x0100: {0x00, 0xC3, 0x50, 0x01}, // NOP; JP 0150
+	0x0150: {0x3E, 0x00},             // LD A,00
+	0x0152: {0xCD, 0x00, 0x02},       // CALL 0200
+	0x0155: {0x3C},                   // INC A
+	0x0156: {0xEA, 0x00, 0xC0},       // LD (C000),A
+	0x0159: {0x18, 0xF7},             // JR 0152
+	0x0200: {0xCD, 0x00, 0x03},       // CALL 0300
+	0x0203: {0xC9},                   // RET
+	0x0300: {0x47, 0xC9},             // LD B,A; RET
+}
+
+// A system running debuggerProgram from 0100
+func newLoopSystem(t *testing.T) (*cpu.CPU, *mmu.MMU, *display.PPU, *scheduler.Scheduler) {
+	t.Helper()
+	header, err := os.ReadFile("./test_roms/blargg/instr_timing.gb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom := make([]byte, 2*cartridge.RomBankSize)
+	copy(rom[0x104:0x150], header[0x104:0x150])
+	rom[0x147], rom[0x148], rom[0x149] = 0x00, 0x00, 0x00
+	for address, code := range debuggerProgram {
+		copy(rom[address:], code)
+	}
+	path := filepath.Join(t.TempDir(), "debugger.gb")
+	if err := os.WriteFile(path, rom, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cart, err := cartridge.NewCartridge(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interrupts := interrupts.NewInterrupts()
+	ppu := display.NewPPU(interrupts)
+	timer := timer.NewTimer(interrupts)
+	mmu := mmu.NewMMU(cart, ppu, timer, display.NewJoypad(), interrupts)
+	cpu := cpu.NewCPU(mmu, interrupts)
+	scheduler := scheduler.NewScheduler(cpu, mmu, ppu, timer)
+	cpu.SkipBootROM()
+	return cpu, mmu, ppu, scheduler
+}
+
+func newTestDebugger(t *testing.T) *debugger.Debugger {
+	t.Helper()
+	cpu, mmu, _, scheduler := newLoopSystem(t)
+	return debugger.NewDebugger(cpu, mmu, scheduler)
+}
+
+func TestDebugger(t *testing.T) {
+	// Runs to the loop, just before CALL 0200
+	toLoop := func(t *testing.T, d *debugger.Debugger) {
+		if _, err := d.AddBreakpoint(0x0152, ""); err != nil {
+			t.Fatal(err)
+		}
+		d.Continue()
+		d.RemoveBreakpoint(0x0152)
+	}
+
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, d *debugger.Debugger)
+		run    func(d *debugger.Debugger) debugger.StopEvent
+		reason debugger.StopReason
+		pc     uint16
+		a      uint16
+		stack  int
+	}{
+		{
+			name: "breakpoint",
+			setup: func(t *testing.T, d *debugger.Debugger) {
+				if _, err := d.AddBreakpoint(0x0155, ""); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run:    (*debugger.Debugger).Continue,
+			reason: debugger.StopBreakpoint,
+			pc:     0x0155,
+		},
+		{
+			name: "conditional breakpoint",
+			setup: func(t *testing.T, d *debugger.Debugger) {
+				if _, err := d.AddBreakpoint(0x0155, "A == 3"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			run:    (*debugger.Debugger).Continue,
+			reason: debugger.StopBreakpoint,
+			pc:     0x0155,
+			a:      3,
+		},
+		{
+			name: "watchpoint",
+			setup: func(t *testing.T, d *debugger.Debugger) {
+				d.AddWatchpoint(0xC000, debugger.WatchWrite)
+			},
+			run:    (*debugger.Debugger).Continue,
+			reason: debugger.StopWatchpoint,
+			pc:     0x0159,
+			a:      1,
+		},
+		{
+			name:   "step into a call",
+			setup:  toLoop,
+			run:    (*debugger.Debugger).Step,
+			reason: debugger.StopStep,
+			pc:     0x0200,
+			stack:  1,
+		},
+		{
+			name:   "step over a call",
+			setup:  toLoop,
+			run:    (*debugger.Debugger).StepOver,
+			reason: debugger.StopStep,
+			pc:     0x0155,
+		},
+		{
+			name: "step out of a nested call",
+			setup: func(t *testing.T, d *debugger.Debugger) {
+				toLoop(t, d)
+				d.Step()
+				d.Step()
+			},
+			run:    (*debugger.Debugger).StepOut,
+			reason: debugger.StopStep,
+			pc:     0x0203,
+			stack:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDebugger(t)
+			tt.setup(t, d)
+			ev := tt.run(d)
+			if ev.Reason != tt.reason || ev.PC != tt.pc {
+				t.Errorf("stopped with reason %d at %04X, want %d at %04X", ev.Reason, ev.PC, tt.reason, tt.pc)
+			}
+			if a, _ := d.Register("A"); a != tt.a {
+				t.Errorf("A is %02X, want %02X", a, tt.a)
+			}
+			if stack := d.CallStack(); len(stack) != tt.stack {
+				t.Errorf("call stack %+v, want %d frames", stack, tt.stack)
+			}
+		})
+	}
+}
+
+func TestDebuggerCallStack(t *testing.T) {
+	d := newTestDebugger(t)
+	if _, err := d.AddBreakpoint(0x0300, ""); err != nil {
+		t.Fatal(err)
+	}
+	d.Continue()
+	want := []debugger.Frame{{Caller: 0x0152, Target: 0x0200}, {Caller: 0x0200, Target: 0x0300}}
+	stack := d.CallStack()
+	if len(stack) != len(want) || stack[0] != want[0] || stack[1] != want[1] {
+		t.Errorf("call stack %+v, want %+v", stack, want)
+	}
+}
+
+func TestDebuggerREPLCounts(t *testing.T) {
+	tests := []struct {
+		command string
+		pc      uint16
+	}{
+		{"s #2", 0x0150}, // NOP, JP
+		{"s 3", 0x0152},  // Counts are hex like addresses
+		{"s A", 0x0159},
+	}
+	for _, tt := range tests {
+		d := newTestDebugger(t)
+		if err := d.RunREPL(strings.NewReader(tt.command+"\nq\n"), io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		if d.PC() != tt.pc {
+			t.Errorf("%q stopped at %04X, want %04X", tt.command, d.PC(), tt.pc)
+		}
+	}
+
+	var out strings.Builder
+	d := newTestDebugger(t)
+	if err := d.RunREPL(strings.NewReader("s #x\nq\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "invalid step count") {
+		t.Errorf("bad count gave %q", out.String())
+	}
+}
+
+func TestDebuggerDelete(t *testing.T) {
+	table, err := symbols.Parse(strings.NewReader(testSymbols))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command string
+		left    []string // Breakpoints still set, as bank:address
+	}{
+		{"d 02:4000", []string{"01:4000", "03:4000", "02:4010"}},
+		{"d BankOneInit", []string{"02:4000", "03:4000", "02:4010"}},
+		{"d BankTwoLoop", []string{"01:4000", "02:4000", "03:4000"}},
+		{"d 4000", []string{"02:4010"}},
+	}
+	for _, tt := range tests {
+		d := newTestDebugger(t)
+		d.SetSymbols(table)
+		var out strings.Builder
+		script := "b 01:4000\nb 02:4000\nb 03:4000\nb BankTwoLoop\n" + tt.command + "\nq\n"
+		if err := d.RunREPL(strings.NewReader(script), &out); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(out.String(), "error") {
+			t.Errorf("%q: %s", tt.command, out.String())
+		}
+
+		var left []string
+		for _, bp := range d.Breakpoints() {
+			left = append(left, fmt.Sprintf("%02X:%04X", bp.Bank, bp.Address))
+		}
+		if strings.Join(left, " ") != strings.Join(tt.left, " ") {
+			t.Errorf("%q left %v, want %v", tt.command, left, tt.left)
+		}
+	}
+
+	var out strings.Builder
+	d := newTestDebugger(t)
+	if err := d.RunREPL(strings.NewReader("b 02:4000\nd 03:4000\nq\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "no breakpoint at 03:4000") || len(d.Breakpoints()) != 1 {
+		t.Errorf("deleting another bank's breakpoint gave %q", out.String())
+	}
+}
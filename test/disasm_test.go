@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"garboy/disasm"
+)
+
+func TestDisassembler(t *testing.T) {
+	tests := []struct {
+		address uint16
+		data    []uint8
+		want    string
+		length  int
+	}{
+		{0x0100, []uint8{0x00}, "NOP", 1},
+		{0x0150, []uint8{0xF0, 0x44}, "LD A,($FF44)", 2},
+		{0x0150, []uint8{0xE0, 0x40}, "LD ($FF40),A", 2},
+		{0x0150, []uint8{0x20, 0xFE}, "JR NZ,$0150", 2},
+		{0x0150, []uint8{0x18, 0x10}, "JR $0162", 2},
+		{0x0000, []uint8{0xC3, 0x50, 0x01}, "JP $0150", 3},
+		{0x0000, []uint8{0xCD, 0x34, 0x12}, "CALL $1234", 3},
+		{0x0000, []uint8{0x01, 0xEF, 0xBE}, "LD BC,$BEEF", 3},
+		{0x0000, []uint8{0x3E, 0x3F}, "LD A,$3F", 2},
+		{0x0000, []uint8{0xEA, 0x00, 0x20}, "LD ($2000),A", 3},
+		{0x0000, []uint8{0xF8, 0xFD}, "LD HL,SP-$03", 2},
+		{0x0000, []uint8{0xE8, 0x05}, "ADD SP,+$05", 2},
+		{0x0000, []uint8{0xFF}, "RST $38", 1},
+		{0x0000, []uint8{0xCB, 0x7C}, "BIT 7,H", 2},
+		{0x0000, []uint8{0xD3}, "DB $D3", 1},
+		{0x0000, []uint8{0xC3, 0x50}, "DB $C3", 1},
+	}
+
+	for _, tc := range tests {
+		instr := disasm.Decode(tc.data, tc.address)
+		if instr.Text != tc.want || instr.Length() != tc.length {
+			t.Errorf("Decode(% X) = %q (%d bytes), want %q (%d bytes)", tc.data, instr.Text, instr.Length(), tc.want, tc.length)
+		}
+	}
+}
+
+func TestCodeDataSeparation(t *testing.T) {
+	// D3 is an invalid opcode, so the vectors and anything unreached stay data
+	rom := make([]uint8, 4*0x4000)
+	for i := range rom {
+		rom[i] = 0xD3
+	}
+	code := map[int][]uint8{
+		0x0100: {0xC3, 0x50, 0x01}, // JP $0150, with the header after it
+		0x0150: {0x3E, 0x02},       // LD A,$02
+		0x0152: {0xEA, 0x00, 0x20}, // LD ($2000),A
+		0x0155: {0xCD, 0x00, 0x40}, // CALL $4000 in bank 2
+		0x0158: {0x18, 0xF6},       // JR $0150
+		0x015A: {0x3E, 0x10},       // Data that decodes as LD A,$10
+		0x4000: {0xC9},             // Bank 1 is never selected
+		0x8000: {0xC9, 0x3E, 0x10}, // RET, then data
+	}
+	for offset, bytes := range code {
+		copy(rom[offset:], bytes)
+	}
+
+	a := disasm.Analyze(rom)
+	tests := []struct {
+		offset int
+		code   bool
+	}{
+		{0x0100, true}, {0x0102, true}, {0x0103, false}, {0x0104, false}, {0x014F, false},
+		{0x0150, true}, {0x0159, true}, {0x015A, false}, {0x015B, false},
+		{0x4000, false}, {0x8000, true}, {0x8001, false},
+		{0x0000, false}, {0x0038, false},
+	}
+	for _, tc := range tests {
+		if a.IsCode(tc.offset) != tc.code {
+			t.Errorf("IsCode(%04X) = %v, want %v", tc.offset, !tc.code, tc.code)
+		}
+	}
+
+	var out strings.Builder
+	if err := a.WriteBank(&out, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"00:0158  18 F6     JR $0150", "00:015A            DB $3E,$10"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("bank 0 listing is missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"garboy/display"
+)
+
+func TestPostFilter(t *testing.T) {
+	var black, white [display.ScreenHeight][display.ScreenWidth]display.Color
+	for y := range black {
+		for x := range black[y] {
+			black[y][x] = 3
+		}
+	}
+
+	// Half of the white frame is still there after switching to black
+	blend := display.NewPostFilter(1, display.FilterOptions{Blend: 0.5})
+	lightest := blend.Apply(&white).RGBAAt(0, 0)
+	darkest := display.CurrentPalette().BG[3]
+	got := blend.Apply(&black).RGBAAt(0, 0)
+	if want := (int(lightest.R) + int(darkest.R)) / 2; int(got.R) < want || int(got.R) > want+1 {
+		t.Errorf("blended red = %d, want about %d", got.R, want)
+	}
+	for i := 0; i < 20; i++ {
+		got = blend.Apply(&black).RGBAAt(0, 0)
+	}
+	if got != darkest {
+		t.Errorf("blending never settled: %v, want %v", got, darkest)
+	}
+
+	grid := display.NewPostFilter(3, display.FilterOptions{Grid: true})
+	img := grid.Apply(&white)
+	if img.Bounds().Dx() != 480 || img.Bounds().Dy() != 432 {
+		t.Fatalf("scaled image is %v, want 480x432", img.Bounds())
+	}
+	if img.RGBAAt(0, 0) != lightest || img.RGBAAt(2, 0).R >= lightest.R || img.RGBAAt(0, 2).R >= lightest.R {
+		t.Error("expected the last row and column of each pixel to be darker")
+	}
+
+	if display.NewPostFilter(3, display.FilterOptions{Blend: 0.5}).Scaled() {
+		t.Error("blending alone should leave scaling to the GPU")
+	}
+}
+
+// Has to stay well under 16ms a frame at the largest window size
+func BenchmarkPostFilter(b *testing.B) {
+	var frame [display.ScreenHeight][display.ScreenWidth]display.Color
+	for y := range frame {
+		for x := range frame[y] {
+			frame[y][x] = display.Color((x ^ y) & 3)
+		}
+	}
+
+	filter := display.NewPostFilter(6, display.FilterOptions{Blend: 0.5, Scanlines: true, Smooth: true})
+	for i := 0; i < b.N; i++ {
+		filter.Apply(&frame)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"garboy/cartridge"
+	"garboy/cpu"
+	"garboy/debugger"
+	"garboy/display"
+	"garboy/interrupts"
+	"garboy/mmu"
+	"garboy/scheduler"
+	"garboy/timer"
+)
+
+type gdbClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Sends a packet and returns the reply, checking the ack and checksum
+func (c *gdbClient) request(packet string) string {
+	c.t.Helper()
+	fmt.Fprintf(c.conn, "$%s#%02x", packet, gdbChecksum(packet))
+
+	if ack, err := c.r.ReadByte(); err != nil || ack != '+' {
+		c.t.Fatalf("%s: expected ack, got %q (%v)", packet, ack, err)
+	}
+	return c.reply()
+}
+
+func (c *gdbClient) reply() string {
+	c.t.Helper()
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	data = data[:len(data)-1]
+
+	sum := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, sum); err != nil {
+		c.t.Fatal(err)
+	}
+	if string(sum) != fmt.Sprintf("%02x", gdbChecksum(data)) {
+		c.t.Fatalf("bad checksum %s for %q", sum, data)
+	}
+	c.conn.Write([]byte("+"))
+	return data
+}
+
+func (c *gdbClient) expect(packet, want string) {
+	c.t.Helper()
+	if got := c.request(packet); got != want {
+		c.t.Errorf("%s = %q, want %q", packet, got, want)
+	}
+}
+
+func gdbChecksum(data string) uint8 {
+	var sum uint8
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+func TestGDBStub(t *testing.T) {
+	cartridge, err := cartridge.NewCartridge("./test_roms/blargg/01-special.gb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	interrupts := interrupts.NewInterrupts()
+	ppu := display.NewPPU(interrupts)
+	timer := timer.NewTimer(interrupts)
+	joypad := display.NewJoypad()
+	mmu := mmu.NewMMU(cartridge, ppu, timer, joypad, interrupts)
+	cpu := cpu.NewCPU(mmu, interrupts)
+	scheduler := scheduler.NewScheduler(cpu, mmu, ppu, timer)
+	cpu.SkipBootROM()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go debugger.NewDebugger(cpu, mmu, scheduler).ServeGDB(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	c := &gdbClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	if got := c.request("qSupported:swbreak+"); !strings.Contains(got, "qXfer:features:read+") {
+		t.Errorf("qSupported = %q", got)
+	}
+	c.expect("?", "S05")
+
+	// A F B C D E H L, then SP and PC little endian
+	c.expect("g", "01b0001300d8014dfeff0001")
+	c.expect("m100,4", "00c31302")
+	c.expect("Mc000,2:abcd", "OK")
+	c.expect("mc000,2", "abcd")
+	c.expect("P0=42", "OK")
+	c.expect("p0", "42")
+
+	c.expect("s", "S05")
+	c.expect("p9", "0101")
+
+	c.expect("Z0,213,1", "OK")
+	c.expect("c", "S05")
+	c.expect("p9", "1302")
+	c.expect("z0,213,1", "OK")
+
+	c.expect("Z2,c000,1", "OK")
+	c.expect("P6=c0", "OK")
+	c.expect("P7=00", "OK")
+	c.expect("P0=99", "OK")
+	c.expect("Mc100,1:77", "OK")
+	c.expect("P9=00c1", "OK") // LD (HL),A at C100
+	c.expect("c", "T05watch:c000;")
+	c.expect("mc000,1", "99")
+	c.expect("z2,c000,1", "OK")
+
+	// Spin on JR -2 until the client sends Ctrl-C
+	c.expect("Mc200,2:18fe", "OK")
+	c.expect("P9=00c2", "OK")
+	fmt.Fprintf(conn, "$c#%02x", gdbChecksum("c"))
+	if ack, _ := c.r.ReadByte(); ack != '+' {
+		t.Fatalf("expected ack for c, got %q", ack)
+	}
+	time.Sleep(50 * time.Millisecond)
+	conn.Write([]byte{0x03})
+	if got := c.reply(); got != "S02" {
+		t.Errorf("interrupted continue = %q, want S02", got)
+	}
+	c.expect("p9", "00c2")
+
+	c.expect("D", "OK")
+}
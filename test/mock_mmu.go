@@ -36,3 +36,5 @@ func (m *MockMmu) WriteWord(address uint16, val uint16) {
 func (m *MockMmu) SetBootRomEnabled(val bool) {
 	m.bootROMEnabled = val
 }
+
+func (m *MockMmu) IncDec16(address uint16) {}
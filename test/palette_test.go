@@ -0,0 +1,50 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"garboy/display"
+)
+
+func TestLoadPalette(t *testing.T) {
+	dir := t.TempDir()
+	text := filepath.Join(dir, "red.pal")
+	if err := os.WriteFile(text, []byte("# Red sprites\nbg: FFFFFF AAAAAA 555555 000000\nobj0: #FFFFFF #FF8484 #943A3A #000000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	jsonPath := filepath.Join(dir, "blue.json")
+	if err := os.WriteFile(jsonPath, []byte(`{"name": "Blue", "bg": ["#FFFFFF", "#65A49B", "#0000FE", "#000000"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	red, err := display.LoadPalette(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if red.Name != "red" || red.BG[1] != (color.RGBA{0xAA, 0xAA, 0xAA, 255}) || red.Obj0[1] != (color.RGBA{0xFF, 0x84, 0x84, 255}) || red.Obj1 != red.Obj0 {
+		t.Errorf("unexpected text palette %+v", red)
+	}
+
+	blue, err := display.LoadPalette(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blue.Name != "Blue" || blue.BG[2] != (color.RGBA{0, 0, 0xFE, 255}) || blue.Obj0 != blue.BG || blue.Obj1 != blue.BG {
+		t.Errorf("unexpected JSON palette %+v", blue)
+	}
+
+	bad := filepath.Join(dir, "bad.pal")
+	if err := os.WriteFile(bad, []byte("bg: FFFFFF 000000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := display.LoadPalette(bad); err == nil {
+		t.Error("expected an error for a palette with 2 colors")
+	}
+
+	if _, ok := display.PresetPalette("pocket"); !ok {
+		t.Error("missing pocket preset")
+	}
+}
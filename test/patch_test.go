@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"garboy/cartridge"
+	"garboy/patch"
+)
+
+func encodeVarint(n int) []byte {
+	var out []byte
+	for {
+		b := byte(n & 0x7F)
+		n >>= 7
+		if n == 0 {
+			return append(out, b|0x80)
+		}
+		out = append(out, b)
+		n--
+	}
+}
+
+func withFooter(body []byte, source, target []byte) []byte {
+	body = binary.LittleEndian.AppendUint32(body, crc32.ChecksumIEEE(source))
+	body = binary.LittleEndian.AppendUint32(body, crc32.ChecksumIEEE(target))
+	return binary.LittleEndian.AppendUint32(body, crc32.ChecksumIEEE(body))
+}
+
+func TestPatches(t *testing.T) {
+	source := bytes.Repeat([]byte{1, 2, 3, 4}, 64)
+	target := append([]byte(nil), source...)
+	target[10], target[11] = 0xAA, 0xBB
+	target = append(target, 0x55, 0x55, 0x55, 0x55)
+	original := append([]byte(nil), source...)
+
+	ips := []byte("PATCH")
+	ips = append(ips, 0, 0, 10, 0, 2, 0xAA, 0xBB) // 2 bytes at 10
+	ips = append(ips, 0, 1, 0, 0, 0, 0, 4, 0x55)  // 0x55 4 times at 256
+	ips = append(ips, []byte("EOF")...)
+
+	ups := append([]byte("UPS1"), encodeVarint(len(source))...)
+	ups = append(ups, encodeVarint(len(target))...)
+	ups = append(ups, encodeVarint(10)...)
+	ups = append(ups, source[10]^0xAA, source[11]^0xBB, 0)
+	ups = append(ups, encodeVarint(len(source)-13)...)
+	ups = append(ups, 0x55, 0x55, 0x55, 0x55, 0)
+	ups = withFooter(ups, source, target)
+
+	// Read 10 bytes from the source, 2 from the patch, copy the rest of the source and repeat the
+	// last 0x55 with a copy that overlaps itself
+	action := func(command, length int) []byte { return encodeVarint((length-1)<<2 | command) }
+	bps := append([]byte("BPS1"), encodeVarint(len(source))...)
+	bps = append(bps, encodeVarint(len(target))...)
+	bps = append(bps, encodeVarint(0)...)
+	bps = append(bps, action(0, 10)...)
+	bps = append(bps, action(1, 2)...)
+	bps = append(bps, 0xAA, 0xBB)
+	bps = append(bps, action(2, len(source)-12)...)
+	bps = append(bps, encodeVarint(12<<1)...)
+	bps = append(bps, action(1, 1)...)
+	bps = append(bps, 0x55)
+	bps = append(bps, action(3, 3)...)
+	bps = append(bps, encodeVarint(len(source)<<1)...)
+	bps = withFooter(bps, source, target)
+
+	for name, p := range map[string][]byte{"IPS": ips, "UPS": ups, "BPS": bps} {
+		got, err := patch.Apply(source, p)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !bytes.Equal(got, target) {
+			t.Errorf("%s: patched ROM differs", name)
+		}
+	}
+	if !bytes.Equal(source, original) {
+		t.Error("patching changed the source")
+	}
+
+	var checksum patch.ChecksumError
+	if _, err := patch.Apply(target, bps); !errors.As(err, &checksum) || checksum.What != "source" {
+		t.Errorf("wrong source: got %v", err)
+	}
+	if _, err := patch.Apply(source, []byte("nope")); !errors.Is(err, patch.ErrUnknownFormat) {
+		t.Errorf("unknown format: got %v", err)
+	}
+}
+
+func TestPatchNextToRom(t *testing.T) {
+	rom, err := os.ReadFile("./test_roms/blargg/instr_timing.gb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	romPath := filepath.Join(dir, "game.gb")
+	if err := os.WriteFile(romPath, rom, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Renames the game to ZZ
+	ips := append([]byte("PATCH"), 0, 0x01, 0x34, 0, 2, 'Z', 'Z')
+	ips = append(ips, []byte("EOF")...)
+	if err := os.WriteFile(filepath.Join(dir, "game.ips"), ips, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cart, err := cartridge.NewCartridge(romPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.Header().Title != "ZZSTR_TIMING" || cart.PatchPath() != filepath.Join(dir, "game.ips") {
+		t.Errorf("title %q, patch %q", cart.Header().Title, cart.PatchPath())
+	}
+	if onDisk, _ := os.ReadFile(romPath); !bytes.Equal(onDisk, rom) {
+		t.Error("the ROM file was modified")
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"testing"
+
+	"garboy/addresses"
+	"garboy/display"
+	"garboy/interrupts"
+)
+
+// Dots spent in mode 3 on the first visible line after setup configures the PPU
+func measureMode3(setup func(p *display.PPU)) int {
+	ppu := display.NewPPU(interrupts.NewInterrupts())
+	ppu.Write(addresses.LcdControl, 0x93) // LCD, BG and sprites on
+	setup(ppu)
+
+	// Run to the start of line 1 so the whole line is measured
+	for ppu.Read(addresses.Ly) != 1 {
+		ppu.Step(1)
+	}
+
+	dots := 0
+	for ppu.Read(addresses.Ly) == 1 {
+		if ppu.Read(addresses.LcdStatus)&3 == display.VramMode {
+			dots++
+		}
+		ppu.Step(1)
+	}
+	return dots
+}
+
+func TestMode3Length(t *testing.T) {
+	sprite := func(x uint8) func(p *display.PPU) {
+		return func(p *display.PPU) {
+			p.Write(addresses.Oam, 16)
+			p.Write(addresses.Oam+1, x)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		setup func(p *display.PPU)
+		want  int
+	}{
+		{"no scroll", func(p *display.PPU) {}, 172},
+		{"SCX 3", func(p *display.PPU) { p.Write(addresses.ScrollX, 3) }, 175},
+		{"SCX 7", func(p *display.PPU) { p.Write(addresses.ScrollX, 7) }, 179},
+		{"window", func(p *display.PPU) {
+			p.Write(addresses.LcdControl, 0xB3)
+			p.Write(addresses.WindowY, 1)
+			p.Write(addresses.WindowX, 7)
+		}, 178},
+		{"sprite at X 0", sprite(0), 183},
+		{"sprite at X 8", sprite(8), 183},
+		{"sprite at X 13", sprite(13), 178},
+		{"sprite at X 168", sprite(168), 172},
+	}
+
+	for _, tc := range tests {
+		if got := measureMode3(tc.setup); got != tc.want {
+			t.Errorf("%s: mode 3 took %d dots, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+// Steps until LY and the STAT mode match
+func runUntilMode(p *display.PPU, ly uint8, mode uint8) {
+	for p.Read(addresses.Ly) != ly || p.Read(addresses.LcdStatus)&3 != mode {
+		p.Step(1)
+	}
+}
+
+func TestVramOamAccessRestrictions(t *testing.T) {
+	ppu := display.NewPPU(interrupts.NewInterrupts())
+	ppu.Write(addresses.LcdControl, 0x00)
+	ppu.Write(addresses.Vram, 0x12)
+	ppu.Write(addresses.Oam, 0x34)
+	ppu.Write(addresses.LcdControl, 0x91)
+
+	runUntilMode(ppu, 1, display.OamMode)
+	if got := ppu.Read(addresses.Oam); got != 0xFF {
+		t.Errorf("OAM read in mode 2 = %02X, want FF", got)
+	}
+	if got := ppu.Read(addresses.Vram); got != 0x12 {
+		t.Errorf("VRAM read in mode 2 = %02X, want 12", got)
+	}
+
+	runUntilMode(ppu, 1, display.VramMode)
+	ppu.Write(addresses.Vram, 0x56)
+	if got := ppu.Read(addresses.Vram); got != 0xFF {
+		t.Errorf("VRAM read in mode 3 = %02X, want FF", got)
+	}
+
+	ppu.SetAccessRestrictions(false)
+	if got := ppu.Read(addresses.Vram); got != 0x12 {
+		t.Errorf("relaxed VRAM read in mode 3 = %02X, want 12 (write should have been dropped)", got)
+	}
+	ppu.SetAccessRestrictions(true)
+
+	runUntilMode(ppu, 1, display.HBlankMode)
+	if got := ppu.Read(addresses.Oam); got != 0x34 {
+		t.Errorf("OAM read in HBlank = %02X, want 34", got)
+	}
+}
+
+func TestOamDmaFromVramInMode3(t *testing.T) {
+	_, mmu, ppu, _ := newLoopSystem(t)
+	ppu.Write(addresses.LcdControl, 0x00)
+	for i := uint16(0); i < 0xA0; i++ {
+		ppu.Write(addresses.Vram+i, uint8(i))
+	}
+	ppu.Write(addresses.LcdControl, 0x91)
+
+	// The CPU sees FF in mode 3 but DMA copies the real bytes
+	runUntilMode(ppu, 1, display.VramMode)
+	if got := mmu.Read(addresses.Vram + 1); got != 0xFF {
+		t.Fatalf("CPU VRAM read in mode 3 = %02X, want FF", got)
+	}
+	mmu.DmaTransfer(0x80)
+	mmu.Step(4 + 0xA0*4)
+	if mode := ppu.Read(addresses.LcdStatus) & 3; mode != display.VramMode {
+		t.Fatalf("left mode 3 during the test, in mode %d", mode)
+	}
+
+	ppu.Write(addresses.LcdControl, 0x00)
+	for i := uint16(0); i < 0xA0; i++ {
+		if got := ppu.Read(addresses.Oam + i); got != uint8(i) {
+			t.Fatalf("OAM[%02X] = %02X, want %02X", i, got, i)
+		}
+	}
+}
+
+func TestOamCorruptionBug(t *testing.T) {
+	ppu := display.NewPPU(interrupts.NewInterrupts())
+	ppu.Write(addresses.LcdControl, 0x00)
+	for i := uint16(0); i < 16; i++ {
+		ppu.Write(addresses.Oam+i, uint8(0x10+i))
+	}
+	ppu.Write(addresses.LcdControl, 0x91)
+
+	// At the start of mode 2 the access lands while the PPU reads row 1
+	runUntilMode(ppu, 1, display.OamMode)
+	ppu.TriggerOamBug(0xFE10)
+
+	ppu.Write(addresses.LcdControl, 0x00)
+	a, b, c := uint16(0x1918), uint16(0x1110), uint16(0x1514)
+	word := ((a ^ c) & (b ^ c)) ^ c
+	want := []uint8{uint8(word), uint8(word >> 8), 0x12, 0x13, 0x14, 0x15, 0x16, 0x17}
+	for i, w := range want {
+		if got := ppu.Read(addresses.Oam + 8 + uint16(i)); got != w {
+			t.Errorf("OAM[%d] = %02X, want %02X", 8+i, got, w)
+		}
+	}
+}
+
+func TestStatInterruptBlocking(t *testing.T) {
+	ints := interrupts.NewInterrupts()
+	ppu := display.NewPPU(ints)
+	ppu.Write(addresses.LcdControl, 0x91)
+	ppu.Write(addresses.Lyc, 1)
+	ppu.Write(addresses.LcdStatus, 0x48) // LYC and HBlank sources
+
+	// LY=LYC holds the line high for all of line 1, so its HBlank can't raise another interrupt
+	runUntilMode(ppu, 1, display.VramMode)
+	if ints.IF()&(1<<interrupts.LcdInterrupt) == 0 {
+		t.Fatal("expected a LY=LYC interrupt on line 1")
+	}
+	ints.Clear(interrupts.LcdInterrupt)
+
+	runUntilMode(ppu, 1, display.HBlankMode)
+	if ints.IF()&(1<<interrupts.LcdInterrupt) != 0 {
+		t.Error("HBlank on line 1 requested an interrupt while LY=LYC held the line")
+	}
+
+	runUntilMode(ppu, 2, display.HBlankMode)
+	if ints.IF()&(1<<interrupts.LcdInterrupt) == 0 {
+		t.Error("expected a HBlank interrupt on line 2")
+	}
+}
+
+func TestLcdOffBlankScreen(t *testing.T) {
+	ppu := display.NewPPU(interrupts.NewInterrupts())
+	ppu.Write(addresses.BgPalette, 0xFF) // Every BG color is black
+	runFrames := func(n uint64) {
+		for target := ppu.Frames() + n; ppu.Frames() < target; {
+			ppu.Step(4)
+		}
+	}
+
+	// The PPU starts in the middle of line 0, so the first frame misses it
+	runFrames(2)
+	if got := ppu.GetFrameBuffer()[0][0]; got != 3 {
+		t.Fatalf("pixel before LCD off = %d, want 3", got)
+	}
+
+	runUntilMode(ppu, 10, display.HBlankMode)
+	ppu.Write(addresses.LcdControl, 0x11)
+	if got := ppu.GetFrameBuffer()[0][0]; got != 0 {
+		t.Errorf("pixel with the LCD off = %d, want 0", got)
+	}
+	ppu.Step(1000)
+	if ly, stat := ppu.Read(addresses.Ly), ppu.Read(addresses.LcdStatus); ly != 0 || stat&3 != 0 {
+		t.Errorf("LCD off: LY = %d, STAT mode = %d, want 0 and 0", ly, stat&3)
+	}
+
+	ppu.Write(addresses.LcdControl, 0x91)
+	runFrames(1)
+	if got := ppu.GetFrameBuffer()[0][0]; got != 0 {
+		t.Errorf("first frame after LCD on was shown, pixel = %d", got)
+	}
+	runFrames(1)
+	if got := ppu.GetFrameBuffer()[0][0]; got != 3 {
+		t.Errorf("second frame after LCD on: pixel = %d, want 3", got)
+	}
+}
+
+func TestVramViewers(t *testing.T) {
+	ppu := display.NewPPU(interrupts.NewInterrupts())
+	ppu.Write(addresses.LcdControl, 0x00)
+
+	// Tile 1 row 0 is color 3, and it sits at the top left of the 9800 map
+	ppu.Write(addresses.Vram+16, 0xFF)
+	ppu.Write(addresses.Vram+17, 0xFF)
+	ppu.Write(addresses.Vram+0x1800, 1)
+	ppu.Write(addresses.LcdControl, 0x10) // Unsigned tile data
+	ppu.Write(addresses.ScrollX, 100)
+	ppu.Write(addresses.ScrollY, 50)
+
+	sheet := ppu.TileSheet(0xE4)
+	if sheet.Bounds().Dx() != 128 || sheet.Bounds().Dy() != 192 {
+		t.Errorf("tile sheet is %v, want 128x192", sheet.Bounds())
+	}
+	if sheet.RGBAAt(8, 0) == sheet.RGBAAt(0, 0) {
+		t.Error("tile 1 should differ from the empty tile 0")
+	}
+
+	bg := ppu.TileMap(display.TileMap0)
+	if bg.RGBAAt(0, 0) != sheet.RGBAAt(8, 0) {
+		t.Errorf("BG map pixel %v, want tile 1 color %v", bg.RGBAAt(0, 0), sheet.RGBAAt(8, 0))
+	}
+	// The viewport outline wraps from X 100 to 259 and Y 50 to 193
+	viewport := bg.RGBAAt(100, 50)
+	if bg.RGBAAt(3, 50) != viewport || bg.RGBAAt(100, 193) != viewport || bg.RGBAAt(101, 51) == viewport {
+		t.Error("viewport rectangle is in the wrong place")
+	}
+
+	ppu.Write(addresses.Oam+4, 0x20)
+	ppu.Write(addresses.Oam+5, 0x18)
+	ppu.Write(addresses.Oam+6, 0x02)
+	ppu.Write(addresses.Oam+7, 0xB0)
+	entry := ppu.OamEntries()[1]
+	if entry.String() != "01 Y:20 X:18 T:02 P-X1" {
+		t.Errorf("OAM entry 1 = %q", entry)
+	}
+}
+
+func TestVramViewersWhileRunning(t *testing.T) {
+	ppu := display.NewPPU(interrupts.NewInterrupts())
+	if snapshot := ppu.ViewerSnapshot(); snapshot.Read(addresses.BgPalette) != 0 {
+		t.Errorf("first snapshot BGP = %02X, want a blank copy", snapshot.Read(addresses.BgPalette))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ppu.Frames() < 10 {
+			ppu.Write(addresses.BgPalette, uint8(ppu.Frames()))
+			ppu.Write(addresses.Vram+16, uint8(ppu.Frames()))
+			ppu.Write(addresses.Oam+4, uint8(ppu.Frames()))
+			ppu.Step(4)
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			snapshot := ppu.ViewerSnapshot()
+			snapshot.TileSheet(snapshot.Read(addresses.BgPalette))
+			snapshot.TileMap(display.TileMap0)
+			snapshot.OamSheet()
+		}
+	}
+
+	// The last snapshot was taken as frame 10 finished
+	if got := ppu.ViewerSnapshot().Read(addresses.BgPalette); got != 9 {
+		t.Errorf("snapshot BGP = %02X, want 09", got)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"garboy/addresses"
+	"garboy/display"
+	"garboy/interrupts"
+	"garboy/record"
+)
+
+func TestVideoRecorder(t *testing.T) {
+	const frameSize = display.ScreenWidth * display.ScreenHeight * 3
+
+	for _, name := range []string{"out.y4m", "out.rgb"} {
+		ppu := display.NewPPU(interrupts.NewInterrupts())
+		path := filepath.Join(t.TempDir(), name)
+		recorder, err := record.NewVideoRecorder(path, ppu)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Two frames with the LCD on, then one blank one with it off
+		for ppu.Frames() < 2 {
+			ppu.Step(4)
+		}
+		ppu.Write(addresses.LcdControl, 0x11)
+		for ppu.Frames() < 3 {
+			ppu.Step(4)
+		}
+		if err := recorder.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 3 * frameSize
+		if strings.HasSuffix(name, ".y4m") {
+			header, _, _ := strings.Cut(string(data), "\n")
+			if header != "YUV4MPEG2 W160 H144 F4194304:70224 Ip A1:1 C444 XCOLORRANGE=FULL" {
+				t.Errorf("Y4M header = %q", header)
+			}
+			want += len(header) + 1 + 3*len("FRAME\n")
+		}
+		if recorder.Frames() != 3 || len(data) != want {
+			t.Errorf("%s: %d frames in %d bytes, want 3 in %d", name, recorder.Frames(), len(data), want)
+		}
+	}
+}
+
+func TestAudioRecorder(t *testing.T) {
+	_, _, ppu, scheduler := newLoopSystem(t)
+	path := filepath.Join(t.TempDir(), "out.wav")
+	if _, err := record.NewAudioRecorder(path, scheduler, nil); !errors.Is(err, record.ErrNoSound) {
+		t.Errorf("recording without a sound source gave %v", err)
+	}
+
+	// Another hook keeps running after the recorder unhooks itself
+	var hooked uint64
+	scheduler.AddCycleHook(func(cycles uint16) { hooked += uint64(cycles) })
+	recorder, err := record.NewAudioRecorder(path, scheduler, func() (int16, int16) { return 0x1234, -2 })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cycles uint64
+	for ppu.Frames() < 3 {
+		cycles += uint64(scheduler.Step())
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Stepping after Close doesn't write anything
+	if after := uint64(scheduler.Step()); hooked != cycles+after {
+		t.Errorf("other hook saw %d cycles, want %d", hooked, cycles+after)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := cycles * record.SampleRate / 4194304
+	if recorder.Samples() != want || uint64(len(data)) != 44+4*want {
+		t.Fatalf("%d samples in %d bytes after %d cycles, want %d", recorder.Samples(), len(data), cycles, want)
+	}
+	if string(data[0:4]) != "RIFF" || string(data[8:16]) != "WAVEfmt " || string(data[36:40]) != "data" {
+		t.Errorf("header %q", data[:44])
+	}
+	if size := binary.LittleEndian.Uint32(data[4:]); size != uint32(len(data)-8) {
+		t.Errorf("RIFF size %d, want %d", size, len(data)-8)
+	}
+	if size := binary.LittleEndian.Uint32(data[40:]); size != uint32(4*want) {
+		t.Errorf("data size %d, want %d", size, 4*want)
+	}
+	if rate := binary.LittleEndian.Uint32(data[24:]); rate != record.SampleRate {
+		t.Errorf("sample rate %d", rate)
+	}
+	if left, right := binary.LittleEndian.Uint16(data[44:]), int16(binary.LittleEndian.Uint16(data[46:])); left != 0x1234 || right != -2 {
+		t.Errorf("first sample %04X %d", left, right)
+	}
+}
@@ -24,20 +24,24 @@ func runRomTest(t *testing.T, romPath string) {
 	isBlarggTest := strings.Contains(romPath, "blargg")
 	isMooneyeTest := strings.Contains(romPath, "mooneye")
 
+	cartridge, err := cartridge.NewCartridge(romPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	if isBlarggTest {
 		originalStdout = os.Stdout
 		r, w, _ = os.Pipe()
 		os.Stdout = w
 	}
 
-	cartridge := cartridge.NewCartridge(romPath)
 	interrupts := interrupts.NewInterrupts()
 	ppu := display.NewPPU(interrupts)
 	timer := timer.NewTimer(interrupts)
 	joypad := display.NewJoypad()
 	mmu := mmu.NewMMU(cartridge, ppu, timer, joypad, interrupts)
 	cpu := cpu.NewCPU(mmu, interrupts)
-	scheduler := scheduler.NewScheduler(cpu, ppu, timer)
+	scheduler := scheduler.NewScheduler(cpu, mmu, ppu, timer)
 	cpu.SkipBootROM()
 
 	const maxCycles = 80_000_000
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"garboy/cartridge"
+	"garboy/romdb"
+)
+
+func romHashes(rom []byte) (string, string) {
+	sum := sha1.Sum(rom)
+	return fmt.Sprintf("%08X", crc32.ChecksumIEEE(rom)), hex.EncodeToString(sum[:])
+}
+
+func TestRomDatabase(t *testing.T) {
+	rom, err := os.ReadFile("./test_roms/blargg/instr_timing.gb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crc, sha := romHashes(rom)
+	other := append([]byte(nil), rom...)
+	other[0x200] ^= 0xFF
+	otherCRC, _ := romHashes(other)
+
+	xmlDat := fmt.Sprintf(`<?xml version="1.0"?>
+<datafile>
+	<header><name>Nintendo - Game Boy</name></header>
+	<game name="Instr Timing (World) (Rev 1)">
+		<rom name="Instr Timing (World) (Rev 1).gb" size="%d" crc="%s" sha1="%s"/>
+	</game>
+	<game name="Broken (Japan) (v1.1)">
+		<rom name="Broken (Japan) (v1.1).gb" size="%d" crc="%s" status="baddump"/>
+	</game>
+</datafile>`, len(rom), crc, sha, len(other), otherCRC)
+
+	cmpDat := fmt.Sprintf(`clrmamepro (
+	name "Nintendo - Game Boy"
+)
+
+game (
+	name "Instr Timing (World) (Rev 1)"
+	rom ( name "Instr Timing (World) (Rev 1).gb" size %d crc %s sha1 %s )
+)
+
+game (
+	name "Broken (Japan) (v1.1)"
+	rom ( name "Broken (Japan) (v1.1).gb" size %d crc %s flags baddump )
+)
+`, len(rom), crc, sha, len(other), otherCRC)
+
+	for format, dat := range map[string]string{"XML": xmlDat, "ClrMamePro": cmpDat} {
+		db, err := romdb.Parse([]byte(dat))
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if db.Len() != 2 {
+			t.Errorf("%s: %d entries, want 2", format, db.Len())
+		}
+
+		match, ok := db.Lookup(rom)
+		if !ok || match.Name != "Instr Timing (World) (Rev 1)" || match.Region != "World" ||
+			match.Revision != "Rev 1" || match.BadDump || match.Overdump {
+			t.Errorf("%s: found %+v, %v", format, match, ok)
+		}
+		if match, ok := db.Lookup(other); !ok || !match.BadDump || match.Region != "Japan" || match.Revision != "v1.1" {
+			t.Errorf("%s: bad dump found as %+v, %v", format, match, ok)
+		}
+
+		// Padding after the ROM is an overdump
+		padded := append(append([]byte(nil), rom...), make([]byte, len(rom))...)
+		if match, ok := db.Lookup(padded); !ok || !match.Overdump || match.Size != len(rom) {
+			t.Errorf("%s: overdump found as %+v, %v", format, match, ok)
+		}
+
+		// A ROM missing its last byte matches nothing
+		if _, ok := db.Lookup(rom[:len(rom)-1]); ok {
+			t.Errorf("%s: found a truncated ROM", format)
+		}
+	}
+
+	if _, err := romdb.Parse([]byte(`game ( name "x" rom ( size 1 crc 0 )`)); !errors.Is(err, romdb.ErrBadBlock) {
+		t.Errorf("unbalanced DAT gave %v", err)
+	}
+	if _, err := romdb.Parse([]byte(`<datafile></datafile>`)); !errors.Is(err, romdb.ErrNoRoms) {
+		t.Errorf("empty DAT gave %v", err)
+	}
+}
+
+func TestRomDatabaseQuirks(t *testing.T) {
+	// A 1MB MBC1 ROM without the second logo that multicarts are usually spotted by
+	path := writeTestRom(t, 62, func(rom []byte) { rom[0x147], rom[0x148] = 0x01, 0x05 })
+	rom, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crc, sha := romHashes(rom)
+	db, err := romdb.Parse([]byte(fmt.Sprintf(`<datafile><game name="Mortal Kombat I &amp; II (USA, Europe)">
+		<rom size="%d" crc="%s" sha1="%s"/></game></datafile>`, len(rom), crc, sha)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bank := func(opts cartridge.LoadOptions) byte {
+		cart, err := cartridge.NewCartridgeWith(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cart.Write(0x4000, 0x01)
+		cart.Write(0x2000, 0x01)
+		return cart.Read(0x4000)
+	}
+	if got := bank(cartridge.LoadOptions{}); got != 0x21 {
+		t.Errorf("without the database bank %02X, want 21", got)
+	}
+	if got := bank(cartridge.LoadOptions{Database: db}); got != 0x11 {
+		t.Errorf("with the MBC1M quirk bank %02X, want 11", got)
+	}
+}
+
+func TestRomDatabaseRamSize(t *testing.T) {
+	// An MBC1 game whose header says 8KB of RAM but which uses four 8KB banks
+	path := writeTestRom(t, 0, func(rom []byte) { rom[0x147], rom[0x149] = 0x03, 0x02 })
+	rom, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crc, sha := romHashes(rom)
+	db, err := romdb.Parse([]byte(fmt.Sprintf(`<datafile><game name="Wrong RAM (Japan)">
+		<rom size="%d" crc="%s" sha1="%s"/></game></datafile>`, len(rom), crc, sha)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bank 3 only holds its own data with all 32KB there
+	banks := func(opts cartridge.LoadOptions) (byte, byte) {
+		cart, err := cartridge.NewCartridgeWith(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cart.Write(0x0000, 0x0A)
+		cart.Write(0x6000, 0x01)
+		cart.Write(0x4000, 0x03)
+		cart.Write(0xA000, 0x42)
+		bank3 := cart.Read(0xA000)
+		cart.Write(0x4000, 0x00)
+		return bank3, cart.Read(0xA000)
+	}
+	if bank3, bank0 := banks(cartridge.LoadOptions{Database: db}); bank3 == 0x42 && bank0 != 0x42 {
+		t.Errorf("without a quirk 8KB of RAM held separate banks")
+	}
+
+	if err := db.ParseQuirks([]byte("Wrong RAM ram=32K")); !errors.Is(err, romdb.ErrBadQuirk) {
+		t.Errorf("a line without a colon gave %v", err)
+	}
+	if err := db.ParseQuirks([]byte("# From a user file\n\nWrong RAM: ram=32K\n")); err != nil {
+		t.Fatal(err)
+	}
+	match, ok := db.Lookup(rom)
+	if !ok || match.Quirks().RamSize != 32*1024 {
+		t.Fatalf("found %+v with quirks %+v", match, match.Quirks())
+	}
+	cart, err := cartridge.NewCartridgeWith(path, cartridge.LoadOptions{Database: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.Header().RamSize != 0x02 {
+		t.Errorf("header RAM size changed to %02X", cart.Header().RamSize)
+	}
+	if bank3, bank0 := banks(cartridge.LoadOptions{Database: db}); bank3 != 0x42 || bank0 == 0x42 {
+		t.Errorf("with the quirk banks 3 and 0 read %02X and %02X", bank3, bank0)
+	}
+
+	// The battery save holds all of it
+	cart.Write(0x0000, 0x0A)
+	cart.Write(0xA000, 0x42)
+	if err := cart.Save(); err != nil {
+		t.Fatal(err)
+	}
+	save, err := os.ReadFile(filepath.Join(filepath.Dir(path), "rom.sav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(save) != 32*1024 {
+		t.Errorf("save of %d bytes, want 32KB", len(save))
+	}
+
+	// No header can give more than 128KB
+	db.SetQuirks("Wrong RAM", romdb.Quirks{RamSize: 256 * 1024})
+	if _, err := cartridge.NewCartridgeWith(path, cartridge.LoadOptions{Database: db}); err == nil {
+		t.Error("a 256KB RAM quirk loaded")
+	}
+}
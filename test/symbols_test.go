@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"garboy/symbols"
+)
+
+const testSymbols = `; File generated by rgblink
+00:0150 Main
+00:0160 Main.loop
+01:4000 BankOneInit
+02:4000 BankTwoInit
+02:4010 BankTwoLoop
+00:C000 wBuffer
+`
+
+func TestSymbolLabels(t *testing.T) {
+	table, err := symbols.Parse(strings.NewReader(testSymbols))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		address uint16
+		bank    int
+		want    string
+	}{
+		{0x0150, 1, "Main"},
+		{0x0165, 1, "Main.loop+$5"},
+		{0x4000, 1, "BankOneInit"},
+		{0x4000, 2, "BankTwoInit"},
+		{0x4012, 2, "BankTwoLoop+$2"},
+		{0x4012, 1, "BankOneInit+$12"},
+		{0x4000, symbols.AnyBank, ""},
+		{0xC004, 1, "wBuffer+$4"},
+		{0x0100, 1, ""},
+		{0x8000, 1, ""},
+	}
+
+	for _, tc := range tests {
+		if got := table.Label(tc.address, tc.bank); got != tc.want {
+			t.Errorf("Label(%04X, %d) = %q, want %q", tc.address, tc.bank, got, tc.want)
+		}
+	}
+
+	sym, ok := table.Resolve("BankTwoLoop")
+	if !ok || sym.Bank != 2 || sym.Address != 0x4010 {
+		t.Errorf("Resolve(BankTwoLoop) = %+v, %v", sym, ok)
+	}
+}
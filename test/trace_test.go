@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"garboy/trace"
+)
+
+const (
+	traceLine1 = "A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02"
+	traceLine2 = "A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0101 PCMEM:C3,13,02,CE"
+	traceLine3 = "A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0213 PCMEM:21,00,40,C3"
+)
+
+func TestTraceDiff(t *testing.T) {
+	ours := strings.Join([]string{traceLine1, "[CPU] " + traceLine2 + " (Start)", traceLine3}, "\n")
+	reference := strings.Join([]string{traceLine1, traceLine2, strings.Replace(traceLine3, "A:01", "A:02", 1)}, "\n")
+
+	divergence, err := trace.Diff(strings.NewReader(ours), strings.NewReader(reference), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if divergence == nil {
+		t.Fatal("expected a divergence")
+	}
+	if divergence.Line != 3 || !slices.Equal(divergence.Context, []string{traceLine2}) || !slices.Equal(divergence.Fields(), []string{"A"}) {
+		t.Errorf("unexpected divergence %+v", divergence)
+	}
+
+	divergence, err = trace.Diff(strings.NewReader(traceLine1), strings.NewReader(traceLine1+"\n"+traceLine2), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if divergence == nil || divergence.Line != 2 || divergence.Ours != "" {
+		t.Errorf("expected our trace to end at line 2, got %+v", divergence)
+	}
+}
+
+func TestTraceTriggers(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		start, stop string
+		first, last string
+		lines       int
+	}{
+		{"pc", "pc.log", "pc:0152", "pc:0300", "PC:0152", "PC:0300", 3},
+		{"gzip", "pc.log.gz", "pc:0200", "pc:0155", "PC:0200", "PC:0155", 5},
+		{"frame", "frame.log", "frame:1", "pc:0156", "", "PC:0156", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, _, ppu, scheduler := newLoopSystem(t)
+			start, err := trace.ParseTrigger(tt.start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stop, err := trace.ParseTrigger(tt.stop)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), tt.path)
+			logger, err := trace.NewLogger(path, false, cpu, ppu, start, stop)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var startFrame uint64
+			for i := 0; i < 1_000_000 && !logger.Done(); i++ {
+				if logger.Lines() == 0 {
+					startFrame = ppu.Frames()
+				}
+				scheduler.Step()
+			}
+			if err := logger.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !logger.Done() {
+				t.Fatal("the stop trigger never fired")
+			}
+
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gzipped := bytes.HasPrefix(raw, []byte{0x1F, 0x8B}); gzipped != strings.HasSuffix(path, ".gz") {
+				t.Errorf("gzipped is %v for %s", gzipped, tt.path)
+			}
+
+			f, err := trace.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			data, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if tt.lines > 0 && len(lines) != tt.lines {
+				t.Errorf("%d lines, want %d", len(lines), tt.lines)
+			}
+			if !strings.Contains(lines[0], tt.first) || !strings.Contains(lines[len(lines)-1], tt.last) {
+				t.Errorf("traced from %q to %q, want %s to %s", lines[0], lines[len(lines)-1], tt.first, tt.last)
+			}
+			if tt.start == "frame:1" && startFrame < 1 {
+				t.Errorf("tracing started in frame %d", startFrame)
+			}
+		})
+	}
+}
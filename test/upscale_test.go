@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"garboy/addresses"
+	"garboy/display"
+	"garboy/interrupts"
+)
+
+func TestUpscalers(t *testing.T) {
+	// Black below the diagonal, so every step of the staircase should get its corner cut
+	var frame [display.ScreenHeight][display.ScreenWidth]display.Color
+	for y := range frame {
+		for x := range frame[y] {
+			if x < y {
+				frame[y][x] = 3
+			}
+		}
+	}
+
+	for _, u := range display.Upscalers() {
+		var out display.UpscaledFrame
+		u.Apply(&frame, &out)
+		if out.Width != display.ScreenWidth*u.Factor() || out.Height != display.ScreenHeight*u.Factor() {
+			t.Errorf("%v: upscaled to %dx%d", u, out.Width, out.Height)
+		}
+		if out.At(0, out.Height-1) != 3 || out.At(out.Width-1, 0) != 0 {
+			t.Errorf("%v: corners changed color", u)
+		}
+	}
+
+	// The top right corner of the black pixel at 4,5 is next to white on both sides
+	for _, u := range []display.Upscaler{display.Scale2x, display.XbrLite} {
+		var out display.UpscaledFrame
+		u.Apply(&frame, &out)
+		got := [4]display.Color{out.At(8, 10), out.At(9, 10), out.At(8, 11), out.At(9, 11)}
+		if want := [4]display.Color{3, 0, 3, 3}; got != want {
+			t.Errorf("%v: pixel 4,5 became %v, want %v", u, got, want)
+		}
+	}
+
+	if u, err := display.ParseUpscaler("Scale3x"); err != nil || u != display.Scale3x {
+		t.Errorf("ParseUpscaler(Scale3x) = %v, %v", u, err)
+	}
+	if _, err := display.ParseUpscaler("hq2x"); err == nil {
+		t.Error("expected an error for an unknown upscaler")
+	}
+}
+
+func TestUpscaledCaptures(t *testing.T) {
+	ppu := display.NewPPU(interrupts.NewInterrupts())
+	ppu.Write(addresses.BgPalette, 0xFF)
+	for ppu.Frames() < 2 {
+		ppu.Step(4)
+	}
+
+	display.SetUpscaler(display.Scale4x)
+	defer display.SetUpscaler(display.NoUpscaler)
+
+	if shot := ppu.Screenshot(2); shot.Bounds().Dx() != 1280 || shot.Bounds().Dy() != 1152 {
+		t.Errorf("screenshot is %v, want 1280x1152", shot.Bounds())
+	}
+
+	filter := display.NewPostFilter(3, display.FilterOptions{Grid: true})
+	var frame [display.ScreenHeight][display.ScreenWidth]display.Color
+	if img := filter.Apply(&frame); img.Bounds().Dx() != 640 {
+		t.Errorf("filtered frame is %v, want the upscaled 640x576", img.Bounds())
+	}
+}
+
+func BenchmarkUpscalers(b *testing.B) {
+	var frame [display.ScreenHeight][display.ScreenWidth]display.Color
+	for y := range frame {
+		for x := range frame[y] {
+			frame[y][x] = display.Color((x ^ y) & 3)
+		}
+	}
+
+	for _, u := range display.Upscalers()[1:] {
+		b.Run(u.String(), func(b *testing.B) {
+			var out display.UpscaledFrame
+			for i := 0; i < b.N; i++ {
+				u.Apply(&frame, &out)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package trace
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// First instruction where two traces disagree
+type Divergence struct {
+	Line      int      // 1-based line number in both traces
+	Ours      string   // "" when our trace ended first
+	Reference string   // "" when the reference ended first
+	Context   []string // Matching lines right before the divergence, oldest first
+}
+
+// Opens a trace file, decompressing it when it starts with the gzip magic bytes
+func Open(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(file)
+	magic, _ := br.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1F && magic[1] == 0x8B {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return readCloser{gz, file}, nil
+	}
+	return readCloser{br, file}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Compares two Gameboy Doctor logs line by line and returns the first divergence with up to
+// context lines before it, or nil when they match
+func Diff(ours, reference io.Reader, context int) (*Divergence, error) {
+	a := bufio.NewScanner(ours)
+	b := bufio.NewScanner(reference)
+	var recent []string
+
+	for line := 1; ; line++ {
+		okA := a.Scan()
+		okB := b.Scan()
+		if err := a.Err(); err != nil {
+			return nil, err
+		}
+		if err := b.Err(); err != nil {
+			return nil, err
+		}
+		if !okA && !okB {
+			return nil, nil
+		}
+
+		lineA, lineB := "", ""
+		if okA {
+			lineA = normalize(a.Text())
+		}
+		if okB {
+			lineB = normalize(b.Text())
+		}
+
+		if lineA != lineB {
+			return &Divergence{Line: line, Ours: lineA, Reference: lineB, Context: recent}, nil
+		}
+
+		if context > 0 {
+			if len(recent) == context {
+				recent = recent[1:]
+			}
+			recent = append(recent, lineA)
+		}
+	}
+}
+
+// Accepts CPU.PrintState output as well as plain Gameboy Doctor lines
+func normalize(line string) string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "[CPU] ")
+	if i := strings.Index(line, " ("); i >= 0 {
+		line = line[:i]
+	}
+	return line
+}
+
+// Fields such as "A" or "PC" that differ between the two lines
+func (d *Divergence) Fields() []string {
+	ours := fields(d.Ours)
+	reference := fields(d.Reference)
+
+	var res []string
+	for _, name := range []string{"A", "F", "B", "C", "D", "E", "H", "L", "SP", "PC", "PCMEM"} {
+		if ours[name] != reference[name] {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+func fields(line string) map[string]string {
+	res := make(map[string]string)
+	for _, field := range strings.Fields(line) {
+		if name, value, ok := strings.Cut(field, ":"); ok {
+			res[name] = value
+		}
+	}
+	return res
+}
+
+func (d *Divergence) Write(w io.Writer) {
+	fmt.Fprintf(w, "Traces diverge at line %d\n", d.Line)
+	for i, line := range d.Context {
+		fmt.Fprintf(w, "  %8d  %s\n", d.Line-len(d.Context)+i, line)
+	}
+
+	ours, reference := d.Ours, d.Reference
+	if ours == "" {
+		ours = "<end of trace>"
+	}
+	if reference == "" {
+		reference = "<end of trace>"
+	}
+	fmt.Fprintf(w, "- %8d  %s\n", d.Line, reference)
+	fmt.Fprintf(w, "+ %8d  %s\n", d.Line, ours)
+
+	if d.Ours != "" && d.Reference != "" {
+		fmt.Fprintf(w, "Mismatched: %s\n", strings.Join(d.Fields(), ", "))
+	}
+}
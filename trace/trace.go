@@ -0,0 +1,174 @@
+package trace
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"garboy/cpu"
+	"garboy/display"
+)
+
+type TriggerKind int
+
+const (
+	TriggerNone TriggerKind = iota
+	TriggerPC
+	TriggerFrame
+)
+
+// Starts or stops tracing when PC reaches an address or after a number of frames
+type Trigger struct {
+	Kind  TriggerKind
+	PC    uint16
+	Frame uint64
+}
+
+// Parses "pc:0150" (hex) or "frame:60". An empty string is TriggerNone
+func ParseTrigger(s string) (Trigger, error) {
+	if s == "" {
+		return Trigger{}, nil
+	}
+
+	kind, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return Trigger{}, fmt.Errorf("invalid trigger %q, want pc:<hex> or frame:<n>", s)
+	}
+
+	switch kind {
+	case "pc":
+		pc, err := strconv.ParseUint(strings.TrimPrefix(value, "$"), 16, 16)
+		if err != nil {
+			return Trigger{}, fmt.Errorf("invalid trigger PC %q", value)
+		}
+		return Trigger{Kind: TriggerPC, PC: uint16(pc)}, nil
+	case "frame":
+		frame, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return Trigger{}, fmt.Errorf("invalid trigger frame %q", value)
+		}
+		return Trigger{Kind: TriggerFrame, Frame: frame}, nil
+	default:
+		return Trigger{}, fmt.Errorf("invalid trigger %q, want pc:<hex> or frame:<n>", s)
+	}
+}
+
+func (t Trigger) matches(pc uint16, frame uint64) bool {
+	switch t.Kind {
+	case TriggerPC:
+		return pc == t.PC
+	case TriggerFrame:
+		return frame >= t.Frame
+	default:
+		return false
+	}
+}
+
+// Writes one Gameboy Doctor line per executed instruction between the start and stop triggers
+type Logger struct {
+	cpu *cpu.CPU
+	ppu *display.PPU
+
+	start Trigger
+	stop  Trigger
+
+	file *os.File
+	gz   *gzip.Writer
+	w    *bufio.Writer
+
+	active bool
+	done   bool
+	lines  uint64
+	err    error
+}
+
+// Creates the log at path, gzipped when compress is set or path ends in .gz, and hooks it into
+// the CPU. Without a start trigger logging begins straight away
+func NewLogger(path string, compress bool, cpu *cpu.CPU, ppu *display.PPU, start, stop Trigger) (*Logger, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Logger{
+		cpu:    cpu,
+		ppu:    ppu,
+		start:  start,
+		stop:   stop,
+		file:   file,
+		active: start.Kind == TriggerNone,
+	}
+
+	var out io.Writer = file
+	if compress || strings.HasSuffix(path, ".gz") {
+		l.gz = gzip.NewWriter(file)
+		out = l.gz
+	}
+	l.w = bufio.NewWriterSize(out, 1<<16)
+
+	cpu.SetTracer(l.trace)
+	return l, nil
+}
+
+func (l *Logger) trace(c *cpu.CPU) {
+	if l.done {
+		return
+	}
+
+	_, _, _, _, _, _, _, _, _, pc := c.GetState()
+	frame := l.ppu.Frames()
+	if !l.active {
+		if !l.start.matches(pc.Read(), frame) {
+			return
+		}
+		l.active = true
+	}
+
+	if err := c.WriteState(l.w); err != nil {
+		l.err = err
+		l.Close()
+		return
+	}
+	l.lines++
+
+	// The stop instruction is logged so PC triggers can bracket a routine
+	if l.stop.matches(pc.Read(), frame) {
+		l.Close()
+	}
+}
+
+// Number of instructions logged so far
+func (l *Logger) Lines() uint64 {
+	return l.lines
+}
+
+// True once the stop trigger has fired or the logger was closed
+func (l *Logger) Done() bool {
+	return l.done
+}
+
+// Flushes and closes the log. Safe to call more than once
+func (l *Logger) Close() error {
+	if l.done {
+		return l.err
+	}
+	l.done = true
+	l.cpu.SetTracer(nil)
+
+	if err := l.w.Flush(); err != nil && l.err == nil {
+		l.err = err
+	}
+	if l.gz != nil {
+		if err := l.gz.Close(); err != nil && l.err == nil {
+			l.err = err
+		}
+	}
+	if err := l.file.Close(); err != nil && l.err == nil {
+		l.err = err
+	}
+	return l.err
+}